@@ -1,5 +1,10 @@
 package common
 
+import (
+	"encoding/json"
+	"time"
+)
+
 // Overview main
 type Overview struct {
 	NodeStatus NodeStatus `json:"node_status"`
@@ -12,9 +17,61 @@ type NodeStatus struct {
 }
 
 type PodStatus struct {
-	Error   []string `json:"error"`
-	Pending []string `json:"pending"`
-	Running int      `json:"running"`
+	Error    []string          `json:"error"`
+	Pending  []string          `json:"pending"`
+	Running  int               `json:"running"`
+	Problems []PodProblemGroup `json:"problems"`
+}
+
+// Pods with the same problem
+type PodProblemGroup struct {
+	Reason string       `json:"reason"`
+	Pods   []PodProblem `json:"pods"`
+}
+
+type PodProblem struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Owner     string `json:"owner"`
+	Container string `json:"container"`
+	Reason    string `json:"reason"` // CrashLoopBackOff, OOMKilled, ImagePullBackOff, ContainerConfigError, Error, Evicted, Failed or Unknown
+	Message   string `json:"message"`
+	ExitCode  int32  `json:"exit_code"`
+	Restarts  int32  `json:"restarts"`
+}
+
+// Cluster capacity (cpu in millicores, memory in MiB)
+type ClusterCapacity struct {
+	Cpu      ResourceCapacity   `json:"cpu"`
+	Memory   ResourceCapacity   `json:"memory"`
+	Pods     ResourceCapacity   `json:"pods"`
+	Forecast []CapacityForecast `json:"forecast"`
+}
+
+type ResourceCapacity struct {
+	Capacity    int64 `json:"capacity"`
+	Allocatable int64 `json:"allocatable"`
+	Requested   int64 `json:"requested"`
+	Used        int64 `json:"used"`
+}
+
+// Trend of requested resources per node pool, an empty pool is the whole cluster
+type CapacityForecast struct {
+	Pool             string  `json:"pool"`
+	CpuTrend         float64 `json:"cpu_trend"`    // requested millicores per day
+	MemoryTrend      float64 `json:"memory_trend"` // requested MiB per day
+	CpuExhaustion    string  `json:"cpu_exhaustion"`
+	MemoryExhaustion string  `json:"memory_exhaustion"`
+}
+
+// Stored history of allocatable and requested resources per node pool
+type CapacitySnapshot struct {
+	Pool           string    `json:"pool"`
+	CpuAllocatable int64     `json:"cpu_allocatable"`
+	CpuRequested   int64     `json:"cpu_requested"`
+	RamAllocatable int64     `json:"ram_allocatable"`
+	RamRequested   int64     `json:"ram_requested"`
+	Timestamp      time.Time `json:"timestamp"`
 }
 
 // Event
@@ -54,6 +111,144 @@ type NodeInfo struct {
 	CpuCores                int64  `json:"cpu_cores"`
 	RamCapacity             int64  `json:"ram_capacity"`
 	Status                  bool   `json:"status"`
+
+	Conditions    []NodeCondition `json:"conditions"`
+	Taints        []string        `json:"taints"`
+	Labels        []string        `json:"labels"`
+	Unschedulable bool            `json:"unschedulable"`
+	HeartbeatAge  int64           `json:"heartbeat_age"` // seconds since the node lease was last renewed, -1 if unknown
+}
+
+type NodeCondition struct {
+	Type               string `json:"type"`
+	Status             string `json:"status"`
+	Reason             string `json:"reason"`
+	Message            string `json:"message"`
+	LastTransitionTime string `json:"last_transition_time"`
+}
+
+// Stored history of node condition transitions
+type NodeConditionTransition struct {
+	Name      string    `json:"name"`
+	Type      string    `json:"type"`
+	Status    string    `json:"status"`
+	Reason    string    `json:"reason"`
+	Message   string    `json:"message"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type NodeFlap struct {
+	Type           string    `json:"type" bson:"_id"`
+	Transitions    int       `json:"transitions"`
+	LastTransition time.Time `json:"last_transition" bson:"lasttransition"`
+}
+
+// Pod with its resource footprint (cpu in millicores, ram in MiB)
+type PodResource struct {
+	Name        string `json:"name"`
+	Namespace   string `json:"namespace"`
+	Phase       string `json:"phase"`
+	Owner       string `json:"owner"`
+	Restarts    int32  `json:"restarts"`
+	CpuRequests int64  `json:"cpu_requests"`
+	RamRequests int64  `json:"ram_requests"`
+	CpuLimits   int64  `json:"cpu_limits"`
+	RamLimits   int64  `json:"ram_limits"`
+	CpuUsage    int64  `json:"cpu_usage"`
+	RamUsage    int64  `json:"ram_usage"`
+}
+
+// Resource aggregates of a namespace (cpu in millicores, ram in MiB)
+type NamespaceUsage struct {
+	Namespace    string               `json:"namespace"`
+	Pods         map[string]int       `json:"pods"` // number of pods per phase
+	CpuRequests  int64                `json:"cpu_requests"`
+	RamRequests  int64                `json:"ram_requests"`
+	CpuLimits    int64                `json:"cpu_limits"`
+	RamLimits    int64                `json:"ram_limits"`
+	CpuUsage     int64                `json:"cpu_usage"`
+	RamUsage     int64                `json:"ram_usage"`
+	Quotas       []ResourceQuotaUsage `json:"quotas,omitempty"`
+	LimitRanges  []LimitRangeDefault  `json:"limit_ranges,omitempty"`
+	TopConsumers []PodResource        `json:"top_consumers,omitempty"`
+}
+
+type ResourceQuotaUsage struct {
+	Name     string `json:"name"`
+	Resource string `json:"resource"`
+	Hard     string `json:"hard"`
+	Used     string `json:"used"`
+}
+
+type LimitRangeDefault struct {
+	Name           string `json:"name"`
+	Type           string `json:"type"`
+	Resource       string `json:"resource"`
+	Default        string `json:"default"`
+	DefaultRequest string `json:"default_request"`
+	Min            string `json:"min"`
+	Max            string `json:"max"`
+}
+
+// Stored history of the resource consumption of a namespace
+type NamespaceUsageSnapshot struct {
+	Namespace   string    `json:"namespace"`
+	Pods        int       `json:"pods"`
+	CpuRequests int64     `json:"cpu_requests"`
+	RamRequests int64     `json:"ram_requests"`
+	CpuLimits   int64     `json:"cpu_limits"`
+	RamLimits   int64     `json:"ram_limits"`
+	CpuUsage    int64     `json:"cpu_usage"`
+	RamUsage    int64     `json:"ram_usage"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// Stored footprint of a scheduled pod, used for cost allocation (cpu in millicores, ram in MiB)
+type PodUsageSnapshot struct {
+	Name        string    `json:"name"`
+	Namespace   string    `json:"namespace"`
+	Node        string    `json:"node"`
+	NodeType    string    `json:"node_type"`
+	Labels      []string  `json:"labels"`
+	CpuRequests int64     `json:"cpu_requests"`
+	RamRequests int64     `json:"ram_requests"`
+	CpuUsage    int64     `json:"cpu_usage"`
+	RamUsage    int64     `json:"ram_usage"`
+	Hours       float64   `json:"hours"` // length of the interval covered by the snapshot
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// Stored allocatable resources of a node, used to compute the idle cost
+type NodeCapacitySnapshot struct {
+	Name           string    `json:"name"`
+	NodeType       string    `json:"node_type"`
+	CpuAllocatable int64     `json:"cpu_allocatable"`
+	RamAllocatable int64     `json:"ram_allocatable"`
+	Hours          float64   `json:"hours"`
+	Timestamp      time.Time `json:"timestamp"`
+}
+
+// Price of a node type
+type NodePrice struct {
+	CpuHour float64 `json:"cpu_hour"`
+	GiBHour float64 `json:"gib_hour"`
+}
+
+type CostReport struct {
+	From    string     `json:"from"`
+	To      string     `json:"to"`
+	GroupBy string     `json:"group_by"`
+	Lines   []CostLine `json:"lines"`
+	Total   float64    `json:"total"`
+}
+
+type CostLine struct {
+	Name     string  `json:"name"`
+	CpuHours float64 `json:"cpu_hours"`
+	GiBHours float64 `json:"gib_hours"`
+	CpuCost  float64 `json:"cpu_cost"`
+	RamCost  float64 `json:"ram_cost"`
+	Cost     float64 `json:"cost"`
 }
 
 type ControllerOverview struct {
@@ -77,6 +272,445 @@ type ControllerInfo struct {
 	ControlledBy string   `json:"controlled_by"`
 }
 
+// Stored rollout of a Deployment revision
+type Rollout struct {
+	Namespace         string    `json:"namespace"`
+	Name              string    `json:"name"`
+	Revision          string    `json:"revision"`
+	Images            []string  `json:"images"`
+	PreviousImages    []string  `json:"previous_images"`
+	Status            string    `json:"status"` // progressing, complete or stalled
+	Replicas          int32     `json:"replicas"`
+	UpdatedReplicas   int32     `json:"updated_replicas"`
+	AvailableReplicas int32     `json:"available_replicas"`
+	StartTime         time.Time `json:"start_time"`
+	EndTime           time.Time `json:"end_time"`
+}
+
+type Revision struct {
+	Revision    string    `json:"revision"`
+	ReplicaSet  string    `json:"replica_set"`
+	Images      []string  `json:"images"`
+	ChangeCause string    `json:"change_cause"`
+	Replicas    int32     `json:"replicas"`
+	Created     time.Time `json:"created"`
+}
+
+type RevisionHistory struct {
+	Revisions []Revision    `json:"revisions"`
+	Diff      []FieldChange `json:"diff,omitempty"` // pod template changes between two revisions
+}
+
+// Change of a single field, values are JSON encoded
+type FieldChange struct {
+	Path string `json:"path"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Recorded spec change of a workload, ConfigMap, Service or node
+type Change struct {
+	Kind       string        `json:"kind"`
+	Namespace  string        `json:"namespace"`
+	Name       string        `json:"name"`
+	Generation int64         `json:"generation"`
+	Summary    string        `json:"summary"`
+	Diff       []FieldChange `json:"diff"`
+	Truncated  bool          `json:"truncated"` // more fields changed than the diff holds
+	Timestamp  time.Time     `json:"timestamp"`
+}
+
+// Stored Warning event, kept after the event expires in the API server
+type WarningEvent struct {
+	Uid       string    `json:"uid"`
+	Kind      string    `json:"kind"` // of the involved object
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	Reason    string    `json:"reason"`
+	Message   string    `json:"message"`
+	Count     int32     `json:"count"`
+	Timestamp time.Time `json:"timestamp"` // last seen
+}
+
+// Change, Warning event or alert transition on the timeline
+type TimelineEntry struct {
+	Time      time.Time     `json:"time"`
+	Source    string        `json:"source"` // change, event or alert
+	Kind      string        `json:"kind"`
+	Namespace string        `json:"namespace"`
+	Name      string        `json:"name"`
+	Reason    string        `json:"reason"` // event reason or alert rule and state
+	Message   string        `json:"message"`
+	Diff      []FieldChange `json:"diff,omitempty"`
+}
+
+// Dependency graph of the objects of a namespace
+type Topology struct {
+	Nodes []TopologyNode `json:"nodes"`
+	Edges []TopologyEdge `json:"edges"`
+}
+
+type TopologyNode struct {
+	Id        string `json:"id"` // Kind/namespace/name
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Status    string `json:"status"` // healthy, warning, error, missing or unknown
+	Message   string `json:"message"`
+}
+
+type TopologyEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Type string `json:"type"` // routes, selects, owns, mounts, uses or scales
+}
+
+type ServiceOverview struct {
+	Namespace         string   `json:"namespace"`
+	Name              string   `json:"name"`
+	Type              string   `json:"type"`
+	ClusterIP         string   `json:"cluster_ip"`
+	Ports             []string `json:"ports"`
+	Selector          []string `json:"selector"`
+	ReadyEndpoints    int      `json:"ready_endpoints"`
+	NotReadyEndpoints int      `json:"not_ready_endpoints"`
+	Problem           string   `json:"problem"` // NoReadyEndpoints or SelectorMatchesNothing, empty if none
+}
+
+type ServiceDetail struct {
+	ServiceOverview
+	Endpoints []EndpointInfo `json:"endpoints"`
+	Pods      []string       `json:"pods"`      // pods matched by the selector
+	Ingresses []string       `json:"ingresses"` // ingresses routing to the service
+}
+
+type EndpointInfo struct {
+	Addresses []string `json:"addresses"`
+	Ready     bool     `json:"ready"`
+	Pod       string   `json:"pod"`
+	Node      string   `json:"node"`
+	Ports     []string `json:"ports"`
+}
+
+type IngressOverview struct {
+	Namespace string        `json:"namespace"`
+	Name      string        `json:"name"`
+	Class     string        `json:"class"`
+	Hosts     []string      `json:"hosts"`
+	Addresses []string      `json:"addresses"`
+	Backends  []IngressPath `json:"backends"`
+	TLS       []string      `json:"tls"`     // secrets of the TLS hosts
+	Problem   string        `json:"problem"` // MissingBackend or NoReadyBackend, empty if none
+}
+
+// Backend of a path of an ingress, the default backend has no host and path
+type IngressPath struct {
+	Host     string `json:"host"`
+	Path     string `json:"path"`
+	PathType string `json:"path_type"`
+	Service  string `json:"service"`
+	Port     string `json:"port"`
+	Resource string `json:"resource"` // Kind/name of a resource backend
+	Problem  string `json:"problem"`
+}
+
+type PVCInfo struct {
+	Namespace    string       `json:"namespace"`
+	Name         string       `json:"name"`
+	Status       string       `json:"status"` // Pending, Bound or Lost
+	Requested    string       `json:"requested"`
+	Capacity     string       `json:"capacity"`
+	StorageClass string       `json:"storage_class"`
+	AccessModes  []string     `json:"access_modes"`
+	Volume       string       `json:"volume"` // bound PV
+	Pods         []string     `json:"pods"`   // pods mounting the claim
+	Usage        *VolumeUsage `json:"usage,omitempty"`
+	Problem      string       `json:"problem"` // Pending, Lost or VolumeFull, empty if none
+	Message      string       `json:"message"`
+}
+
+type PVInfo struct {
+	Name          string   `json:"name"`
+	Phase         string   `json:"phase"`
+	Capacity      string   `json:"capacity"`
+	StorageClass  string   `json:"storage_class"`
+	AccessModes   []string `json:"access_modes"`
+	ReclaimPolicy string   `json:"reclaim_policy"`
+	Claim         string   `json:"claim"` // namespace/name of the bound claim
+	Reason        string   `json:"reason"`
+}
+
+// Volume stats reported by the kubelet
+type VolumeUsage struct {
+	Node              string  `json:"node"`
+	UsedBytes         int64   `json:"used_bytes"`
+	AvailableBytes    int64   `json:"available_bytes"`
+	CapacityBytes     int64   `json:"capacity_bytes"`
+	UsedPercent       float64 `json:"used_percent"`
+	InodesUsed        int64   `json:"inodes_used"`
+	InodesFree        int64   `json:"inodes_free"`
+	Inodes            int64   `json:"inodes"`
+	InodesUsedPercent float64 `json:"inodes_used_percent"`
+}
+
+type AutoscalerInfo struct {
+	Namespace       string             `json:"namespace"`
+	Name            string             `json:"name"`
+	Target          string             `json:"target"` // Kind/name of the scaled workload
+	MinReplicas     int32              `json:"min_replicas"`
+	MaxReplicas     int32              `json:"max_replicas"`
+	CurrentReplicas int32              `json:"current_replicas"`
+	DesiredReplicas int32              `json:"desired_replicas"`
+	Metrics         []AutoscalerMetric `json:"metrics"`
+	Conditions      []Conditions       `json:"conditions"` // AbleToScale, ScalingActive and ScalingLimited
+	LastScaleTime   string             `json:"last_scale_time"`
+}
+
+type AutoscalerMetric struct {
+	Type    string `json:"type"` // Resource, ContainerResource, Pods, Object or External
+	Name    string `json:"name"`
+	Current string `json:"current"`
+	Target  string `json:"target"`
+}
+
+// Stored change of the desired replicas of an autoscaler
+type ScaleEvent struct {
+	Namespace string             `json:"namespace"`
+	Name      string             `json:"name"`
+	Target    string             `json:"target"`
+	From      int32              `json:"from"`
+	To        int32              `json:"to"`
+	Reason    string             `json:"reason"`
+	Metrics   []AutoscalerMetric `json:"metrics"` // metrics at the time of the scaling
+	Timestamp time.Time          `json:"timestamp"`
+}
+
+type ScalingHistory struct {
+	Autoscaler AutoscalerInfo       `json:"autoscaler"`
+	Events     []ScaleEvent         `json:"events"`
+	Usage      []WorkloadUsagePoint `json:"usage"`
+}
+
+// Usage of the pods of a workload at one point in time, cpu in millicores
+type WorkloadUsagePoint struct {
+	Timestamp   time.Time `json:"timestamp"`
+	Pods        int       `json:"pods"`
+	CpuUsage    int64     `json:"cpu_usage"`
+	CpuRequests int64     `json:"cpu_requests"`
+}
+
+// Stored run of a Job, durations in seconds
+type JobRun struct {
+	Namespace      string    `json:"namespace"`
+	Name           string    `json:"name"`
+	Uid            string    `json:"uid"`
+	CronJob        string    `json:"cronjob"` // empty if the job was not created by a CronJob
+	Status         string    `json:"status"`  // running, succeeded or failed
+	StartTime      time.Time `json:"start_time"`
+	CompletionTime time.Time `json:"completion_time"`
+	Duration       int64     `json:"duration"`
+	Active         int32     `json:"active"`
+	Succeeded      int32     `json:"succeeded"`
+	Failed         int32     `json:"failed"`
+	Reason         string    `json:"reason"` // failure reason, e.g. BackoffLimitExceeded
+	Message        string    `json:"message"`
+}
+
+type CronJobInfo struct {
+	Namespace          string   `json:"namespace"`
+	Name               string   `json:"name"`
+	Schedule           string   `json:"schedule"`
+	Suspended          bool     `json:"suspended"`
+	ConcurrencyPolicy  string   `json:"concurrency_policy"`
+	LastScheduleTime   string   `json:"last_schedule_time"`
+	LastSuccessfulTime string   `json:"last_successful_time"`
+	NextScheduleTime   string   `json:"next_schedule_time"`
+	ActiveRuns         []string `json:"active_runs"`
+	MissedSchedules    int      `json:"missed_schedules"`   // schedules which did not start a run since the last one
+	IntervalsSinceOk   int      `json:"intervals_since_ok"` // schedules since the last successful run
+	OverlappingRuns    int      `json:"overlapping_runs"`   // recorded runs which started before the previous one ended
+	Runs               []JobRun `json:"runs,omitempty"`
+}
+
+type SecurityReport struct {
+	Scores    []NamespaceScore   `json:"scores"`
+	Workloads []WorkloadFindings `json:"workloads"`
+}
+
+// Score out of 100 of the workloads of a namespace, lowered by the severity of their findings
+type NamespaceScore struct {
+	Namespace string         `json:"namespace"`
+	Score     int            `json:"score"`
+	Workloads int            `json:"workloads"`
+	Findings  map[string]int `json:"findings"` // number of findings by severity
+}
+
+type WorkloadFindings struct {
+	Namespace string            `json:"namespace"`
+	Kind      string            `json:"kind"`
+	Name      string            `json:"name"`
+	Severity  string            `json:"severity"` // highest severity of the findings
+	Findings  []SecurityFinding `json:"findings"`
+}
+
+type SecurityFinding struct {
+	Check     string `json:"check"`
+	Severity  string `json:"severity"` // critical, high, medium or low
+	Container string `json:"container"`
+	Message   string `json:"message"`
+}
+
+type LintReport struct {
+	Namespaces []NamespaceLint `json:"namespaces"`
+	Workloads  []WorkloadLint  `json:"workloads"`
+}
+
+type NamespaceLint struct {
+	Namespace string         `json:"namespace"`
+	Workloads int            `json:"workloads"`
+	Passing   int            `json:"passing"` // workloads without findings
+	Waived    int            `json:"waived"`
+	Findings  map[string]int `json:"findings"` // number of findings by rule
+}
+
+type WorkloadLint struct {
+	Namespace string        `json:"namespace"`
+	Kind      string        `json:"kind"`
+	Name      string        `json:"name"`
+	Findings  []LintFinding `json:"findings"`
+	Waived    []string      `json:"waived,omitempty"` // rules waived by the annotation of the workload
+}
+
+type LintFinding struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+type LintRule struct {
+	Name     string          `json:"name"`
+	Severity string          `json:"severity"`
+	Message  string          `json:"message"`
+	Kinds    []string        `json:"kinds,omitempty"`   // all workload kinds if empty
+	When     []LintCondition `json:"when,omitempty"`    // the rule only applies if every condition holds
+	Require  []LintCondition `json:"require,omitempty"` // a finding is reported unless every condition holds
+	BuiltIn  bool            `json:"built_in"`
+}
+
+// Condition on the values at a path of the workload object, e.g. spec.template.spec.containers[*].image
+type LintCondition struct {
+	Path    string   `json:"path"`
+	Exists  *bool    `json:"exists,omitempty"`
+	Equals  *string  `json:"equals,omitempty"`
+	OneOf   []string `json:"one_of,omitempty"`
+	Matches string   `json:"matches,omitempty"` // regular expression
+	Min     Quantity `json:"min,omitempty"`
+	Max     Quantity `json:"max,omitempty"`
+}
+
+// Number or quantity like 500m or 128Mi, written either as a number or a string
+type Quantity string
+
+func (q *Quantity) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var value string
+		if err := json.Unmarshal(data, &value); err != nil {
+			return err
+		}
+		*q = Quantity(value)
+		return nil
+	}
+	*q = Quantity(data)
+	return nil
+}
+
+type DeprecationReport struct {
+	ServerVersion string             `json:"server_version"`
+	TargetVersion string             `json:"target_version"`
+	Objects       []DeprecatedObject `json:"objects"`
+}
+
+// Object last applied or managed with an API version which is deprecated or removed in the target version
+type DeprecatedObject struct {
+	Namespace    string   `json:"namespace"`
+	Kind         string   `json:"kind"`
+	Name         string   `json:"name"`
+	ApiVersion   string   `json:"api_version"`
+	Sources      []string `json:"sources"` // last-applied-configuration or the managers of the managedFields
+	DeprecatedIn string   `json:"deprecated_in"`
+	RemovedIn    string   `json:"removed_in"`
+	Replacement  string   `json:"replacement"`
+	Breaks       bool     `json:"breaks"` // the API version is removed in the target version
+}
+
+// Certificate of a kubernetes.io/tls Secret, the key is never read
+type CertificateInfo struct {
+	Namespace string   `json:"namespace"`
+	Secret    string   `json:"secret"`
+	Subject   string   `json:"subject"`
+	Issuer    string   `json:"issuer"`
+	SANs      []string `json:"sans"`
+	NotBefore string   `json:"not_before"`
+	NotAfter  string   `json:"not_after"`
+	DaysLeft  int      `json:"days_left"`
+	Status    string   `json:"status"` // valid, expiring, expired or invalid
+	Message   string   `json:"message,omitempty"`
+	Ingresses []string `json:"ingresses"`
+}
+
+// User allowed to run write operations, identified by the bearer token of the request
+type User struct {
+	Name       string   `json:"name"`
+	Token      string   `json:"token"`
+	Namespaces []string `json:"namespaces,omitempty"` // all namespaces if empty
+	Roles      []string `json:"roles,omitempty"`      // e.g. exec, needed for the interactive sessions
+}
+
+// Record of a write operation, including dry runs and failures. Records are never updated
+type AuditRecord struct {
+	Id        string            `json:"id"`
+	User      string            `json:"user"`
+	Action    string            `json:"action"`
+	Kind      string            `json:"kind"`
+	Namespace string            `json:"namespace"`
+	Name      string            `json:"name"`
+	DryRun    bool              `json:"dry_run"`
+	Before    map[string]string `json:"before"`
+	After     map[string]string `json:"after"`
+	Error     string            `json:"error,omitempty"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+// Drain of a node running in the background, pods are namespace/name
+type Drain struct {
+	Id                 string         `json:"id"`
+	Node               string         `json:"node"`
+	User               string         `json:"user"`
+	DryRun             bool           `json:"dry_run"`
+	GracePeriod        int64          `json:"grace_period"` // seconds, -1 for the grace period of every pod
+	Timeout            string         `json:"timeout"`
+	Force              bool           `json:"force"`                // evict the pods without a controller
+	DeleteEmptyDirData bool           `json:"delete_emptydir_data"` // evict the pods with emptyDir volumes
+	Status             string         `json:"status"`               // running, completed or failed
+	Evicted            []string       `json:"evicted"`
+	Skipped            []string       `json:"skipped"` // DaemonSet, mirror, finished pods and the ones not forced
+	Failed             []DrainFailure `json:"failed"`
+	Progress           []DrainEvent   `json:"progress"`
+	Started            time.Time      `json:"started"`
+	Finished           time.Time      `json:"finished,omitempty"`
+}
+
+type DrainFailure struct {
+	Pod    string `json:"pod"`
+	Reason string `json:"reason"`
+}
+
+type DrainEvent struct {
+	Timestamp time.Time `json:"timestamp"`
+	Pod       string    `json:"pod,omitempty"`
+	Message   string    `json:"message"`
+}
+
 type Conditions struct {
 	Type   string `json:"type"`
 	Status string `json:"status"`
@@ -93,9 +727,99 @@ type PodInfo struct {
 	Volumes    []string `json:"volumes"`
 	Controller string   `json:"controller"`
 	Status     string   `json:"status"`
+
+	Diagnosis *PendingDiagnosis `json:"diagnosis,omitempty"` // only for pending pods
+}
+
+// Why a pending pod is not scheduled
+type PendingDiagnosis struct {
+	Kind          string          `json:"kind"` // Pod, or the controller which could not create its pods
+	Name          string          `json:"name"`
+	Namespace     string          `json:"namespace"`
+	Causes        []string        `json:"causes"` // InsufficientCPU, InsufficientMemory, TaintNotTolerated, NodeAffinity, NodeUnschedulable, UnboundPVC, QuotaExceeded
+	Message       string          `json:"message"`
+	Events        []string        `json:"events"`
+	RejectedNodes []NodeRejection `json:"rejected_nodes"`
+}
+
+type NodeRejection struct {
+	Node    string   `json:"node"`
+	Reasons []string `json:"reasons"`
 }
 
 type GetPodUsage struct {
 	CpuUsage []int `json:"cpu_usage"`
 	RamUsage []int `json:"ram_usage"`
 }
+
+// Alert rule, loaded from the rules file
+type AlertRule struct {
+	Name      string            `json:"name"`
+	Kind      string            `json:"kind"` // node_not_ready, pod_restarts, node_cpu, node_ram, warning_event, volume_fill, pvc_pending, cronjob_failing, cert_expiry
+	Severity  string            `json:"severity"`
+	Threshold float64           `json:"threshold,omitempty"`
+	For       string            `json:"for,omitempty"`    // how long the condition must hold before firing, e.g. 15m
+	Window    string            `json:"window,omitempty"` // lookback of pod_restarts and warning_event, e.g. 10m
+	Reason    string            `json:"reason,omitempty"` // regular expression matched against the event reason
+	Namespace string            `json:"namespace,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+type Alert struct {
+	Rule        string            `json:"rule"`
+	Severity    string            `json:"severity"`
+	Subject     string            `json:"subject"` // node or object the alert is about
+	Namespace   string            `json:"namespace"`
+	Message     string            `json:"message"`
+	Labels      map[string]string `json:"labels"`
+	State       string            `json:"state"` // pending, firing or resolved
+	ActiveSince time.Time         `json:"active_since"`
+	FiredAt     time.Time         `json:"fired_at"`
+}
+
+// Stored history of alert state changes
+type AlertTransition struct {
+	Rule      string            `json:"rule"`
+	Severity  string            `json:"severity"`
+	Subject   string            `json:"subject"`
+	Namespace string            `json:"namespace"`
+	Message   string            `json:"message"`
+	Labels    map[string]string `json:"labels"`
+	State     string            `json:"state"`
+	Timestamp time.Time         `json:"timestamp"`
+
+	SilencedBy string `json:"silenced_by,omitempty"` // silence which suppressed the notification
+}
+
+// Notification of a group of alerts to a receiver, with its delivery status
+type Notification struct {
+	Id        string    `json:"id"`
+	Receiver  string    `json:"receiver"`
+	Group     string    `json:"group"`
+	Status    string    `json:"status"` // pending, sent or failed
+	Attempts  int       `json:"attempts"`
+	Error     string    `json:"error"`
+	Alerts    []Alert   `json:"alerts"`
+	Created   time.Time `json:"created"`
+	Delivered time.Time `json:"delivered"`
+}
+
+// Silence of the alerts matching every matcher, between StartsAt and EndsAt.
+// With a schedule it is a recurring maintenance window, only active for Duration after each scheduled time
+type Silence struct {
+	Id        string    `json:"id"`
+	Matchers  []Matcher `json:"matchers"`
+	StartsAt  time.Time `json:"starts_at"`
+	EndsAt    time.Time `json:"ends_at"`            // zero for a maintenance window without end
+	Schedule  string    `json:"schedule,omitempty"` // cron expression, e.g. "0 2 * * SAT"
+	Duration  string    `json:"duration,omitempty"` // e.g. 4h
+	CreatedBy string    `json:"created_by"`
+	Comment   string    `json:"comment"`
+	Created   time.Time `json:"created"`
+}
+
+type Matcher struct {
+	Name  string `json:"name"` // rule, subject, node, namespace, severity or a rule label
+	Value string `json:"value"`
+	Regex bool   `json:"regex"`
+}
@@ -6,13 +6,28 @@ import (
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/metrics/pkg/client/clientset/versioned"
 	metrics "k8s.io/metrics/pkg/client/clientset/versioned"
+	"os"
 )
 
-func InitK8sClient() *kubernetes.Clientset {
+// Value of the environment variable, or fallback if it is not set
+func GetEnv(key string, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+// Config of the service account of the pod (In Cluster)
+func ConfigInCluster() *rest.Config {
 	config, err := rest.InClusterConfig()
 	if err != nil {
 		panic(err.Error())
 	}
+	return config
+}
+
+func InitK8sClient() *kubernetes.Clientset {
+	config := ConfigInCluster()
 	// creates the Kubernetes Client
 	client, err := kubernetes.NewForConfig(config)
 	if err != nil {
@@ -24,10 +39,7 @@ func InitK8sClient() *kubernetes.Clientset {
 
 // Init Kubernetes Metric Client (In Cluster)
 func InitMetricK8sClient() *versioned.Clientset {
-	config, err := rest.InClusterConfig()
-	if err != nil {
-		panic(err.Error())
-	}
+	config := ConfigInCluster()
 	// create the Kubernetes Metric Client
 	client, err := metrics.NewForConfig(config)
 	if err != nil {
@@ -37,29 +49,10 @@ func InitMetricK8sClient() *versioned.Clientset {
 }
 
 // -- Out of Cluster -- //
-func ClientSetOutofCluster() *kubernetes.Clientset {
-	config, err := clientcmd.BuildConfigFromFlags("serverhwan.shop:8001", "/Users/kyh-macbook/Kubernetes/kube-config")
-	if err != nil {
-		panic(err)
-	}
-	cs, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		panic(err)
-	}
-	return cs
-}
-
-func MetricClientSetOutofCluster() *versioned.Clientset {
-
+func ConfigOutofCluster() *rest.Config {
 	config, err := clientcmd.BuildConfigFromFlags("serverhwan.shop:8001", "/Users/kyh-macbook/Kubernetes/kube-config")
 	if err != nil {
 		panic(err)
 	}
-
-	mcs, err := metrics.NewForConfig(config)
-	if err != nil {
-		panic(err)
-	}
-
-	return mcs
+	return config
 }
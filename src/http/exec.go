@@ -0,0 +1,221 @@
+package http
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	cm "github.com/royroyee/kubem/common"
+	"github.com/royroyee/kubem/k8s"
+	"golang.org/x/net/websocket"
+	"io"
+	"k8s.io/client-go/tools/remotecommand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+const (
+	// subprotocol of the terminal messages, the only one selected
+	terminalProtocol = "kubem.terminal"
+	// browsers cannot set headers on a WebSocket, so the token is offered as subprotocol like the API server does
+	tokenProtocolPrefix = "base64url.bearer.kubem.io."
+)
+
+// Origins of the pages allowed to open a terminal, comma separated, e.g. https://kubem.example.com.
+// Only pages served by kubem itself if empty
+var terminalOrigins = cm.GetEnv("KUBEM_TERMINAL_ORIGINS", "")
+
+// Message of the terminal WebSocket, in both directions:
+// stdin and resize from the browser, stdout, stderr and exit to it
+type terminalMessage struct {
+	Type string `json:"type"`
+	Data string `json:"data,omitempty"`
+	Cols uint16 `json:"cols,omitempty"`
+	Rows uint16 `json:"rows,omitempty"`
+}
+
+// Streams of a remote command over a WebSocket
+type terminalSession struct {
+	conn   *websocket.Conn
+	mutex  sync.Mutex // the outputs are written concurrently
+	stdin  *io.PipeReader
+	sizes  chan remotecommand.TerminalSize
+	cancel context.CancelFunc
+	ctx    context.Context
+}
+
+func newTerminalSession(conn *websocket.Conn) *terminalSession {
+	ctx, cancel := context.WithCancel(context.Background())
+	stdin, stdinWriter := io.Pipe()
+	session := &terminalSession{
+		conn:   conn,
+		stdin:  stdin,
+		sizes:  make(chan remotecommand.TerminalSize, 1),
+		cancel: cancel,
+		ctx:    ctx,
+	}
+
+	// the session ends when the browser goes away
+	go func() {
+		defer cancel()
+		defer stdinWriter.Close()
+		for {
+			var message terminalMessage
+			if err := websocket.JSON.Receive(conn, &message); err != nil {
+				return
+			}
+			switch message.Type {
+			case "stdin":
+				if _, err := stdinWriter.Write([]byte(message.Data)); err != nil {
+					return
+				}
+			case "resize":
+				// only the latest size matters
+				select {
+				case <-session.sizes:
+				default:
+				}
+				session.sizes <- remotecommand.TerminalSize{Width: message.Cols, Height: message.Rows}
+			}
+		}
+	}()
+	return session
+}
+
+// Next size of the terminal, nil once the session is over
+func (session *terminalSession) Next() *remotecommand.TerminalSize {
+	select {
+	case size := <-session.sizes:
+		return &size
+	case <-session.ctx.Done():
+		return nil
+	}
+}
+
+func (session *terminalSession) send(message terminalMessage) error {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	return websocket.JSON.Send(session.conn, message)
+}
+
+type terminalWriter struct {
+	session *terminalSession
+	stream  string
+}
+
+func (writer terminalWriter) Write(data []byte) (int, error) {
+	if err := writer.session.send(terminalMessage{Type: writer.stream, Data: string(data)}); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+func (httpHandler HTTPHandler) ExecPod(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	httpHandler.serveTerminal(w, r, params, false)
+}
+
+func (httpHandler HTTPHandler) AttachPod(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	httpHandler.serveTerminal(w, r, params, true)
+}
+
+// Token offered as subprotocol, base64url encoded without padding
+func protocolToken(r *http.Request) string {
+	for _, protocol := range strings.Split(r.Header.Get("Sec-WebSocket-Protocol"), ",") {
+		protocol = strings.TrimSpace(protocol)
+		if !strings.HasPrefix(protocol, tokenProtocolPrefix) {
+			continue
+		}
+		if token, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(protocol, tokenProtocolPrefix)); err == nil {
+			return string(token)
+		}
+	}
+	return ""
+}
+
+// Reject pages of other sites, which could open a terminal with a leaked token, and select the terminal protocol.
+// Clients which are not browsers send no origin
+func terminalHandshake(config *websocket.Config, r *http.Request) error {
+	origin, err := websocket.Origin(config, r)
+	if err != nil {
+		return err
+	}
+	if origin != nil && !originAllowed(origin, r.Host) {
+		return fmt.Errorf("Origin %v is not allowed", origin)
+	}
+	config.Origin = origin
+
+	// the token must not be echoed back
+	offered := config.Protocol
+	config.Protocol = nil
+	for _, protocol := range offered {
+		if protocol == terminalProtocol {
+			config.Protocol = []string{terminalProtocol}
+		}
+	}
+	return nil
+}
+
+func originAllowed(origin *url.URL, host string) bool {
+	if terminalOrigins == "" {
+		return origin.Host == host
+	}
+	for _, allowed := range strings.Split(terminalOrigins, ",") {
+		if strings.TrimSpace(allowed) == origin.Scheme+"://"+origin.Host {
+			return true
+		}
+	}
+	return false
+}
+
+// Proxy an exec or attach stream of a container to a WebSocket. The token is given in the Authorization header,
+// or by browsers as subprotocol next to kubem.terminal. It is never taken from the URL, which ends up in logs
+func (httpHandler HTTPHandler) serveTerminal(w http.ResponseWriter, r *http.Request, params httprouter.Params, attach bool) {
+
+	namespace := params.ByName("namespace")
+	token := bearerToken(r)
+	if token == "" {
+		token = protocolToken(r)
+	}
+	action := k8s.ActionExec
+	if attach {
+		action = k8s.ActionAttach
+	}
+	user, ok := httpHandler.authorizeToken(w, token, action, namespace, params.ByName("name"))
+	if !ok || !httpHandler.authorizeRole(w, user, k8s.RoleExec, action, namespace, params.ByName("name")) {
+		return
+	}
+
+	container := r.URL.Query().Get("container")
+	tty := r.URL.Query().Get("tty") != "false"
+	command := r.URL.Query()["command"]
+	if !attach && len(command) == 0 {
+		command = []string{"sh"}
+	}
+
+	server := websocket.Server{Handshake: terminalHandshake, Handler: func(conn *websocket.Conn) {
+		defer conn.Close()
+
+		session := newTerminalSession(conn)
+		defer session.cancel()
+
+		streams := remotecommand.StreamOptions{
+			Stdin:  session.stdin,
+			Stdout: terminalWriter{session: session, stream: "stdout"},
+			Stderr: terminalWriter{session: session, stream: "stderr"},
+			Tty:    tty,
+		}
+		if tty {
+			streams.TerminalSizeQueue = session
+		}
+
+		exit := terminalMessage{Type: "exit"}
+		err := httpHandler.k8sHandler.Exec(session.ctx, user.Name, namespace, params.ByName("name"), container, command, attach, streams)
+		if err != nil {
+			exit.Data = err.Error()
+		}
+		session.send(exit)
+	}}
+	server.ServeHTTP(w, r)
+}
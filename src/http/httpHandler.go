@@ -31,6 +31,7 @@ func (httpHandler HTTPHandler) StartHTTPServer() {
 	r.GET("/overview/status", httpHandler.GetOverviewStatus)
 
 	r.GET("/overview/nodes/usage", httpHandler.GetNodeUsageOverview)
+	r.GET("/overview/capacity", httpHandler.GetClusterCapacity) // Example : /overview/capacity?days=7 (history used for the forecast)
 
 	// Event
 	r.GET("/events", httpHandler.GetEvents) // Example : /events/?event=warning&page=1&per_page=10
@@ -40,20 +41,99 @@ func (httpHandler HTTPHandler) StartHTTPServer() {
 	r.GET("/nodes", httpHandler.GetNodeOverview)
 	r.GET("/node/usage/:name", httpHandler.GetNodeUsage)
 	r.GET("/node/info/:name", httpHandler.GetNodeInfo)
+	r.GET("/node/pods/:name", httpHandler.GetPodsOfNode)                 // Example : /node/pods/worker-1?sort=cpu_usage&order=desc
+	r.GET("/node/conditions/:name", httpHandler.GetNodeConditionHistory) // Example : /node/conditions/worker-1?type=MemoryPressure&page=1&per_page=10
+	r.GET("/node/flaps/:name", httpHandler.GetNodeFlaps)                 // Example : /node/flaps/worker-1?hours=24
 	r.GET("/nodes/count", httpHandler.GetNumberOfNodes)
+	r.POST("/node/:name/cordon", httpHandler.CordonNode) // Example : /node/worker-1/cordon?dryRun=true
+	r.POST("/node/:name/uncordon", httpHandler.UncordonNode)
+	r.POST("/node/:name/drain", httpHandler.DrainNode) // Example : /node/worker-1/drain?grace_period=30&timeout=10m&follow=true
+	r.GET("/drains", httpHandler.GetDrains)
+	r.GET("/drains/:id", httpHandler.GetDrain) // Example : /drains/6475c5f1e1382330a8d7e2b1?follow=true (server-sent events)
 
 	// Workload
 	r.GET("/workload/namespaces", httpHandler.GetNamespace)
+	r.GET("/workload/namespaces/usage", httpHandler.GetNamespaceUsages)                   // Example : /workload/namespaces/usage?page=1&per_page=10
+	r.GET("/workload/namespace/:namespace", httpHandler.GetNamespaceUsage)                // Example : /workload/namespace/default?top=5
+	r.GET("/workload/namespace/:namespace/history", httpHandler.GetNamespaceUsageHistory) // Example : /workload/namespace/default/history?days=30
+
 	r.GET("/workload", httpHandler.GetControllersByFilter) // Filtering by Namespace, Type
 	r.GET("/workload/count", httpHandler.GetNumberOfControllers)
 	r.GET("/workload/info/:namespace/:name", httpHandler.GetControllerInfo)
 	r.GET("/workload/conditions/:namespace/:name", httpHandler.GetConditions)
 	r.GET("/workload/detail/:namespace/:name", httpHandler.GetControllerDetail)
+	r.GET("/workload/rollouts/:namespace/:name", httpHandler.GetRollouts)   // Example : /workload/rollouts/default/web?page=1&per_page=10
+	r.GET("/workload/revisions/:namespace/:name", httpHandler.GetRevisions) // Example : /workload/revisions/default/web?from=3&to=4
+	r.GET("/workload/autoscalers", httpHandler.GetAutoscalers)              // Example : /workload/autoscalers?namespace=default&page=1&per_page=10
+	r.GET("/workload/autoscaler/:namespace/:name", httpHandler.GetAutoscaler)
+	r.GET("/workload/scaling/:namespace/:name", httpHandler.GetScalingHistory) // Example : /workload/scaling/default/web-hpa?hours=24
+	r.GET("/workload/jobs/runs", httpHandler.GetJobRuns)                       // Example : /workload/jobs/runs?namespace=default&cronjob=backup&status=failed&page=1&per_page=10
+	r.GET("/workload/cronjobs", httpHandler.GetCronJobs)                       // Example : /workload/cronjobs?namespace=default&page=1&per_page=10
+	r.GET("/workload/cronjob/:namespace/:name", httpHandler.GetCronJob)
+
+	// Operations, which require the bearer token of a user allowed to change the namespace
+	r.POST("/workload/restart/:namespace/:name", httpHandler.RestartWorkload)     // Example : /workload/restart/default/web?type=deployment&dryRun=true
+	r.POST("/workload/scale/:namespace/:name", httpHandler.ScaleWorkload)         // Example : /workload/scale/default/web?type=deployment&replicas=3&dryRun=true
+	r.POST("/workload/rollback/:namespace/:name", httpHandler.RollbackDeployment) // Example : /workload/rollback/default/web?revision=3&dryRun=true
+	r.POST("/workload/suspend/:namespace/:name", httpHandler.SuspendCronJob)      // Example : /workload/suspend/default/backup?dryRun=true
+	r.POST("/workload/resume/:namespace/:name", httpHandler.ResumeCronJob)
+	r.GET("/audit", httpHandler.GetAuditRecords) // Example : /audit?user=alice&namespace=default&page=1&per_page=10
 
 	// Pod
-	r.GET("/pod/info/:name", httpHandler.GetPodInfo) // Information of Pod (detail page)
+	r.GET("/pod/info/:name", httpHandler.GetPodInfo) // Information of Pod (detail page), with a scheduling diagnosis if pending
 	r.GET("/pod/usage/:name", httpHandler.GetPodUsage)
 	r.GET("/pod/logs/:namespace/:name", httpHandler.GetLogsOfPod)
+	r.GET("/pods/problems", httpHandler.GetPodProblems)          // Example : /pods/problems?namespace=default&reason=CrashLoopBackOff
+	r.GET("/pods/pending", httpHandler.GetPendingDiagnoses)      // Example : /pods/pending?namespace=default
+	r.DELETE("/pod/:namespace/:name", httpHandler.DeletePod)     // Example : /pod/default/web-7d4b9c-x2x9z?grace_period=0&dryRun=true
+	r.POST("/pod/debug/:namespace/:name", httpHandler.DebugPod)  // Example : /pod/debug/default/web-7d4b9c-x2x9z?image=busybox:1.36&target=app
+	r.GET("/pod/exec/:namespace/:name", httpHandler.ExecPod)     // WebSocket, Example : /pod/exec/default/web-7d4b9c-x2x9z?container=app&command=sh&tty=true
+	r.GET("/pod/attach/:namespace/:name", httpHandler.AttachPod) // WebSocket, Example : /pod/attach/default/web-7d4b9c-x2x9z?container=app
+
+	// Network
+	r.GET("/network/services", httpHandler.GetServices) // Example : /network/services?namespace=default&problem=true&page=1&per_page=10
+	r.GET("/network/services/count", httpHandler.GetNumberOfServices)
+	r.GET("/network/service/:namespace/:name", httpHandler.GetServiceDetail)
+	r.GET("/network/ingresses", httpHandler.GetIngresses) // Example : /network/ingresses?namespace=default&problem=true&page=1&per_page=10
+	r.GET("/network/ingresses/count", httpHandler.GetNumberOfIngresses)
+	r.GET("/network/ingress/:namespace/:name", httpHandler.GetIngressDetail)
+
+	// Storage
+	r.GET("/storage/pvcs", httpHandler.GetPVCs) // Example : /storage/pvcs?namespace=default&problem=true&page=1&per_page=10
+	r.GET("/storage/pvcs/count", httpHandler.GetNumberOfPVCs)
+	r.GET("/storage/pvc/:namespace/:name", httpHandler.GetPVC)
+	r.GET("/storage/pvs", httpHandler.GetPVs)
+	r.GET("/storage/pvs/count", httpHandler.GetNumberOfPVs)
+
+	// Cost
+	r.GET("/cost/report", httpHandler.GetCostReport) // Example : /cost/report?from=2023-05-01&to=2023-05-31&by=label&label=team&format=csv
+
+	// Alert
+	r.GET("/alerts", httpHandler.GetAlerts)               // Pending and firing alerts
+	r.GET("/alerts/history", httpHandler.GetAlertHistory) // Example : /alerts/history?rule=NodeNotReady&state=firing&page=1&per_page=10
+	r.GET("/alerts/rules", httpHandler.GetAlertRules)
+	r.POST("/alerts/rules/reload", httpHandler.ReloadAlertRules)
+	r.GET("/notifications", httpHandler.GetNotifications) // Example : /notifications?receiver=ops&status=failed&page=1&per_page=10
+	r.GET("/notifications/:id", httpHandler.GetNotification)
+	r.POST("/silences", httpHandler.CreateSilence)
+	r.GET("/silences", httpHandler.GetSilences) // Example : /silences?active=true&page=1&per_page=10
+	r.DELETE("/silences/:id", httpHandler.ExpireSilence)
+
+	// Timeline
+	r.GET("/timeline", httpHandler.GetTimeline) // Example : /timeline?namespace=default&from=2023-05-01T10:00:00Z&to=2023-05-01T12:00:00Z
+
+	// Topology
+	r.GET("/topology", httpHandler.GetTopology) // Example : /topology?namespace=default&root=Deployment/web
+
+	// Security
+	r.GET("/security/findings", httpHandler.GetSecurityFindings)                // Example : /security/findings?namespace=default&severity=high&page=1&per_page=10
+	r.GET("/security/certificates", httpHandler.GetCertificates)                // Example : /security/certificates?namespace=default&status=expiring&page=1&per_page=10
+	r.GET("/security/certificate/:namespace/:name", httpHandler.GetCertificate) // Example : /security/certificate/default/web-tls
+	r.GET("/lint/findings", httpHandler.GetLintFindings)                        // Example : /lint/findings?namespace=default&rule=probes&page=1&per_page=10
+	r.GET("/lint/rules", httpHandler.GetLintRules)
+
+	// Upgrades
+	r.GET("/deprecations", httpHandler.GetDeprecations) // Example : /deprecations?namespace=default&target=1.25&page=1&per_page=10
 
 	log.Fatal(http.ListenAndServe(":9000", r))
 
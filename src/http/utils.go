@@ -1,12 +1,22 @@
 package http
 
 import (
+	"encoding/csv"
+	"fmt"
 	"github.com/julienschmidt/httprouter"
+	cm "github.com/royroyee/kubem/common"
+	"github.com/royroyee/kubem/k8s"
+	"io"
 	"k8s.io/apimachinery/pkg/util/json"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 )
 
+// How often the progress of a followed drain is sent
+const drainStreamInterval = time.Second
+
 func (httpHandler HTTPHandler) GetOverviewStatus(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 
 	overview, err := httpHandler.k8sHandler.GetOverviewStatus()
@@ -26,6 +36,30 @@ func (httpHandler HTTPHandler) GetOverviewStatus(w http.ResponseWriter, r *http.
 	w.WriteHeader(http.StatusOK)
 }
 
+func (httpHandler HTTPHandler) GetClusterCapacity(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	days, err := strconv.Atoi(r.URL.Query().Get("days"))
+	if err != nil {
+		days = 7
+	}
+
+	capacity, err := httpHandler.k8sHandler.GetClusterCapacity(days)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := json.Marshal(&capacity)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
 func (httpHandler HTTPHandler) GetEvents(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
 
 	eventType := r.URL.Query().Get("event")
@@ -157,6 +191,82 @@ func (httpHandler HTTPHandler) GetNodeInfo(w http.ResponseWriter, r *http.Reques
 	w.WriteHeader(http.StatusOK)
 }
 
+func (httpHandler HTTPHandler) GetPodsOfNode(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	sortBy := r.URL.Query().Get("sort")
+	order := r.URL.Query().Get("order")
+
+	pods, err := httpHandler.k8sHandler.GetPodsOfNode(ps.ByName("name"), sortBy, order)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := json.Marshal(&pods)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (httpHandler HTTPHandler) GetNodeConditionHistory(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	conditionType := r.URL.Query().Get("type")
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil {
+		page = 1
+	}
+	perPage, err := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if err != nil {
+		perPage = 10
+	}
+
+	history, err := httpHandler.k8sHandler.GetNodeConditionHistory(ps.ByName("name"), conditionType, page, perPage)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := json.Marshal(&history)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (httpHandler HTTPHandler) GetNodeFlaps(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	hours, err := strconv.Atoi(r.URL.Query().Get("hours"))
+	if err != nil {
+		hours = 24
+	}
+
+	flaps, err := httpHandler.k8sHandler.GetNodeFlaps(ps.ByName("name"), hours)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := json.Marshal(&flaps)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
 func (httpHandler HTTPHandler) GetNumberOfNodes(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 
 	count, err := httpHandler.k8sHandler.NumberOfNodes()
@@ -195,6 +305,82 @@ func (httpHandler HTTPHandler) GetNamespace(w http.ResponseWriter, r *http.Reque
 	w.WriteHeader(http.StatusOK)
 }
 
+func (httpHandler HTTPHandler) GetNamespaceUsages(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil {
+		page = 1
+	}
+	perPage, err := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if err != nil {
+		perPage = 10
+	}
+
+	usages, err := httpHandler.k8sHandler.GetNamespaceUsages(page, perPage)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := json.Marshal(&usages)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (httpHandler HTTPHandler) GetNamespaceUsage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	top, err := strconv.Atoi(r.URL.Query().Get("top"))
+	if err != nil {
+		top = 5
+	}
+
+	usage, err := httpHandler.k8sHandler.GetNamespaceUsage(ps.ByName("namespace"), top)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := json.Marshal(&usage)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (httpHandler HTTPHandler) GetNamespaceUsageHistory(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	days, err := strconv.Atoi(r.URL.Query().Get("days"))
+	if err != nil {
+		days = 7
+	}
+
+	history, err := httpHandler.k8sHandler.GetNamespaceUsageHistory(ps.ByName("namespace"), time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := json.Marshal(&history)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
 func (httpHandler HTTPHandler) GetControllersByFilter(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
 
 	namespace := r.URL.Query().Get("namespace")
@@ -286,9 +472,59 @@ func (httpHandler HTTPHandler) GetControllerDetail(w http.ResponseWriter, r *htt
 	w.WriteHeader(http.StatusOK)
 }
 
+func (httpHandler HTTPHandler) GetRollouts(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil {
+		page = 1
+	}
+	perPage, err := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if err != nil {
+		perPage = 10
+	}
+
+	rollouts, err := httpHandler.k8sHandler.GetRollouts(params.ByName("namespace"), params.ByName("name"), page, perPage)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := json.Marshal(&rollouts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (httpHandler HTTPHandler) GetRevisions(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+
+	from := r.URL.Query().Get("from")
+	to := r.URL.Query().Get("to")
+
+	revisions, err := httpHandler.k8sHandler.GetRevisions(params.ByName("namespace"), params.ByName("name"), from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := json.Marshal(&revisions)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
 func (httpHandler HTTPHandler) GetPodInfo(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 
-	podInfo, err := httpHandler.k8sHandler.GetInfoOfPod(ps.ByName("name"))
+	podInfo, err := httpHandler.k8sHandler.GetPodInfo(ps.ByName("name"))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -300,6 +536,49 @@ func (httpHandler HTTPHandler) GetPodInfo(w http.ResponseWriter, r *http.Request
 	w.WriteHeader(http.StatusOK)
 }
 
+func (httpHandler HTTPHandler) GetPodProblems(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	namespace := r.URL.Query().Get("namespace")
+	reason := r.URL.Query().Get("reason")
+
+	problems, err := httpHandler.k8sHandler.GetPodProblems(namespace, reason)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := json.Marshal(&problems)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (httpHandler HTTPHandler) GetPendingDiagnoses(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	namespace := r.URL.Query().Get("namespace")
+
+	diagnoses, err := httpHandler.k8sHandler.GetPendingDiagnoses(namespace)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := json.Marshal(&diagnoses)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
 func (httpHandler HTTPHandler) GetPodUsage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 
 	podUsage, err := httpHandler.k8sHandler.GetPodUsageDetail(ps.ByName("name"))
@@ -332,3 +611,1321 @@ func (httpHandler HTTPHandler) GetLogsOfPod(w http.ResponseWriter, r *http.Reque
 	w.Write(result)
 	w.WriteHeader(http.StatusOK)
 }
+
+func (httpHandler HTTPHandler) GetCostReport(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	groupBy := r.URL.Query().Get("by")
+	if groupBy == "" {
+		groupBy = "namespace"
+	}
+	labelKey := r.URL.Query().Get("label")
+
+	// Default to the current month, both dates are included
+	now := time.Now()
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local)
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	if value := r.URL.Query().Get("from"); value != "" {
+		date, err := time.ParseInLocation("2006-01-02", value, time.Local)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		from = date
+	}
+	if value := r.URL.Query().Get("to"); value != "" {
+		date, err := time.ParseInLocation("2006-01-02", value, time.Local)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		to = date
+	}
+
+	report, err := httpHandler.k8sHandler.GetCostReport(from, to, groupBy, labelKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=cost-%s-%s.csv", report.From, report.To))
+
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"name", "cpu_hours", "gib_hours", "cpu_cost", "ram_cost", "cost"})
+		for _, line := range report.Lines {
+			writer.Write([]string{
+				line.Name,
+				strconv.FormatFloat(line.CpuHours, 'f', 2, 64),
+				strconv.FormatFloat(line.GiBHours, 'f', 2, 64),
+				strconv.FormatFloat(line.CpuCost, 'f', 2, 64),
+				strconv.FormatFloat(line.RamCost, 'f', 2, 64),
+				strconv.FormatFloat(line.Cost, 'f', 2, 64),
+			})
+		}
+		writer.Flush()
+		return
+	}
+
+	result, err := json.Marshal(&report)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (httpHandler HTTPHandler) GetAlerts(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	alerts := httpHandler.k8sHandler.GetAlerts()
+
+	result, err := json.Marshal(&alerts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (httpHandler HTTPHandler) GetAlertHistory(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	rule := r.URL.Query().Get("rule")
+	state := r.URL.Query().Get("state")
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil {
+		page = 1
+	}
+	perPage, err := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if err != nil {
+		perPage = 10
+	}
+
+	history, err := httpHandler.k8sHandler.GetAlertHistory(rule, state, page, perPage)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := json.Marshal(&history)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (httpHandler HTTPHandler) GetAlertRules(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	rules := httpHandler.k8sHandler.GetAlertRules()
+
+	result, err := json.Marshal(&rules)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (httpHandler HTTPHandler) ReloadAlertRules(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	err := httpHandler.k8sHandler.ReloadAlertRules()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	httpHandler.GetAlertRules(w, r, ps)
+}
+
+func (httpHandler HTTPHandler) GetNotifications(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	receiver := r.URL.Query().Get("receiver")
+	status := r.URL.Query().Get("status")
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil {
+		page = 1
+	}
+	perPage, err := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if err != nil {
+		perPage = 10
+	}
+
+	notifications, err := httpHandler.k8sHandler.GetNotifications(receiver, status, page, perPage)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := json.Marshal(&notifications)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (httpHandler HTTPHandler) GetNotification(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	notification, err := httpHandler.k8sHandler.GetNotification(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := json.Marshal(&notification)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
+// Silences mute alerts of every namespace, so only cluster scoped users may create or expire them
+func (httpHandler HTTPHandler) CreateSilence(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	user, ok := httpHandler.authorize(w, r, k8s.ActionSilence, "", "")
+	if !ok {
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var silence cm.Silence
+	if err := json.Unmarshal(body, &silence); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	silence.CreatedBy = user.Name
+	silence, err = httpHandler.k8sHandler.CreateSilence(silence)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := json.Marshal(&silence)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(result)
+}
+
+func (httpHandler HTTPHandler) GetSilences(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	active := r.URL.Query().Get("active") == "true"
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil {
+		page = 1
+	}
+	perPage, err := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if err != nil {
+		perPage = 10
+	}
+
+	silences, err := httpHandler.k8sHandler.GetSilences(active, page, perPage)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := json.Marshal(&silences)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (httpHandler HTTPHandler) ExpireSilence(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	user, ok := httpHandler.authorize(w, r, k8s.ActionExpireSilence, "", ps.ByName("id"))
+	if !ok {
+		return
+	}
+
+	silence, err := httpHandler.k8sHandler.ExpireSilence(user.Name, ps.ByName("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := json.Marshal(&silence)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (httpHandler HTTPHandler) GetTimeline(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	namespace := r.URL.Query().Get("namespace")
+
+	// Default to the last 24 hours
+	to := time.Now()
+	if value := r.URL.Query().Get("to"); value != "" {
+		date, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		to = date
+	}
+	from := to.Add(-24 * time.Hour)
+	if value := r.URL.Query().Get("from"); value != "" {
+		date, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		from = date
+	}
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil {
+		page = 1
+	}
+	perPage, err := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if err != nil {
+		perPage = 50
+	}
+
+	timeline, err := httpHandler.k8sHandler.GetTimeline(namespace, from, to, page, perPage)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := json.Marshal(&timeline)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (httpHandler HTTPHandler) GetTopology(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	namespace := r.URL.Query().Get("namespace")
+	root := r.URL.Query().Get("root")
+
+	topology, err := httpHandler.k8sHandler.GetTopology(namespace, root)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := json.Marshal(&topology)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (httpHandler HTTPHandler) GetServices(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	namespace := r.URL.Query().Get("namespace")
+	problemsOnly := r.URL.Query().Get("problem") == "true"
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil {
+		page = 1
+	}
+	perPage, err := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if err != nil {
+		perPage = 10
+	}
+
+	services, err := httpHandler.k8sHandler.GetServices(namespace, problemsOnly, page, perPage)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := json.Marshal(&services)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (httpHandler HTTPHandler) GetNumberOfServices(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	namespace := r.URL.Query().Get("namespace")
+	problemsOnly := r.URL.Query().Get("problem") == "true"
+	count, err := httpHandler.k8sHandler.NumberOfServices(namespace, problemsOnly)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := json.Marshal(&count)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (httpHandler HTTPHandler) GetServiceDetail(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+
+	detail, err := httpHandler.k8sHandler.GetServiceDetail(params.ByName("namespace"), params.ByName("name"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := json.Marshal(&detail)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (httpHandler HTTPHandler) GetIngresses(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	namespace := r.URL.Query().Get("namespace")
+	problemsOnly := r.URL.Query().Get("problem") == "true"
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil {
+		page = 1
+	}
+	perPage, err := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if err != nil {
+		perPage = 10
+	}
+
+	ingresses, err := httpHandler.k8sHandler.GetIngresses(namespace, problemsOnly, page, perPage)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := json.Marshal(&ingresses)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (httpHandler HTTPHandler) GetNumberOfIngresses(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	namespace := r.URL.Query().Get("namespace")
+	problemsOnly := r.URL.Query().Get("problem") == "true"
+	count, err := httpHandler.k8sHandler.NumberOfIngresses(namespace, problemsOnly)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := json.Marshal(&count)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (httpHandler HTTPHandler) GetIngressDetail(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+
+	detail, err := httpHandler.k8sHandler.GetIngressDetail(params.ByName("namespace"), params.ByName("name"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := json.Marshal(&detail)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (httpHandler HTTPHandler) GetPVCs(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	namespace := r.URL.Query().Get("namespace")
+	problemsOnly := r.URL.Query().Get("problem") == "true"
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil {
+		page = 1
+	}
+	perPage, err := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if err != nil {
+		perPage = 10
+	}
+
+	claims, err := httpHandler.k8sHandler.GetPVCs(namespace, problemsOnly, page, perPage)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := json.Marshal(&claims)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (httpHandler HTTPHandler) GetNumberOfPVCs(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	namespace := r.URL.Query().Get("namespace")
+	problemsOnly := r.URL.Query().Get("problem") == "true"
+	count, err := httpHandler.k8sHandler.NumberOfPVCs(namespace, problemsOnly)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := json.Marshal(&count)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (httpHandler HTTPHandler) GetPVC(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+
+	claim, err := httpHandler.k8sHandler.GetPVC(params.ByName("namespace"), params.ByName("name"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := json.Marshal(&claim)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (httpHandler HTTPHandler) GetPVs(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil {
+		page = 1
+	}
+	perPage, err := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if err != nil {
+		perPage = 10
+	}
+
+	volumes, err := httpHandler.k8sHandler.GetPVs(page, perPage)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := json.Marshal(&volumes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (httpHandler HTTPHandler) GetNumberOfPVs(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	count, err := httpHandler.k8sHandler.NumberOfPVs()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := json.Marshal(&count)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (httpHandler HTTPHandler) GetAutoscalers(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	namespace := r.URL.Query().Get("namespace")
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil {
+		page = 1
+	}
+	perPage, err := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if err != nil {
+		perPage = 10
+	}
+
+	autoscalers, err := httpHandler.k8sHandler.GetAutoscalers(namespace, page, perPage)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := json.Marshal(&autoscalers)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (httpHandler HTTPHandler) GetAutoscaler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+
+	autoscaler, err := httpHandler.k8sHandler.GetAutoscaler(params.ByName("namespace"), params.ByName("name"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := json.Marshal(&autoscaler)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (httpHandler HTTPHandler) GetScalingHistory(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+
+	hours, err := strconv.Atoi(r.URL.Query().Get("hours"))
+	if err != nil {
+		hours = 24
+	}
+
+	history, err := httpHandler.k8sHandler.GetScalingHistory(params.ByName("namespace"), params.ByName("name"), time.Now().Add(-time.Duration(hours)*time.Hour))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := json.Marshal(&history)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (httpHandler HTTPHandler) GetJobRuns(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	namespace := r.URL.Query().Get("namespace")
+	cronJob := r.URL.Query().Get("cronjob")
+	status := r.URL.Query().Get("status")
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil {
+		page = 1
+	}
+	perPage, err := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if err != nil {
+		perPage = 10
+	}
+
+	runs, err := httpHandler.k8sHandler.GetJobRuns(namespace, cronJob, status, page, perPage)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := json.Marshal(&runs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (httpHandler HTTPHandler) GetCronJobs(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	namespace := r.URL.Query().Get("namespace")
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil {
+		page = 1
+	}
+	perPage, err := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if err != nil {
+		perPage = 10
+	}
+
+	cronJobs, err := httpHandler.k8sHandler.GetCronJobs(namespace, page, perPage)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := json.Marshal(&cronJobs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (httpHandler HTTPHandler) GetCronJob(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+
+	cronJob, err := httpHandler.k8sHandler.GetCronJob(params.ByName("namespace"), params.ByName("name"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := json.Marshal(&cronJob)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (httpHandler HTTPHandler) GetSecurityFindings(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	namespace := r.URL.Query().Get("namespace")
+	severity := r.URL.Query().Get("severity")
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil {
+		page = 1
+	}
+	perPage, err := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if err != nil {
+		perPage = 10
+	}
+
+	report, err := httpHandler.k8sHandler.GetSecurityFindings(namespace, severity, page, perPage)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := json.Marshal(&report)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (httpHandler HTTPHandler) GetLintFindings(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	namespace := r.URL.Query().Get("namespace")
+	rule := r.URL.Query().Get("rule")
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil {
+		page = 1
+	}
+	perPage, err := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if err != nil {
+		perPage = 10
+	}
+
+	report, err := httpHandler.k8sHandler.GetLintFindings(namespace, rule, page, perPage)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := json.Marshal(&report)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (httpHandler HTTPHandler) GetLintRules(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	rules, err := httpHandler.k8sHandler.GetLintRules()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := json.Marshal(&rules)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (httpHandler HTTPHandler) GetDeprecations(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	namespace := r.URL.Query().Get("namespace")
+	target := r.URL.Query().Get("target")
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil {
+		page = 1
+	}
+	perPage, err := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if err != nil {
+		perPage = 10
+	}
+
+	report, err := httpHandler.k8sHandler.GetDeprecations(namespace, target, page, perPage)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := json.Marshal(&report)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (httpHandler HTTPHandler) GetCertificates(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	namespace := r.URL.Query().Get("namespace")
+	status := r.URL.Query().Get("status")
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil {
+		page = 1
+	}
+	perPage, err := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if err != nil {
+		perPage = 10
+	}
+
+	certificates, err := httpHandler.k8sHandler.GetCertificates(namespace, status, page, perPage)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := json.Marshal(&certificates)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (httpHandler HTTPHandler) GetCertificate(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+
+	certificate, err := httpHandler.k8sHandler.GetCertificate(params.ByName("namespace"), params.ByName("name"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := json.Marshal(&certificate)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
+// User of the bearer token of the request if allowed to change the namespace, otherwise the error is written.
+// Rejected attempts of the action on the object are audited, with the user if the token is known
+func (httpHandler HTTPHandler) authorize(w http.ResponseWriter, r *http.Request, action string, namespace string, name string) (cm.User, bool) {
+	return httpHandler.authorizeToken(w, bearerToken(r), action, namespace, name)
+}
+
+func (httpHandler HTTPHandler) authorizeToken(w http.ResponseWriter, token string, action string, namespace string, name string) (cm.User, bool) {
+	user, ok := httpHandler.authenticate(w, token, action, namespace, name)
+	if !ok {
+		return user, false
+	}
+	if err := httpHandler.k8sHandler.Authorize(user, namespace); err != nil {
+		httpHandler.k8sHandler.AuditDenied(cm.AuditRecord{User: user.Name, Action: action, Namespace: namespace, Name: name}, err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return user, false
+	}
+	return user, true
+}
+
+// User of the token whatever its namespaces, otherwise the error is written and the attempt audited
+func (httpHandler HTTPHandler) authenticate(w http.ResponseWriter, token string, action string, namespace string, name string) (cm.User, bool) {
+	user, err := httpHandler.k8sHandler.Authenticate(token)
+	if err != nil {
+		httpHandler.k8sHandler.AuditDenied(cm.AuditRecord{Action: action, Namespace: namespace, Name: name}, err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return user, false
+	}
+	return user, true
+}
+
+// Same as authorize for an authorized user, who must also have the role
+func (httpHandler HTTPHandler) authorizeRole(w http.ResponseWriter, user cm.User, role string, action string, namespace string, name string) bool {
+	if err := httpHandler.k8sHandler.AuthorizeRole(user, role); err != nil {
+		httpHandler.k8sHandler.AuditDenied(cm.AuditRecord{User: user.Name, Action: action, Namespace: namespace, Name: name}, err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func bearerToken(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimPrefix(header, "Bearer ")
+	}
+	return ""
+}
+
+func (httpHandler HTTPHandler) writeAuditRecord(w http.ResponseWriter, record cm.AuditRecord, err error) {
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := json.Marshal(&record)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (httpHandler HTTPHandler) RestartWorkload(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+
+	namespace := params.ByName("namespace")
+	user, ok := httpHandler.authorize(w, r, k8s.ActionRestart, namespace, params.ByName("name"))
+	if !ok {
+		return
+	}
+
+	controllerType := r.URL.Query().Get("type")
+	dryRun := r.URL.Query().Get("dryRun") == "true"
+
+	record, err := httpHandler.k8sHandler.RestartWorkload(user.Name, controllerType, namespace, params.ByName("name"), dryRun)
+	httpHandler.writeAuditRecord(w, record, err)
+}
+
+func (httpHandler HTTPHandler) ScaleWorkload(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+
+	namespace := params.ByName("namespace")
+	user, ok := httpHandler.authorize(w, r, k8s.ActionScale, namespace, params.ByName("name"))
+	if !ok {
+		return
+	}
+
+	controllerType := r.URL.Query().Get("type")
+	dryRun := r.URL.Query().Get("dryRun") == "true"
+	replicas, err := strconv.ParseInt(r.URL.Query().Get("replicas"), 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid replicas", http.StatusBadRequest)
+		return
+	}
+
+	record, err := httpHandler.k8sHandler.ScaleWorkload(user.Name, controllerType, namespace, params.ByName("name"), int32(replicas), dryRun)
+	httpHandler.writeAuditRecord(w, record, err)
+}
+
+func (httpHandler HTTPHandler) RollbackDeployment(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+
+	namespace := params.ByName("namespace")
+	user, ok := httpHandler.authorize(w, r, k8s.ActionRollback, namespace, params.ByName("name"))
+	if !ok {
+		return
+	}
+
+	revision := r.URL.Query().Get("revision")
+	if revision == "" {
+		http.Error(w, "Missing revision", http.StatusBadRequest)
+		return
+	}
+	dryRun := r.URL.Query().Get("dryRun") == "true"
+
+	record, err := httpHandler.k8sHandler.RollbackDeployment(user.Name, namespace, params.ByName("name"), revision, dryRun)
+	httpHandler.writeAuditRecord(w, record, err)
+}
+
+func (httpHandler HTTPHandler) SuspendCronJob(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+
+	namespace := params.ByName("namespace")
+	user, ok := httpHandler.authorize(w, r, k8s.ActionSuspend, namespace, params.ByName("name"))
+	if !ok {
+		return
+	}
+
+	dryRun := r.URL.Query().Get("dryRun") == "true"
+
+	record, err := httpHandler.k8sHandler.SuspendCronJob(user.Name, namespace, params.ByName("name"), true, dryRun)
+	httpHandler.writeAuditRecord(w, record, err)
+}
+
+func (httpHandler HTTPHandler) ResumeCronJob(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+
+	namespace := params.ByName("namespace")
+	user, ok := httpHandler.authorize(w, r, k8s.ActionResume, namespace, params.ByName("name"))
+	if !ok {
+		return
+	}
+
+	dryRun := r.URL.Query().Get("dryRun") == "true"
+
+	record, err := httpHandler.k8sHandler.SuspendCronJob(user.Name, namespace, params.ByName("name"), false, dryRun)
+	httpHandler.writeAuditRecord(w, record, err)
+}
+
+// Audit records of a namespace, or of every namespace the user may access
+func (httpHandler HTTPHandler) GetAuditRecords(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	user := r.URL.Query().Get("user")
+	namespace := r.URL.Query().Get("namespace")
+
+	var reader cm.User
+	var ok bool
+	if namespace != "" {
+		reader, ok = httpHandler.authorize(w, r, k8s.ActionReadAudit, namespace, "")
+	} else {
+		reader, ok = httpHandler.authenticate(w, bearerToken(r), k8s.ActionReadAudit, "", "")
+	}
+	if !ok {
+		return
+	}
+	// all namespaces and the cluster scoped records if empty
+	namespaces := reader.Namespaces
+	if namespace != "" {
+		namespaces = []string{namespace}
+	}
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil {
+		page = 1
+	}
+	perPage, err := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if err != nil {
+		perPage = 10
+	}
+
+	records, err := httpHandler.k8sHandler.GetAuditRecords(user, namespaces, page, perPage)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := json.Marshal(&records)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (httpHandler HTTPHandler) CordonNode(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+
+	user, ok := httpHandler.authorize(w, r, k8s.ActionCordon, "", params.ByName("name"))
+	if !ok {
+		return
+	}
+
+	dryRun := r.URL.Query().Get("dryRun") == "true"
+
+	record, err := httpHandler.k8sHandler.CordonNode(user.Name, params.ByName("name"), true, dryRun)
+	httpHandler.writeAuditRecord(w, record, err)
+}
+
+func (httpHandler HTTPHandler) UncordonNode(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+
+	user, ok := httpHandler.authorize(w, r, k8s.ActionUncordon, "", params.ByName("name"))
+	if !ok {
+		return
+	}
+
+	dryRun := r.URL.Query().Get("dryRun") == "true"
+
+	record, err := httpHandler.k8sHandler.CordonNode(user.Name, params.ByName("name"), false, dryRun)
+	httpHandler.writeAuditRecord(w, record, err)
+}
+
+func (httpHandler HTTPHandler) DrainNode(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+
+	user, ok := httpHandler.authorize(w, r, k8s.ActionDrain, "", params.ByName("name"))
+	if !ok {
+		return
+	}
+
+	dryRun := r.URL.Query().Get("dryRun") == "true"
+
+	gracePeriod, err := strconv.ParseInt(r.URL.Query().Get("grace_period"), 10, 64)
+	if err != nil {
+		gracePeriod = -1
+	}
+	timeout := k8s.DefaultDrainTimeout
+	if value := r.URL.Query().Get("timeout"); value != "" {
+		timeout, err = time.ParseDuration(value)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	force := r.URL.Query().Get("force") == "true"
+	deleteEmptyDirData := r.URL.Query().Get("delete_emptydir_data") == "true"
+
+	drain, err := httpHandler.k8sHandler.DrainNode(user.Name, params.ByName("name"), gracePeriod, timeout, force, deleteEmptyDirData, dryRun)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if r.URL.Query().Get("follow") == "true" {
+		httpHandler.streamDrain(w, r, drain.Id)
+		return
+	}
+
+	result, err := json.Marshal(&drain)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(result)
+}
+
+func (httpHandler HTTPHandler) GetDrains(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	drains := httpHandler.k8sHandler.GetDrains()
+
+	result, err := json.Marshal(&drains)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (httpHandler HTTPHandler) GetDrain(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+
+	if r.URL.Query().Get("follow") == "true" {
+		httpHandler.streamDrain(w, r, params.ByName("id"))
+		return
+	}
+
+	drain, err := httpHandler.k8sHandler.GetDrain(params.ByName("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := json.Marshal(&drain)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	w.WriteHeader(http.StatusOK)
+}
+
+// Stream the progress of the drain as server-sent events, ending with a report event holding the whole drain.
+// The drain goes on if the client goes away
+func (httpHandler HTTPHandler) streamDrain(w http.ResponseWriter, r *http.Request, id string) {
+
+	drain, err := httpHandler.k8sHandler.GetDrain(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	ticker := time.NewTicker(drainStreamInterval)
+	defer ticker.Stop()
+
+	sent := 0
+	for {
+		for _, event := range drain.Progress[sent:] {
+			data, err := json.Marshal(&event)
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(w, "event: progress\ndata: %s\n\n", data)
+		}
+		sent = len(drain.Progress)
+
+		if drain.Status != k8s.DrainRunning {
+			data, err := json.Marshal(&drain)
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(w, "event: report\ndata: %s\n\n", data)
+			flusher.Flush()
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+		if drain, err = httpHandler.k8sHandler.GetDrain(id); err != nil {
+			return
+		}
+	}
+}
+
+func (httpHandler HTTPHandler) DeletePod(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+
+	namespace := params.ByName("namespace")
+	user, ok := httpHandler.authorize(w, r, k8s.ActionDelete, namespace, params.ByName("name"))
+	if !ok {
+		return
+	}
+
+	dryRun := r.URL.Query().Get("dryRun") == "true"
+	gracePeriod, err := strconv.ParseInt(r.URL.Query().Get("grace_period"), 10, 64)
+	if err != nil {
+		gracePeriod = -1
+	}
+
+	record, err := httpHandler.k8sHandler.DeletePod(user.Name, namespace, params.ByName("name"), gracePeriod, dryRun)
+	httpHandler.writeAuditRecord(w, record, err)
+}
+
+func (httpHandler HTTPHandler) DebugPod(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+
+	namespace := params.ByName("namespace")
+	user, ok := httpHandler.authorize(w, r, k8s.ActionDebug, namespace, params.ByName("name"))
+	if !ok || !httpHandler.authorizeRole(w, user, k8s.RoleExec, k8s.ActionDebug, namespace, params.ByName("name")) {
+		return
+	}
+
+	image := r.URL.Query().Get("image")
+	target := r.URL.Query().Get("target")
+	dryRun := r.URL.Query().Get("dryRun") == "true"
+
+	record, err := httpHandler.k8sHandler.DebugPod(user.Name, namespace, params.ByName("name"), image, target, dryRun)
+	httpHandler.writeAuditRecord(w, record, err)
+}
@@ -0,0 +1,682 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"github.com/robfig/cron/v3"
+	cm "github.com/royroyee/kubem/common"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"log"
+	"os"
+	"regexp"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rules file, e.g.
+//
+//	rules:
+//	  - name: NodeNotReady
+//	    kind: node_not_ready
+//	    for: 2m
+//	    severity: critical
+//	  - name: PodRestarting
+//	    kind: pod_restarts
+//	    threshold: 5
+//	    window: 10m
+//	  - name: NodeHighCpu
+//	    kind: node_cpu
+//	    threshold: 90
+//	    for: 15m
+//	  - name: BackOff
+//	    kind: warning_event
+//	    reason: BackOff|FailedMount
+//	  - name: VolumeFull
+//	    kind: volume_fill
+//	    threshold: 90
+//	  - name: CronJobFailing
+//	    kind: cronjob_failing
+//	    threshold: 3
+//	  - name: CertificateExpiring
+//	    kind: cert_expiry
+//	    threshold: 14
+var alertRulesPath = cm.GetEnv("KUBEM_ALERT_RULES", "alert-rules.yaml")
+
+const (
+	alertInterval = 30 * time.Second
+
+	AlertPending  = "pending"
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+
+	defaultRestartWindow = 10 * time.Minute
+	defaultEventWindow   = 5 * time.Minute
+	// a single late run is not a failure yet
+	defaultCronJobThreshold = 2
+	// older node usage samples are missing, the node stopped reporting
+	nodeUsageMaxAge = 5 * time.Minute
+)
+
+type alertRule struct {
+	cm.AlertRule
+	forDuration time.Duration
+	window      time.Duration
+	reason      *regexp.Regexp
+}
+
+// A subject for which the condition of a rule currently holds
+type alertCandidate struct {
+	subject   string
+	namespace string
+	message   string
+}
+
+type alertEvaluator func(kh K8sHandler, rule alertRule, now time.Time) ([]alertCandidate, error)
+
+// Evaluator of every rule kind
+var alertEvaluators = map[string]alertEvaluator{
+	"node_not_ready":  evaluateNodeNotReady,
+	"pod_restarts":    evaluatePodRestarts,
+	"node_cpu":        evaluateNodeCpu,
+	"node_ram":        evaluateNodeRam,
+	"warning_event":   evaluateWarningEvent,
+	"volume_fill":     evaluateVolumeFill,
+	"pvc_pending":     evaluatePVCPending,
+	"cronjob_failing": evaluateCronJobFailing,
+	"cert_expiry":     evaluateCertExpiry,
+}
+
+type restartSample struct {
+	restarts  int32
+	timestamp time.Time
+}
+
+// State of the alerting, shared by every copy of the K8sHandler
+type AlertEngine struct {
+	mutex         sync.Mutex
+	rules         []alertRule
+	rulesModTime  time.Time
+	active        map[string]*cm.Alert
+	restarts      map[string][]restartSample
+	restartWindow time.Duration
+}
+
+func NewAlertEngine() *AlertEngine {
+	return &AlertEngine{
+		active:   make(map[string]*cm.Alert),
+		restarts: make(map[string][]restartSample),
+	}
+}
+
+// Evaluate the rules periodically, the rules file is reloaded whenever it changes
+func (kh K8sHandler) EvaluateAlerts() {
+
+	ticker := time.NewTicker(alertInterval)
+	defer ticker.Stop()
+
+	for ; true; <-ticker.C {
+		info, err := os.Stat(alertRulesPath)
+		if err == nil && !info.ModTime().Equal(kh.alerts.modTime()) {
+			if err := kh.ReloadAlertRules(); err != nil {
+				log.Println(err)
+			}
+		}
+		kh.evaluateAlerts(time.Now())
+	}
+}
+
+func (kh K8sHandler) ReloadAlertRules() error {
+
+	info, err := os.Stat(alertRulesPath)
+	if err != nil {
+		return fmt.Errorf("Failed to read alert rules: %v", err)
+	}
+	data, err := os.ReadFile(alertRulesPath)
+	if err != nil {
+		return fmt.Errorf("Failed to read alert rules: %v", err)
+	}
+	rules, err := parseAlertRules(data)
+
+	engine := kh.alerts
+	engine.mutex.Lock()
+	defer engine.mutex.Unlock()
+
+	// do not retry a broken file until it changes again
+	engine.rulesModTime = info.ModTime()
+	if err != nil {
+		return err
+	}
+
+	engine.rules = rules
+	engine.restartWindow = 0
+	for _, rule := range rules {
+		if rule.Kind == "pod_restarts" && rule.window > engine.restartWindow {
+			engine.restartWindow = rule.window
+		}
+	}
+	log.Printf("Loaded %d alert rules", len(rules))
+	return nil
+}
+
+func parseAlertRules(data []byte) ([]alertRule, error) {
+	var file struct {
+		Rules []cm.AlertRule `json:"rules"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("Failed to parse alert rules: %v", err)
+	}
+
+	var result []alertRule
+	names := make(map[string]bool)
+	for _, rule := range file.Rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("Alert rule without name")
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("Duplicate alert rule %v", rule.Name)
+		}
+		names[rule.Name] = true
+
+		if _, ok := alertEvaluators[rule.Kind]; !ok {
+			return nil, fmt.Errorf("Invalid kind %v of alert rule %v", rule.Kind, rule.Name)
+		}
+		if rule.Severity == "" {
+			rule.Severity = "warning"
+		}
+
+		compiled := alertRule{AlertRule: rule}
+		if rule.For != "" {
+			duration, err := time.ParseDuration(rule.For)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid for of alert rule %v: %v", rule.Name, err)
+			}
+			compiled.forDuration = duration
+		}
+		switch rule.Kind {
+		case "pod_restarts":
+			compiled.window = defaultRestartWindow
+		case "warning_event":
+			compiled.window = defaultEventWindow
+		}
+		if rule.Window != "" {
+			duration, err := time.ParseDuration(rule.Window)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid window of alert rule %v: %v", rule.Name, err)
+			}
+			compiled.window = duration
+		}
+		if rule.Reason != "" {
+			reason, err := regexp.Compile(rule.Reason)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid reason of alert rule %v: %v", rule.Name, err)
+			}
+			compiled.reason = reason
+		}
+		result = append(result, compiled)
+	}
+	return result, nil
+}
+
+func (engine *AlertEngine) modTime() time.Time {
+	engine.mutex.Lock()
+	defer engine.mutex.Unlock()
+	return engine.rulesModTime
+}
+
+// Move every alert through pending, firing and resolved according to the current candidates of its rule.
+// The evaluators run with the engine unlocked, they may call the API server, the kubelets and the database
+func (kh K8sHandler) evaluateAlerts(now time.Time) {
+	engine := kh.alerts
+	engine.mutex.Lock()
+	rules := engine.rules
+	engine.mutex.Unlock()
+
+	results := make([][]alertCandidate, len(rules))
+	failed := make([]bool, len(rules))
+	for i, rule := range rules {
+		candidates, err := alertEvaluators[rule.Kind](kh, rule, now)
+		if err != nil {
+			log.Printf("Failed to evaluate alert rule %s: %v", rule.Name, err)
+			failed[i] = true
+		}
+		results[i] = candidates
+	}
+
+	engine.mutex.Lock()
+
+	// stored and notified once the engine is unlocked, the database and the receivers may be slow
+	var transitions []cm.Alert
+	seen := make(map[string]bool)
+
+	for i, rule := range rules {
+		if failed[i] {
+			// keep the current state of the rule until it can be evaluated again
+			for key, alert := range engine.active {
+				if alert.Rule == rule.Name {
+					seen[key] = true
+				}
+			}
+			continue
+		}
+
+		for _, candidate := range results[i] {
+			key := rule.Name + "/" + candidate.namespace + "/" + candidate.subject
+			seen[key] = true
+
+			alert, ok := engine.active[key]
+			if !ok {
+				alert = &cm.Alert{
+					Rule:        rule.Name,
+					Severity:    rule.Severity,
+					Subject:     candidate.subject,
+					Namespace:   candidate.namespace,
+					Labels:      rule.Labels,
+					State:       AlertPending,
+					ActiveSince: now,
+				}
+				engine.active[key] = alert
+			}
+			alert.Message = candidate.message
+
+			if !ok && rule.forDuration > 0 {
+				transitions = append(transitions, *alert)
+			}
+			if alert.State == AlertPending && now.Sub(alert.ActiveSince) >= rule.forDuration {
+				alert.State = AlertFiring
+				alert.FiredAt = now
+				transitions = append(transitions, *alert)
+			}
+		}
+	}
+
+	for key, alert := range engine.active {
+		if seen[key] {
+			continue
+		}
+		// pending alerts which never fired are dropped silently
+		if alert.State == AlertFiring {
+			alert.State = AlertResolved
+			transitions = append(transitions, *alert)
+		}
+		delete(engine.active, key)
+	}
+	engine.mutex.Unlock()
+
+	for _, alert := range transitions {
+		kh.alertTransition(alert, now)
+	}
+}
+
+// Record the new state of an alert, and notify the receivers when it fires unless it is silenced.
+// A resolved alert is always passed on, to replace its queued firing copy; a silenced one is dropped when sent
+func (kh K8sHandler) alertTransition(alert cm.Alert, now time.Time) {
+	var silencedBy string
+	if alert.State != AlertPending {
+		silencedBy = kh.silencedBy(alert, now)
+	}
+
+	kh.StoreAlertTransitionInDB(cm.AlertTransition{
+		Rule:       alert.Rule,
+		Severity:   alert.Severity,
+		Subject:    alert.Subject,
+		Namespace:  alert.Namespace,
+		Message:    alert.Message,
+		Labels:     alert.Labels,
+		State:      alert.State,
+		Timestamp:  now,
+		SilencedBy: silencedBy,
+	})
+
+	if alert.State == AlertResolved || (alert.State == AlertFiring && silencedBy == "") {
+		kh.notifier.notify(alert, now)
+	}
+}
+
+// Pending and firing alerts
+func (kh K8sHandler) GetAlerts() []cm.Alert {
+	engine := kh.alerts
+	engine.mutex.Lock()
+	defer engine.mutex.Unlock()
+
+	result := []cm.Alert{}
+	for _, alert := range engine.active {
+		result = append(result, *alert)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ActiveSince.Before(result[j].ActiveSince) })
+	return result
+}
+
+func (kh K8sHandler) GetAlertRules() []cm.AlertRule {
+	engine := kh.alerts
+	engine.mutex.Lock()
+	defer engine.mutex.Unlock()
+
+	result := []cm.AlertRule{}
+	for _, rule := range engine.rules {
+		result = append(result, rule.AlertRule)
+	}
+	return result
+}
+
+func evaluateNodeNotReady(kh K8sHandler, rule alertRule, now time.Time) ([]alertCandidate, error) {
+	var result []alertCandidate
+
+	nodes, err := kh.informers.Core().V1().Nodes().Lister().List(labels.Everything())
+	if err != nil {
+		return result, err
+	}
+
+	for _, node := range nodes {
+		if isNodeReady(node) {
+			continue
+		}
+		message := fmt.Sprintf("Node %s is not ready", node.Name)
+		for _, condition := range node.Status.Conditions {
+			if condition.Type == corev1.NodeReady && condition.Message != "" {
+				message = fmt.Sprintf("Node %s is not ready: %s", node.Name, condition.Message)
+			}
+		}
+		result = append(result, alertCandidate{subject: node.Name, message: message})
+	}
+	return result, nil
+}
+
+// Pods which restarted more than threshold times within the window
+func evaluatePodRestarts(kh K8sHandler, rule alertRule, now time.Time) ([]alertCandidate, error) {
+	var result []alertCandidate
+	engine := kh.alerts
+
+	pods, err := kh.informers.Core().V1().Pods().Lister().List(labels.Everything())
+	if err != nil {
+		return result, err
+	}
+
+	engine.mutex.Lock()
+	defer engine.mutex.Unlock()
+
+	exists := make(map[string]bool)
+	for _, pod := range pods {
+		key := pod.Namespace + "/" + pod.Name
+		exists[key] = true
+
+		// the samples are shared by every pod_restarts rule, only sample once per evaluation
+		samples := engine.restarts[key]
+		restarts := podRestarts(pod)
+		if len(samples) == 0 || !samples[len(samples)-1].timestamp.Equal(now) {
+			samples = append(samples, restartSample{restarts: restarts, timestamp: now})
+		}
+		for len(samples) > 1 && now.Sub(samples[0].timestamp) > engine.restartWindow {
+			samples = samples[1:]
+		}
+		engine.restarts[key] = samples
+
+		if rule.Namespace != "" && pod.Namespace != rule.Namespace {
+			continue
+		}
+
+		// oldest sample within the window of this rule
+		baseline := samples[len(samples)-1]
+		for _, sample := range samples {
+			if now.Sub(sample.timestamp) <= rule.window {
+				baseline = sample
+				break
+			}
+		}
+		increase := restarts - baseline.restarts
+		if float64(increase) > rule.Threshold {
+			result = append(result, alertCandidate{
+				subject:   pod.Name,
+				namespace: pod.Namespace,
+				message:   fmt.Sprintf("Pod %s restarted %d times in %s", pod.Name, increase, rule.window),
+			})
+		}
+	}
+
+	for key := range engine.restarts {
+		if !exists[key] {
+			delete(engine.restarts, key)
+		}
+	}
+	return result, nil
+}
+
+func evaluateNodeCpu(kh K8sHandler, rule alertRule, now time.Time) ([]alertCandidate, error) {
+	var result []alertCandidate
+
+	usages, err := kh.nodeUsages(now)
+	if err != nil {
+		return result, err
+	}
+	for _, usage := range usages {
+		if usage.cpu > rule.Threshold {
+			result = append(result, alertCandidate{
+				subject: usage.name,
+				message: fmt.Sprintf("Node %s cpu usage is %.1f%%", usage.name, usage.cpu),
+			})
+		}
+	}
+	return result, nil
+}
+
+func evaluateNodeRam(kh K8sHandler, rule alertRule, now time.Time) ([]alertCandidate, error) {
+	var result []alertCandidate
+
+	usages, err := kh.nodeUsages(now)
+	if err != nil {
+		return result, err
+	}
+	for _, usage := range usages {
+		if usage.ram > rule.Threshold {
+			result = append(result, alertCandidate{
+				subject: usage.name,
+				message: fmt.Sprintf("Node %s ram usage is %.1f%%", usage.name, usage.ram),
+			})
+		}
+	}
+	return result, nil
+}
+
+// Usage of a node in percent of its allocatable resources
+type nodeUsage struct {
+	name string
+	cpu  float64
+	ram  float64
+}
+
+// Latest stored usage of every node. Nodes whose samples are stale are left out,
+// so that the last sample of a node which stopped reporting does not keep an alert firing
+func (kh K8sHandler) nodeUsages(now time.Time) ([]nodeUsage, error) {
+	var result []nodeUsage
+
+	usages, err := kh.latestNodeUsage(now.Add(-nodeUsageMaxAge))
+	if err != nil {
+		return result, err
+	}
+	for _, usage := range usages {
+		result = append(result, nodeUsage{name: usage.Name, cpu: usage.CpuUsage, ram: usage.RamUsage})
+	}
+	return result, nil
+}
+
+// Objects with a Warning event whose reason matches, seen within the window
+func evaluateWarningEvent(kh K8sHandler, rule alertRule, now time.Time) ([]alertCandidate, error) {
+	var result []alertCandidate
+
+	events, err := kh.informers.Core().V1().Events().Lister().List(labels.Everything())
+	if err != nil {
+		return result, err
+	}
+
+	subjects := make(map[string]bool)
+	for _, event := range events {
+		if event.Type != corev1.EventTypeWarning {
+			continue
+		}
+		if rule.reason != nil && !rule.reason.MatchString(event.Reason) {
+			continue
+		}
+		if rule.Namespace != "" && event.InvolvedObject.Namespace != rule.Namespace {
+			continue
+		}
+		if now.Sub(eventTime(event)) > rule.window {
+			continue
+		}
+
+		subject := event.InvolvedObject.Kind + "/" + event.InvolvedObject.Name
+		key := event.InvolvedObject.Namespace + "/" + subject
+		if subjects[key] {
+			continue
+		}
+		subjects[key] = true
+
+		result = append(result, alertCandidate{
+			subject:   subject,
+			namespace: event.InvolvedObject.Namespace,
+			message:   fmt.Sprintf("%s: %s", event.Reason, event.Message),
+		})
+	}
+	return result, nil
+}
+
+// When the event was last seen
+func eventTime(event *corev1.Event) time.Time {
+	if !event.LastTimestamp.IsZero() {
+		return event.LastTimestamp.Time
+	}
+	if !event.EventTime.IsZero() {
+		return event.EventTime.Time
+	}
+	return event.CreationTimestamp.Time
+}
+
+// Claims whose space or inodes are used above the threshold, KUBEM_VOLUME_FILL_THRESHOLD if the rule has none
+func evaluateVolumeFill(kh K8sHandler, rule alertRule, now time.Time) ([]alertCandidate, error) {
+	var result []alertCandidate
+
+	claims, err := kh.pvcInfos(rule.Namespace, "")
+	if err != nil {
+		return result, err
+	}
+	threshold := rule.Threshold
+	if threshold == 0 {
+		threshold = volumeFillThreshold
+	}
+	for _, claim := range claims {
+		if claim.Usage == nil {
+			continue
+		}
+		if claim.Usage.UsedPercent >= threshold || claim.Usage.InodesUsedPercent >= threshold {
+			result = append(result, alertCandidate{
+				subject:   claim.Name,
+				namespace: claim.Namespace,
+				message: fmt.Sprintf("Volume of claim %s/%s is %.1f%% full (%.1f%% of the inodes)",
+					claim.Namespace, claim.Name, claim.Usage.UsedPercent, claim.Usage.InodesUsedPercent),
+			})
+		}
+	}
+	return result, nil
+}
+
+func evaluatePVCPending(kh K8sHandler, rule alertRule, now time.Time) ([]alertCandidate, error) {
+	var result []alertCandidate
+
+	claims, err := kh.K8sClient.CoreV1().PersistentVolumeClaims(rule.Namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return result, err
+	}
+	for _, claim := range claims.Items {
+		if claim.Status.Phase == corev1.ClaimPending {
+			result = append(result, alertCandidate{
+				subject:   claim.Name,
+				namespace: claim.Namespace,
+				message:   fmt.Sprintf("Claim %s/%s is pending", claim.Namespace, claim.Name),
+			})
+		}
+	}
+	return result, nil
+}
+
+// CronJobs which have not succeeded for at least threshold schedules (default 2)
+func evaluateCronJobFailing(kh K8sHandler, rule alertRule, now time.Time) ([]alertCandidate, error) {
+	var result []alertCandidate
+
+	cronJobs, err := kh.informers.Batch().V1().CronJobs().Lister().CronJobs(rule.Namespace).List(labels.Everything())
+	if err != nil {
+		return result, err
+	}
+	threshold := int(rule.Threshold)
+	if threshold < 1 {
+		threshold = defaultCronJobThreshold
+	}
+	for _, cronJob := range cronJobs {
+		if cronJob.Spec.Suspend != nil && *cronJob.Spec.Suspend {
+			continue
+		}
+		schedule, err := cronJobSchedule(cronJob)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if intervals := failedIntervals(cronJob, schedule, now); intervals >= threshold {
+			result = append(result, alertCandidate{
+				subject:   cronJob.Name,
+				namespace: cronJob.Namespace,
+				message:   fmt.Sprintf("CronJob %s/%s has not succeeded in %d intervals", cronJob.Namespace, cronJob.Name, intervals),
+			})
+		}
+	}
+	return result, nil
+}
+
+// Schedules since the last success, without the latest one while its run is still active
+func failedIntervals(cronJob *batchv1.CronJob, schedule cron.Schedule, now time.Time) int {
+	since := cronJob.CreationTimestamp.Time
+	if cronJob.Status.LastSuccessfulTime != nil {
+		since = cronJob.Status.LastSuccessfulTime.Time
+	}
+	intervals := countSchedules(schedule, since, now)
+	if len(cronJob.Status.Active) > 0 && intervals > 0 {
+		intervals--
+	}
+	return intervals
+}
+
+// Certificates of TLS Secrets expiring within threshold days, or in KUBEM_CERT_WARNING_DAYS by default
+func evaluateCertExpiry(kh K8sHandler, rule alertRule, now time.Time) ([]alertCandidate, error) {
+	var result []alertCandidate
+
+	certificates, err := kh.certificateInfos(rule.Namespace, "", now)
+	if err != nil {
+		return result, err
+	}
+	threshold := int(rule.Threshold)
+	if threshold == 0 {
+		threshold = certWarningDays
+	}
+	for _, certificate := range certificates {
+		var message string
+		switch {
+		case certificate.Status == CertExpired:
+			message = fmt.Sprintf("Certificate of Secret %s/%s expired on %s", certificate.Namespace, certificate.Secret, certificate.NotAfter)
+		case certificate.Status != CertInvalid && certificate.DaysLeft < threshold:
+			message = fmt.Sprintf("Certificate of Secret %s/%s expires in %d days on %s", certificate.Namespace, certificate.Secret, certificate.DaysLeft, certificate.NotAfter)
+		default:
+			continue
+		}
+		if len(certificate.Ingresses) > 0 {
+			message += fmt.Sprintf(", used by Ingress %s", strings.Join(certificate.Ingresses, ", "))
+		}
+		result = append(result, alertCandidate{
+			subject:   certificate.Secret,
+			namespace: certificate.Namespace,
+			message:   message,
+		})
+	}
+	return result, nil
+}
@@ -0,0 +1,77 @@
+package k8s
+
+import (
+	"crypto/subtle"
+	"fmt"
+	cm "github.com/royroyee/kubem/common"
+	"log"
+	"os"
+	"sigs.k8s.io/yaml"
+)
+
+// Users allowed to run write operations, write operations are disabled without this file, e.g.
+//
+//	users:
+//	  - name: alice
+//	    token: 6f0c3b1e9d...
+//	  - name: bob
+//	    token: 91ad77c2e4...
+//	    namespaces: [web, payments]
+//	    roles: [exec]
+var usersPath = cm.GetEnv("KUBEM_USERS", "users.yaml")
+
+// Action of the rejected attempts to read the audit records
+const ActionReadAudit = "read-audit"
+
+// Role needed to exec into or attach to a container, or to add a debug container to a pod
+const RoleExec = "exec"
+
+// User of the bearer token, the users file is read on every call so that tokens can be revoked without restart
+func (kh K8sHandler) Authenticate(token string) (cm.User, error) {
+	if token == "" {
+		return cm.User{}, fmt.Errorf("Missing bearer token")
+	}
+
+	data, err := os.ReadFile(usersPath)
+	if err != nil {
+		log.Printf("Failed to read users: %v", err)
+		return cm.User{}, fmt.Errorf("Write operations are disabled, no users are configured")
+	}
+	var file struct {
+		Users []cm.User `json:"users"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		log.Printf("Failed to parse users: %v", err)
+		return cm.User{}, fmt.Errorf("Write operations are disabled, no users are configured")
+	}
+
+	for _, user := range file.Users {
+		if user.Token != "" && subtle.ConstantTimeCompare([]byte(user.Token), []byte(token)) == 1 {
+			return user, nil
+		}
+	}
+	return cm.User{}, fmt.Errorf("Invalid token")
+}
+
+// Users limited to namespaces may not change cluster scoped objects, whose namespace is empty
+func (kh K8sHandler) Authorize(user cm.User, namespace string) error {
+	if len(user.Namespaces) > 0 && !contains(user.Namespaces, namespace) {
+		if namespace == "" {
+			return fmt.Errorf("User %v may not change cluster scoped objects", user.Name)
+		}
+		return fmt.Errorf("User %v may not change namespace %v", user.Name, namespace)
+	}
+	return nil
+}
+
+func (kh K8sHandler) AuthorizeRole(user cm.User, role string) error {
+	if !contains(user.Roles, role) {
+		return fmt.Errorf("User %v does not have the %v role", user.Name, role)
+	}
+	return nil
+}
+
+// Record an attempt which was not authenticated or not authorized
+func (kh K8sHandler) AuditDenied(record cm.AuditRecord, err error) {
+	kh.audit(record, err)
+}
@@ -0,0 +1,266 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	cm "github.com/royroyee/kubem/common"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Record every change of the desired replicas of the autoscalers
+func (kh K8sHandler) TrackAutoscalers() {
+	kh.informers.Autoscaling().V2().HorizontalPodAutoscalers().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			before, ok1 := oldObj.(*autoscalingv2.HorizontalPodAutoscaler)
+			after, ok2 := newObj.(*autoscalingv2.HorizontalPodAutoscaler)
+			if !ok1 || !ok2 || before.Status.DesiredReplicas == after.Status.DesiredReplicas {
+				return
+			}
+			info := autoscalerInfo(after)
+			kh.StoreScaleEventInDB(cm.ScaleEvent{
+				Namespace: after.Namespace,
+				Name:      after.Name,
+				Target:    info.Target,
+				From:      before.Status.DesiredReplicas,
+				To:        after.Status.DesiredReplicas,
+				Reason:    scaleReason(after),
+				Metrics:   info.Metrics,
+				Timestamp: time.Now(),
+			})
+		},
+	})
+}
+
+// Why the autoscaler chose its desired replicas, as reported by its conditions
+func scaleReason(autoscaler *autoscalingv2.HorizontalPodAutoscaler) string {
+	var ableToScale string
+	for _, condition := range autoscaler.Status.Conditions {
+		switch condition.Type {
+		case autoscalingv2.ScalingLimited:
+			if condition.Status == corev1.ConditionTrue {
+				return condition.Message
+			}
+		case autoscalingv2.AbleToScale:
+			ableToScale = condition.Message
+		}
+	}
+	return ableToScale
+}
+
+// Autoscalers of the namespace (all namespaces if empty)
+func (kh K8sHandler) GetAutoscalers(namespace string, page int, perPage int) ([]cm.AutoscalerInfo, error) {
+	result := []cm.AutoscalerInfo{}
+
+	autoscalers, err := kh.informers.Autoscaling().V2().HorizontalPodAutoscalers().Lister().HorizontalPodAutoscalers(namespace).List(labels.Everything())
+	if err != nil {
+		return result, err
+	}
+	for _, autoscaler := range autoscalers {
+		result = append(result, autoscalerInfo(autoscaler))
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Namespace+"/"+result[i].Name < result[j].Namespace+"/"+result[j].Name
+	})
+
+	start, end := pageBounds(len(result), page, perPage)
+	return result[start:end], nil
+}
+
+func (kh K8sHandler) GetAutoscaler(namespace string, name string) (cm.AutoscalerInfo, error) {
+	autoscaler, err := kh.informers.Autoscaling().V2().HorizontalPodAutoscalers().Lister().HorizontalPodAutoscalers(namespace).Get(name)
+	if err != nil {
+		return cm.AutoscalerInfo{}, err
+	}
+	return autoscalerInfo(autoscaler), nil
+}
+
+// Scale events of the autoscaler since the given time, with the cpu usage of the pods of its target
+func (kh K8sHandler) GetScalingHistory(namespace string, name string, since time.Time) (cm.ScalingHistory, error) {
+	var result cm.ScalingHistory
+
+	autoscaler, err := kh.informers.Autoscaling().V2().HorizontalPodAutoscalers().Lister().HorizontalPodAutoscalers(namespace).Get(name)
+	if err != nil {
+		return result, err
+	}
+	result.Autoscaler = autoscalerInfo(autoscaler)
+
+	result.Events, err = kh.GetScaleEvents(namespace, name, since)
+	if err != nil {
+		return result, err
+	}
+
+	selector, err := kh.targetSelector(autoscaler)
+	if err != nil {
+		// the replicas are still useful without the usage
+		log.Println(err)
+		return result, nil
+	}
+
+	points := make(map[time.Time]*cm.WorkloadUsagePoint)
+	err = kh.iterPodUsageSnapshots(since, time.Now(), func(snapshot cm.PodUsageSnapshot) {
+		if snapshot.Namespace != namespace || !selector.Matches(snapshotLabels(snapshot)) {
+			return
+		}
+		point, ok := points[snapshot.Timestamp]
+		if !ok {
+			point = &cm.WorkloadUsagePoint{Timestamp: snapshot.Timestamp}
+			points[snapshot.Timestamp] = point
+		}
+		point.Pods++
+		point.CpuUsage += snapshot.CpuUsage
+		point.CpuRequests += snapshot.CpuRequests
+	})
+	if err != nil {
+		return result, err
+	}
+	for _, point := range points {
+		result.Usage = append(result.Usage, *point)
+	}
+	sort.Slice(result.Usage, func(i, j int) bool { return result.Usage[i].Timestamp.Before(result.Usage[j].Timestamp) })
+
+	return result, nil
+}
+
+// Selector of the pods of the scale target, from its scale subresource
+func (kh K8sHandler) targetSelector(autoscaler *autoscalingv2.HorizontalPodAutoscaler) (labels.Selector, error) {
+	target := autoscaler.Spec.ScaleTargetRef
+	ctx := context.TODO()
+
+	var scale *autoscalingv1.Scale
+	var err error
+	switch target.Kind {
+	case "Deployment":
+		scale, err = kh.K8sClient.AppsV1().Deployments(autoscaler.Namespace).GetScale(ctx, target.Name, metav1.GetOptions{})
+	case "StatefulSet":
+		scale, err = kh.K8sClient.AppsV1().StatefulSets(autoscaler.Namespace).GetScale(ctx, target.Name, metav1.GetOptions{})
+	case "ReplicaSet":
+		scale, err = kh.K8sClient.AppsV1().ReplicaSets(autoscaler.Namespace).GetScale(ctx, target.Name, metav1.GetOptions{})
+	default:
+		return nil, fmt.Errorf("Unsupported scale target %v/%v", target.Kind, target.Name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return labels.Parse(scale.Status.Selector)
+}
+
+// Labels of a snapshot, stored as key=value
+func snapshotLabels(snapshot cm.PodUsageSnapshot) labels.Set {
+	result := labels.Set{}
+	for _, label := range snapshot.Labels {
+		parts := strings.SplitN(label, "=", 2)
+		if len(parts) == 2 {
+			result[parts[0]] = parts[1]
+		}
+	}
+	return result
+}
+
+func autoscalerInfo(autoscaler *autoscalingv2.HorizontalPodAutoscaler) cm.AutoscalerInfo {
+	result := cm.AutoscalerInfo{
+		Namespace:       autoscaler.Namespace,
+		Name:            autoscaler.Name,
+		Target:          autoscaler.Spec.ScaleTargetRef.Kind + "/" + autoscaler.Spec.ScaleTargetRef.Name,
+		MinReplicas:     1,
+		MaxReplicas:     autoscaler.Spec.MaxReplicas,
+		CurrentReplicas: autoscaler.Status.CurrentReplicas,
+		DesiredReplicas: autoscaler.Status.DesiredReplicas,
+		Metrics:         autoscalerMetrics(autoscaler),
+	}
+	if autoscaler.Spec.MinReplicas != nil {
+		result.MinReplicas = *autoscaler.Spec.MinReplicas
+	}
+	if autoscaler.Status.LastScaleTime != nil {
+		result.LastScaleTime = autoscaler.Status.LastScaleTime.Format("2006-01-02 15:04")
+	}
+	for _, condition := range autoscaler.Status.Conditions {
+		result.Conditions = append(result.Conditions, cm.Conditions{
+			Type:   string(condition.Type),
+			Status: string(condition.Status),
+			Reason: condition.Reason,
+		})
+	}
+	return result
+}
+
+// Every metric of the spec with its current value from the status
+func autoscalerMetrics(autoscaler *autoscalingv2.HorizontalPodAutoscaler) []cm.AutoscalerMetric {
+	var result []cm.AutoscalerMetric
+
+	current := make(map[string]string)
+	for _, status := range autoscaler.Status.CurrentMetrics {
+		switch {
+		case status.Resource != nil:
+			current[metricKey(status.Type, string(status.Resource.Name))] = metricValue(status.Resource.Current)
+		case status.ContainerResource != nil:
+			current[metricKey(status.Type, status.ContainerResource.Container+"/"+string(status.ContainerResource.Name))] = metricValue(status.ContainerResource.Current)
+		case status.Pods != nil:
+			current[metricKey(status.Type, status.Pods.Metric.Name)] = metricValue(status.Pods.Current)
+		case status.Object != nil:
+			current[metricKey(status.Type, status.Object.Metric.Name)] = metricValue(status.Object.Current)
+		case status.External != nil:
+			current[metricKey(status.Type, status.External.Metric.Name)] = metricValue(status.External.Current)
+		}
+	}
+
+	for _, spec := range autoscaler.Spec.Metrics {
+		metric := cm.AutoscalerMetric{Type: string(spec.Type)}
+		switch {
+		case spec.Resource != nil:
+			metric.Name = string(spec.Resource.Name)
+			metric.Target = metricTarget(spec.Resource.Target)
+		case spec.ContainerResource != nil:
+			metric.Name = spec.ContainerResource.Container + "/" + string(spec.ContainerResource.Name)
+			metric.Target = metricTarget(spec.ContainerResource.Target)
+		case spec.Pods != nil:
+			metric.Name = spec.Pods.Metric.Name
+			metric.Target = metricTarget(spec.Pods.Target)
+		case spec.Object != nil:
+			metric.Name = spec.Object.Metric.Name
+			metric.Target = metricTarget(spec.Object.Target)
+		case spec.External != nil:
+			metric.Name = spec.External.Metric.Name
+			metric.Target = metricTarget(spec.External.Target)
+		}
+		metric.Current = current[metricKey(spec.Type, metric.Name)]
+		result = append(result, metric)
+	}
+	return result
+}
+
+func metricKey(metricType autoscalingv2.MetricSourceType, name string) string {
+	return string(metricType) + "/" + name
+}
+
+func metricTarget(target autoscalingv2.MetricTarget) string {
+	switch {
+	case target.AverageUtilization != nil:
+		return fmt.Sprintf("%d%%", *target.AverageUtilization)
+	case target.AverageValue != nil:
+		return target.AverageValue.String()
+	case target.Value != nil:
+		return target.Value.String()
+	}
+	return ""
+}
+
+func metricValue(value autoscalingv2.MetricValueStatus) string {
+	switch {
+	case value.AverageUtilization != nil:
+		return fmt.Sprintf("%d%%", *value.AverageUtilization)
+	case value.AverageValue != nil:
+		return value.AverageValue.String()
+	case value.Value != nil:
+		return value.Value.String()
+	}
+	return ""
+}
@@ -0,0 +1,220 @@
+package k8s
+
+import (
+	"context"
+	cm "github.com/royroyee/kubem/common"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"log"
+	"sort"
+	"time"
+)
+
+// Label used to group nodes into node pools, nodes without it belong to the "none" pool
+var nodePoolLabel = cm.GetEnv("KUBEM_NODE_POOL_LABEL", "node-pool")
+
+const (
+	unlabeledPool     = "none"
+	capacityInterval  = 10 * time.Minute
+	capacityRetention = 30 * 24 * time.Hour
+)
+
+// Periodically store allocatable and requested resources per node pool, used for forecasting
+func (kh K8sHandler) RecordCapacity() {
+
+	ticker := time.NewTicker(capacityInterval)
+	defer ticker.Stop()
+
+	for ; true; <-ticker.C {
+		_, snapshots, err := kh.clusterCapacity()
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		kh.StoreCapacityInDB(snapshots)
+		kh.deleteCapacityFromDB(capacityRetention)
+	}
+}
+
+func (kh K8sHandler) GetClusterCapacity(days int) (cm.ClusterCapacity, error) {
+
+	result, _, err := kh.clusterCapacity()
+	if err != nil {
+		return result, err
+	}
+
+	history, err := kh.GetCapacityHistory(time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		return result, err
+	}
+	result.Forecast = forecastCapacity(history)
+
+	return result, nil
+}
+
+// Current cluster totals, and a snapshot per node pool plus one for the whole cluster (empty pool)
+func (kh K8sHandler) clusterCapacity() (cm.ClusterCapacity, []cm.CapacitySnapshot, error) {
+	var result cm.ClusterCapacity
+
+	nodes, err := kh.K8sClient.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		log.Println(err)
+		return result, nil, err
+	}
+	pods, err := kh.K8sClient.CoreV1().Pods(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		log.Println(err)
+		return result, nil, err
+	}
+
+	now := time.Now()
+	pools := map[string]*cm.CapacitySnapshot{"": {Pool: "", Timestamp: now}}
+	poolOfNode := make(map[string]string)
+
+	for _, node := range nodes.Items {
+		pool, ok := node.Labels[nodePoolLabel]
+		if !ok {
+			pool = unlabeledPool
+		}
+		poolOfNode[node.Name] = pool
+		if _, ok := pools[pool]; !ok {
+			pools[pool] = &cm.CapacitySnapshot{Pool: pool, Timestamp: now}
+		}
+
+		capacity := node.Status.Capacity
+		allocatable := node.Status.Allocatable
+
+		result.Cpu.Capacity += capacity.Cpu().MilliValue()
+		result.Memory.Capacity += capacity.Memory().Value() / 1024 / 1024
+		result.Pods.Capacity += capacity.Pods().Value()
+		result.Cpu.Allocatable += allocatable.Cpu().MilliValue()
+		result.Memory.Allocatable += allocatable.Memory().Value() / 1024 / 1024
+		result.Pods.Allocatable += allocatable.Pods().Value()
+
+		for _, snapshot := range []*cm.CapacitySnapshot{pools[""], pools[pool]} {
+			snapshot.CpuAllocatable += allocatable.Cpu().MilliValue()
+			snapshot.RamAllocatable += allocatable.Memory().Value() / 1024 / 1024
+		}
+	}
+
+	for _, pod := range pods.Items {
+		// finished pods no longer hold any resources
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		requests, _ := podResources(&pod)
+
+		result.Cpu.Requested += requests.Cpu().MilliValue()
+		result.Memory.Requested += requests.Memory().Value() / 1024 / 1024
+		result.Pods.Requested++
+		if pod.Status.Phase == corev1.PodRunning {
+			result.Pods.Used++
+		}
+
+		snapshots := []*cm.CapacitySnapshot{pools[""]}
+		if pool, ok := poolOfNode[pod.Spec.NodeName]; ok {
+			snapshots = append(snapshots, pools[pool])
+		}
+		for _, snapshot := range snapshots {
+			snapshot.CpuRequested += requests.Cpu().MilliValue()
+			snapshot.RamRequested += requests.Memory().Value() / 1024 / 1024
+		}
+	}
+
+	nodeMetrics, err := kh.MetricK8sClient.MetricsV1beta1().NodeMetricses().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		// usage is optional, metrics-server may not be installed
+		log.Println(err)
+	} else {
+		for _, nodeMetric := range nodeMetrics.Items {
+			result.Cpu.Used += nodeMetric.Usage.Cpu().MilliValue()
+			result.Memory.Used += nodeMetric.Usage.Memory().Value() / 1024 / 1024
+		}
+	}
+
+	var snapshots []cm.CapacitySnapshot
+	for _, snapshot := range pools {
+		snapshots = append(snapshots, *snapshot)
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Pool < snapshots[j].Pool })
+
+	return result, snapshots, nil
+}
+
+// Fit a linear trend over the requested resources of each pool, and estimate when they will exceed the allocatable resources
+func forecastCapacity(history []cm.CapacitySnapshot) []cm.CapacityForecast {
+	var result []cm.CapacityForecast
+
+	byPool := make(map[string][]cm.CapacitySnapshot)
+	var pools []string
+	for _, snapshot := range history {
+		if _, ok := byPool[snapshot.Pool]; !ok {
+			pools = append(pools, snapshot.Pool)
+		}
+		byPool[snapshot.Pool] = append(byPool[snapshot.Pool], snapshot)
+	}
+	sort.Strings(pools)
+
+	for _, pool := range pools {
+		snapshots := byPool[pool]
+		latest := snapshots[len(snapshots)-1]
+
+		var days, cpu, ram []float64
+		for _, snapshot := range snapshots {
+			days = append(days, snapshot.Timestamp.Sub(latest.Timestamp).Hours()/24)
+			cpu = append(cpu, float64(snapshot.CpuRequested))
+			ram = append(ram, float64(snapshot.RamRequested))
+		}
+
+		forecast := cm.CapacityForecast{Pool: pool}
+		if slope, intercept, ok := linearRegression(days, cpu); ok {
+			forecast.CpuTrend = slope
+			forecast.CpuExhaustion = exhaustionTime(latest.Timestamp, slope, intercept, float64(latest.CpuAllocatable))
+		}
+		if slope, intercept, ok := linearRegression(days, ram); ok {
+			forecast.MemoryTrend = slope
+			forecast.MemoryExhaustion = exhaustionTime(latest.Timestamp, slope, intercept, float64(latest.RamAllocatable))
+		}
+		result = append(result, forecast)
+	}
+	return result
+}
+
+// Least squares fit of y = slope * x + intercept, ok is false if there are not enough distinct points
+func linearRegression(xs []float64, ys []float64) (slope float64, intercept float64, ok bool) {
+	n := float64(len(xs))
+	if len(xs) < 2 {
+		return 0, 0, false
+	}
+
+	var sumX, sumY, sumXY, sumXX float64
+	for i := range xs {
+		sumX += xs[i]
+		sumY += ys[i]
+		sumXY += xs[i] * ys[i]
+		sumXX += xs[i] * xs[i]
+	}
+
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return 0, 0, false
+	}
+	slope = (n*sumXY - sumX*sumY) / denominator
+	intercept = (sumY - slope*sumX) / n
+	return slope, intercept, true
+}
+
+// When the trend (in days relative to now) crosses the limit, empty if it does not within ten years
+func exhaustionTime(now time.Time, slope float64, intercept float64, limit float64) string {
+	if intercept >= limit {
+		return now.Format("2006-01-02 15:04")
+	}
+	if slope <= 0 {
+		return ""
+	}
+	days := (limit - intercept) / slope
+	if days > 10*365 {
+		return ""
+	}
+	return now.Add(time.Duration(days * 24 * float64(time.Hour))).Format("2006-01-02 15:04")
+}
@@ -0,0 +1,238 @@
+package k8s
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	cm "github.com/royroyee/kubem/common"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Days before its expiry from which a certificate is reported as expiring
+var certWarningDays = parseCertWarningDays(cm.GetEnv("KUBEM_CERT_WARNING_DAYS", "30"))
+
+const (
+	CertValid    = "valid"
+	CertExpiring = "expiring"
+	CertExpired  = "expired"
+	CertInvalid  = "invalid"
+)
+
+// Certificates of the TLS Secrets by namespace/name, shared by every copy of the K8sHandler.
+// A Secret is only fetched again when its resourceVersion changes, the private key is never kept
+type CertificateCache struct {
+	mutex   sync.Mutex
+	entries map[string]cachedCertificate
+}
+
+type cachedCertificate struct {
+	resourceVersion string
+	data            []byte
+}
+
+func NewCertificateCache() *CertificateCache {
+	return &CertificateCache{entries: make(map[string]cachedCertificate)}
+}
+
+func (cache *CertificateCache) get(key string, resourceVersion string) ([]byte, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	entry, ok := cache.entries[key]
+	if !ok || entry.resourceVersion != resourceVersion {
+		return nil, false
+	}
+	return entry.data, true
+}
+
+func (cache *CertificateCache) set(key string, resourceVersion string, data []byte) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	cache.entries[key] = cachedCertificate{resourceVersion: resourceVersion, data: data}
+}
+
+// Forget the Secrets of the namespace (all namespaces if empty) which no longer exist
+func (cache *CertificateCache) prune(namespace string, exists map[string]bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	for key := range cache.entries {
+		if (namespace == "" || strings.HasPrefix(key, namespace+"/")) && !exists[key] {
+			delete(cache.entries, key)
+		}
+	}
+}
+
+func parseCertWarningDays(value string) int {
+	days, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid KUBEM_CERT_WARNING_DAYS %v: %v", value, err)
+		return 30
+	}
+	return days
+}
+
+// Certificates of the TLS Secrets of the namespace (all namespaces if empty) which expire first,
+// only those with the given status if not empty
+func (kh K8sHandler) GetCertificates(namespace string, status string, page int, perPage int) ([]cm.CertificateInfo, error) {
+	result := []cm.CertificateInfo{}
+
+	certificates, err := kh.certificateInfos(namespace, "", time.Now())
+	if err != nil {
+		return result, err
+	}
+	for _, certificate := range certificates {
+		if status != "" && certificate.Status != status {
+			continue
+		}
+		result = append(result, certificate)
+	}
+
+	start, end := pageBounds(len(result), page, perPage)
+	return result[start:end], nil
+}
+
+func (kh K8sHandler) GetCertificate(namespace string, name string) (cm.CertificateInfo, error) {
+	certificates, err := kh.certificateInfos(namespace, name, time.Now())
+	if err != nil {
+		return cm.CertificateInfo{}, err
+	}
+	if len(certificates) == 0 {
+		return cm.CertificateInfo{}, fmt.Errorf("TLS Secret %v/%v not found", namespace, name)
+	}
+	return certificates[0], nil
+}
+
+// Certificates of the TLS Secrets with the Ingresses using them, only the named Secret if name is not empty.
+// The Secrets are listed by metadata, only those which changed since the last call are fetched
+func (kh K8sHandler) certificateInfos(namespace string, name string, now time.Time) ([]cm.CertificateInfo, error) {
+	var result []cm.CertificateInfo
+	ctx := context.TODO()
+
+	selector := "type=" + string(corev1.SecretTypeTLS)
+	if name != "" {
+		selector += ",metadata.name=" + name
+	}
+	secrets, err := kh.metadataClient.Resource(corev1.SchemeGroupVersion.WithResource("secrets")).Namespace(namespace).List(ctx, metav1.ListOptions{FieldSelector: selector})
+	if err != nil {
+		log.Println(err)
+		return result, err
+	}
+
+	ingresses, err := kh.informers.Networking().V1().Ingresses().Lister().Ingresses(namespace).List(labels.Everything())
+	if err != nil {
+		return result, err
+	}
+	users := make(map[string][]string)
+	for _, ingress := range ingresses {
+		for _, tls := range ingress.Spec.TLS {
+			key := ingress.Namespace + "/" + tls.SecretName
+			if tls.SecretName != "" && !contains(users[key], ingress.Name) {
+				users[key] = append(users[key], ingress.Name)
+			}
+		}
+	}
+
+	exists := make(map[string]bool)
+	for _, secret := range secrets.Items {
+		key := secret.Namespace + "/" + secret.Name
+		exists[key] = true
+
+		data, ok := kh.certificates.get(key, secret.ResourceVersion)
+		if !ok {
+			data, err = kh.certificateData(ctx, secret.Namespace, secret.Name)
+			if err != nil {
+				// deleted since it was listed
+				continue
+			}
+			kh.certificates.set(key, secret.ResourceVersion, data)
+		}
+
+		info := certificateInfo(data, now)
+		info.Namespace = secret.Namespace
+		info.Secret = secret.Name
+		info.Ingresses = users[secret.Namespace+"/"+secret.Name]
+		if info.Ingresses == nil {
+			info.Ingresses = []string{}
+		}
+		result = append(result, info)
+	}
+
+	if name == "" {
+		kh.certificates.prune(namespace, exists)
+	}
+
+	// invalid certificates first, then by expiry
+	sort.Slice(result, func(i, j int) bool {
+		if (result[i].Status == CertInvalid) != (result[j].Status == CertInvalid) {
+			return result[i].Status == CertInvalid
+		}
+		return result[i].DaysLeft < result[j].DaysLeft
+	})
+	return result, nil
+}
+
+// Certificate of the Secret. The API returns the whole Secret, its private key is wiped right away
+func (kh K8sHandler) certificateData(ctx context.Context, namespace string, name string) ([]byte, error) {
+	secret, err := kh.K8sClient.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	privateKey := secret.Data[corev1.TLSPrivateKeyKey]
+	for i := range privateKey {
+		privateKey[i] = 0
+	}
+	delete(secret.Data, corev1.TLSPrivateKeyKey)
+	return secret.Data[corev1.TLSCertKey], nil
+}
+
+// Leaf certificate of the PEM chain, which comes first
+func certificateInfo(data []byte, now time.Time) cm.CertificateInfo {
+	result := cm.CertificateInfo{Status: CertInvalid, SANs: []string{}}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		result.Message = "no PEM certificate in " + corev1.TLSCertKey
+		return result
+	}
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+
+	result.Subject = certificate.Subject.String()
+	result.Issuer = certificate.Issuer.String()
+	result.SANs = append(result.SANs, certificate.DNSNames...)
+	for _, ip := range certificate.IPAddresses {
+		result.SANs = append(result.SANs, ip.String())
+	}
+	result.NotBefore = certificate.NotBefore.Format("2006-01-02 15:04")
+	result.NotAfter = certificate.NotAfter.Format("2006-01-02 15:04")
+	result.DaysLeft = int(certificate.NotAfter.Sub(now).Hours() / 24)
+
+	switch {
+	case !now.Before(certificate.NotAfter):
+		result.Status = CertExpired
+		result.DaysLeft = 0
+	case result.DaysLeft < certWarningDays:
+		result.Status = CertExpiring
+	case now.Before(certificate.NotBefore):
+		// not usable yet, which breaks the clients just as much
+		result.Status = CertInvalid
+		result.Message = "not valid before " + result.NotBefore
+	default:
+		result.Status = CertValid
+	}
+	return result
+}
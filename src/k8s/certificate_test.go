@@ -0,0 +1,201 @@
+package k8s
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	metadatafake "k8s.io/client-go/metadata/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"math/big"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Self-signed certificate valid between notBefore and notAfter, PEM encoded
+func testCertificate(t *testing.T, notBefore time.Time, notAfter time.Time) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "web.example.com"},
+		DNSNames:     []string{"web.example.com", "www.example.com"},
+		IPAddresses:  []net.IP{net.ParseIP("10.0.0.1")},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestCertificateInfo(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	tests := []struct {
+		name     string
+		data     []byte
+		status   string
+		daysLeft int
+	}{
+		{"valid", testCertificate(t, now.Add(-day), now.Add(90*day)), CertValid, 90},
+		{"expiring", testCertificate(t, now.Add(-day), now.Add(10*day)), CertExpiring, 10},
+		{"expiring at the warning days", testCertificate(t, now.Add(-day), now.Add(time.Duration(certWarningDays)*day-time.Hour)), CertExpiring, certWarningDays - 1},
+		{"expired", testCertificate(t, now.Add(-90*day), now.Add(-day)), CertExpired, 0},
+		{"expires now", testCertificate(t, now.Add(-90*day), now), CertExpired, 0},
+		{"not valid yet", testCertificate(t, now.Add(day), now.Add(90*day)), CertInvalid, 90},
+		{"not PEM", []byte("not a certificate"), CertInvalid, 0},
+		{"key instead of certificate", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte{1}}), CertInvalid, 0},
+		{"corrupt certificate", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte{1, 2, 3}}), CertInvalid, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info := certificateInfo(test.data, now)
+			if info.Status != test.status {
+				t.Errorf("expected status %v, got %v (%v)", test.status, info.Status, info.Message)
+			}
+			if info.DaysLeft != test.daysLeft {
+				t.Errorf("expected %d days left, got %d", test.daysLeft, info.DaysLeft)
+			}
+			if info.Status == CertInvalid && info.Message == "" {
+				t.Error("expected a message for an invalid certificate")
+			}
+		})
+	}
+}
+
+func TestCertificateInfoFields(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	info := certificateInfo(testCertificate(t, now.Add(-time.Hour), now.Add(48*time.Hour)), now)
+
+	if expected := []string{"web.example.com", "www.example.com", "10.0.0.1"}; !reflect.DeepEqual(info.SANs, expected) {
+		t.Errorf("expected SANs %v, got %v", expected, info.SANs)
+	}
+	if info.Subject != "CN=web.example.com" || info.Issuer != "CN=web.example.com" {
+		t.Errorf("unexpected subject %v or issuer %v", info.Subject, info.Issuer)
+	}
+	if info.NotBefore != "2024-06-01 11:00" || info.NotAfter != "2024-06-03 12:00" {
+		t.Errorf("unexpected validity %v to %v", info.NotBefore, info.NotAfter)
+	}
+}
+
+func TestParseCertWarningDays(t *testing.T) {
+	tests := map[string]int{"14": 14, "0": 0, "soon": 30, "": 30}
+	for value, expected := range tests {
+		if days := parseCertWarningDays(value); days != expected {
+			t.Errorf("%q: expected %d days, got %d", value, expected, days)
+		}
+	}
+}
+
+func TestCertificateInfosCache(t *testing.T) {
+	now := time.Now()
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "tls", ResourceVersion: "1"},
+		Type:       corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       testCertificate(t, now.Add(-time.Hour), now.Add(90*24*time.Hour)),
+			corev1.TLSPrivateKeyKey: []byte("private key"),
+		},
+	}
+	client := fake.NewSimpleClientset(secret)
+
+	// only the metadata of the Secrets is listed
+	listed := []metav1.ObjectMeta{secret.ObjectMeta}
+	metadataClient := metadatafake.NewSimpleMetadataClient(metadatafake.NewTestScheme())
+	metadataClient.PrependReactor("list", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		list := &metav1.List{}
+		for _, meta := range listed {
+			list.Items = append(list.Items, runtime.RawExtension{Object: &metav1.PartialObjectMetadata{ObjectMeta: meta}})
+		}
+		return true, list, nil
+	})
+
+	kh := K8sHandler{
+		K8sClient:      client,
+		metadataClient: metadataClient,
+		informers:      informers.NewSharedInformerFactory(client, 0),
+		certificates:   NewCertificateCache(),
+	}
+	gets := func() int {
+		count := 0
+		for _, action := range client.Actions() {
+			if action.GetVerb() == "get" {
+				count++
+			}
+		}
+		return count
+	}
+
+	for i := 0; i < 2; i++ {
+		certificates, err := kh.certificateInfos("", "", now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(certificates) != 1 || certificates[0].Status != CertValid || certificates[0].Secret != "tls" {
+			t.Fatalf("expected the valid certificate of the Secret, got %v", certificates)
+		}
+	}
+	if count := gets(); count != 1 {
+		t.Errorf("expected the Secret to be fetched once, got %d", count)
+	}
+	for key, entry := range kh.certificates.entries {
+		if string(entry.data) != string(secret.Data[corev1.TLSCertKey]) {
+			t.Errorf("expected only the certificate to be cached for %v", key)
+		}
+	}
+
+	// fetched again once it changed
+	listed[0].ResourceVersion = "2"
+	if _, err := kh.certificateInfos("", "", now); err != nil {
+		t.Fatal(err)
+	}
+	if count := gets(); count != 2 {
+		t.Errorf("expected the changed Secret to be fetched again, got %d gets", count)
+	}
+
+	// and forgotten once deleted
+	listed = nil
+	if _, err := kh.certificateInfos("", "", now); err != nil {
+		t.Fatal(err)
+	}
+	if len(kh.certificates.entries) != 0 {
+		t.Errorf("expected the deleted Secret to be forgotten, got %v", kh.certificates.entries)
+	}
+}
+
+func TestCertificateDataWipesKey(t *testing.T) {
+	key := []byte("private key")
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("get", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, &corev1.Secret{Data: map[string][]byte{corev1.TLSCertKey: []byte("cert"), corev1.TLSPrivateKeyKey: key}}, nil
+	})
+
+	data, err := K8sHandler{K8sClient: client}.certificateData(context.TODO(), "web", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "cert" {
+		t.Errorf("expected the certificate, got %q", data)
+	}
+	for _, b := range key {
+		if b != 0 {
+			t.Fatalf("expected the private key to be wiped, got %q", key)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	cm "github.com/royroyee/kubem/common"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"log"
+	"math"
+	"os"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Prices per node type, e.g.
+//
+//	default:
+//	  cpu_hour: 0.0316
+//	  gib_hour: 0.0042
+//	node_types:
+//	  m5.xlarge:
+//	    cpu_hour: 0.048
+//	    gib_hour: 0.006
+var pricesPath = cm.GetEnv("KUBEM_PRICES", "prices.yaml")
+
+const (
+	nodeTypeLabel      = "node.kubernetes.io/instance-type"
+	costInterval       = 10 * time.Minute
+	costRetention      = 400 * 24 * time.Hour
+	idleCostLine       = "idle"
+	unlabeledCostLine  = "unlabeled"
+	costGroupNamespace = "namespace"
+	costGroupLabel     = "label"
+)
+
+type pricing struct {
+	Default   *cm.NodePrice           `json:"default"`
+	NodeTypes map[string]cm.NodePrice `json:"node_types"`
+}
+
+// Prices are not guessed, a report without them would present made up numbers as costs
+func loadPricing() (pricing, error) {
+	var result pricing
+
+	data, err := os.ReadFile(pricesPath)
+	if err != nil {
+		return result, fmt.Errorf("Failed to read prices, set KUBEM_PRICES to a price file: %v", err)
+	}
+	if err := yaml.Unmarshal(data, &result); err != nil {
+		return result, fmt.Errorf("Failed to parse prices %v: %v", pricesPath, err)
+	}
+	return result, nil
+}
+
+func (p pricing) priceOf(nodeType string) (cm.NodePrice, bool) {
+	if price, ok := p.NodeTypes[nodeType]; ok {
+		return price, true
+	}
+	if p.Default != nil {
+		return *p.Default, true
+	}
+	return cm.NodePrice{}, false
+}
+
+// Periodically store the footprint of every scheduled pod and the allocatable resources of every node
+func (kh K8sHandler) RecordPodUsage() {
+
+	ticker := time.NewTicker(costInterval)
+	defer ticker.Stop()
+
+	for ; true; <-ticker.C {
+		nodes, err := kh.K8sClient.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		pods, err := kh.K8sClient.CoreV1().Pods(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		usages, err := kh.podUsages(metav1.NamespaceAll)
+		if err != nil {
+			// usage is optional, metrics-server may not be installed
+			log.Println(err)
+		}
+
+		now := time.Now()
+		hours := costInterval.Hours()
+
+		var nodeSnapshots []cm.NodeCapacitySnapshot
+		nodeTypes := make(map[string]string)
+		for _, node := range nodes.Items {
+			nodeTypes[node.Name] = node.Labels[nodeTypeLabel]
+			nodeSnapshots = append(nodeSnapshots, cm.NodeCapacitySnapshot{
+				Name:           node.Name,
+				NodeType:       node.Labels[nodeTypeLabel],
+				CpuAllocatable: node.Status.Allocatable.Cpu().MilliValue(),
+				RamAllocatable: node.Status.Allocatable.Memory().Value() / 1024 / 1024,
+				Hours:          hours,
+				Timestamp:      now,
+			})
+		}
+
+		var podSnapshots []cm.PodUsageSnapshot
+		for _, pod := range pods.Items {
+			if pod.Spec.NodeName == "" || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+				continue
+			}
+			footprint := podResource(&pod, usages[pod.Namespace+"/"+pod.Name])
+
+			var labels []string
+			for key, value := range pod.Labels {
+				labels = append(labels, fmt.Sprintf("%s=%s", key, value))
+			}
+
+			podSnapshots = append(podSnapshots, cm.PodUsageSnapshot{
+				Name:        pod.Name,
+				Namespace:   pod.Namespace,
+				Node:        pod.Spec.NodeName,
+				NodeType:    nodeTypes[pod.Spec.NodeName],
+				Labels:      labels,
+				CpuRequests: footprint.CpuRequests,
+				RamRequests: footprint.RamRequests,
+				CpuUsage:    footprint.CpuUsage,
+				RamUsage:    footprint.RamUsage,
+				Hours:       hours,
+				Timestamp:   now,
+			})
+		}
+
+		kh.StoreCostSnapshotsInDB(podSnapshots, nodeSnapshots)
+		kh.deleteCostSnapshotsFromDB(costRetention)
+	}
+}
+
+// Cost allocation from the first to the last day included, split by namespace or by the value of a pod label.
+// A pod is charged for the larger of its requests and its usage, what is left of the node cost is reported as idle
+func (kh K8sHandler) GetCostReport(from time.Time, to time.Time, groupBy string, labelKey string) (cm.CostReport, error) {
+	result := cm.CostReport{
+		From:    from.Format("2006-01-02"),
+		To:      to.Format("2006-01-02"),
+		GroupBy: groupBy,
+	}
+	if groupBy == costGroupLabel {
+		if labelKey == "" {
+			return result, fmt.Errorf("Label key is required to group by label")
+		}
+		result.GroupBy = costGroupLabel + ":" + labelKey
+	} else if groupBy != costGroupNamespace {
+		return result, fmt.Errorf("Invalid Group %v", groupBy)
+	}
+
+	prices, err := loadPricing()
+	if err != nil {
+		return result, err
+	}
+	lines := make(map[string]*cm.CostLine)
+	unpriced := make(map[string]bool)
+	end := to.AddDate(0, 0, 1)
+
+	err = kh.iterPodUsageSnapshots(from, end, func(snapshot cm.PodUsageSnapshot) {
+		name := snapshot.Namespace
+		if groupBy == costGroupLabel {
+			name = labelValue(snapshot.Labels, labelKey)
+		}
+		line, ok := lines[name]
+		if !ok {
+			line = &cm.CostLine{Name: name}
+			lines[name] = line
+		}
+
+		price, ok := prices.priceOf(snapshot.NodeType)
+		if !ok {
+			unpriced[snapshot.NodeType] = true
+		}
+		cpuHours := float64(maxInt64(snapshot.CpuRequests, snapshot.CpuUsage)) / 1000 * snapshot.Hours
+		gibHours := float64(maxInt64(snapshot.RamRequests, snapshot.RamUsage)) / 1024 * snapshot.Hours
+
+		line.CpuHours += cpuHours
+		line.GiBHours += gibHours
+		line.CpuCost += cpuHours * price.CpuHour
+		line.RamCost += gibHours * price.GiBHour
+	})
+	if err != nil {
+		return result, err
+	}
+
+	idle := &cm.CostLine{Name: idleCostLine}
+	err = kh.iterNodeCapacitySnapshots(from, end, func(snapshot cm.NodeCapacitySnapshot) {
+		price, ok := prices.priceOf(snapshot.NodeType)
+		if !ok {
+			unpriced[snapshot.NodeType] = true
+		}
+		cpuHours := float64(snapshot.CpuAllocatable) / 1000 * snapshot.Hours
+		gibHours := float64(snapshot.RamAllocatable) / 1024 * snapshot.Hours
+
+		idle.CpuHours += cpuHours
+		idle.GiBHours += gibHours
+		idle.CpuCost += cpuHours * price.CpuHour
+		idle.RamCost += gibHours * price.GiBHour
+	})
+	if err != nil {
+		return result, err
+	}
+	if len(unpriced) > 0 {
+		var nodeTypes []string
+		for nodeType := range unpriced {
+			nodeTypes = append(nodeTypes, fmt.Sprintf("%q", nodeType))
+		}
+		sort.Strings(nodeTypes)
+		return result, fmt.Errorf("No price for node types %v in %v and no default price", strings.Join(nodeTypes, ", "), pricesPath)
+	}
+
+	for _, line := range lines {
+		line.Cost = line.CpuCost + line.RamCost
+		result.Lines = append(result.Lines, *line)
+		result.Total += line.Cost
+
+		idle.CpuHours -= line.CpuHours
+		idle.GiBHours -= line.GiBHours
+		idle.CpuCost -= line.CpuCost
+		idle.RamCost -= line.RamCost
+	}
+	sort.Slice(result.Lines, func(i, j int) bool { return result.Lines[i].Cost > result.Lines[j].Cost })
+
+	// pods may use more than the allocatable resources, in which case nothing is idle
+	idle.CpuHours = math.Max(idle.CpuHours, 0)
+	idle.GiBHours = math.Max(idle.GiBHours, 0)
+	idle.CpuCost = math.Max(idle.CpuCost, 0)
+	idle.RamCost = math.Max(idle.RamCost, 0)
+	idle.Cost = idle.CpuCost + idle.RamCost
+	result.Lines = append(result.Lines, *idle)
+	result.Total += idle.Cost
+
+	return result, nil
+}
+
+// Value of the label in a list of key=value labels
+func labelValue(labels []string, key string) string {
+	for _, label := range labels {
+		if strings.HasPrefix(label, key+"=") {
+			return strings.TrimPrefix(label, key+"=")
+		}
+	}
+	return unlabeledCostLine
+}
+
+func maxInt64(a int64, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
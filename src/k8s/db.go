@@ -300,3 +300,713 @@ func (kh K8sHandler) GetPodUsageDetail(podName string) (cm.GetPodUsage, error) {
 
 	return result, nil
 }
+
+// Store a node condition transition, the same transition is only stored once
+func (kh K8sHandler) StoreNodeConditionInDB(transition cm.NodeConditionTransition) {
+
+	// Use its own session to avoid any concurrent use issues
+	cloneSession := kh.session.Clone()
+	defer cloneSession.Close()
+
+	collection := cloneSession.DB("kubem").C("nodecondition")
+
+	selector := bson.M{"name": transition.Name, "type": transition.Type, "timestamp": transition.Timestamp}
+	_, err := collection.Upsert(selector, transition)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+}
+
+func (kh K8sHandler) GetNodeConditionHistory(nodeName string, conditionType string, page int, perPage int) ([]cm.NodeConditionTransition, error) {
+	var result []cm.NodeConditionTransition
+	collection := kh.session.DB("kubem").C("nodecondition")
+
+	skip := (page - 1) * perPage
+	limit := perPage
+	filter := bson.M{"name": nodeName}
+	if conditionType != "" {
+		filter["type"] = conditionType
+	}
+	err := collection.Find(filter).Skip(skip).Limit(limit).Sort("-timestamp").All(&result)
+	if err != nil {
+		log.Println(err)
+		return result, err
+	}
+	return result, nil
+}
+
+// Number of transitions per condition type of the node within the last hours
+func (kh K8sHandler) GetNodeFlaps(nodeName string, hours int) ([]cm.NodeFlap, error) {
+	var result []cm.NodeFlap
+	collection := kh.session.DB("kubem").C("nodecondition")
+
+	cutoff := time.Now().Add(-time.Duration(hours) * time.Hour)
+	pipeline := collection.Pipe([]bson.M{
+		{"$match": bson.M{"name": nodeName, "timestamp": bson.M{"$gte": cutoff}}},
+		{"$group": bson.M{
+			"_id":            "$type",
+			"transitions":    bson.M{"$sum": 1},
+			"lasttransition": bson.M{"$max": "$timestamp"},
+		}},
+		{"$sort": bson.M{"transitions": -1}},
+	})
+
+	err := pipeline.All(&result)
+	if err != nil {
+		log.Println(err)
+		return result, err
+	}
+	return result, nil
+}
+
+func (kh K8sHandler) StoreCapacityInDB(snapshots []cm.CapacitySnapshot) {
+
+	// Use its own session to avoid any concurrent use issues
+	cloneSession := kh.session.Clone()
+	defer cloneSession.Close()
+
+	collection := cloneSession.DB("kubem").C("capacity")
+
+	for _, snapshot := range snapshots {
+		err := collection.Insert(snapshot)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+	}
+}
+
+// Delete capacity history older than the retention period
+func (kh K8sHandler) deleteCapacityFromDB(retention time.Duration) {
+
+	cloneSession := kh.session.Clone()
+	defer cloneSession.Close()
+
+	collection := cloneSession.DB("kubem").C("capacity")
+
+	cutoff := time.Now().Add(-retention)
+	_, err := collection.RemoveAll(bson.M{"timestamp": bson.M{"$lte": cutoff}})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+}
+
+func (kh K8sHandler) GetCapacityHistory(since time.Time) ([]cm.CapacitySnapshot, error) {
+	var result []cm.CapacitySnapshot
+	collection := kh.session.DB("kubem").C("capacity")
+
+	err := collection.Find(bson.M{"timestamp": bson.M{"$gte": since}}).Sort("timestamp").All(&result)
+	if err != nil {
+		log.Println(err)
+		return result, err
+	}
+	return result, nil
+}
+
+func (kh K8sHandler) StoreNamespaceUsageInDB(snapshots []cm.NamespaceUsageSnapshot) {
+
+	// Use its own session to avoid any concurrent use issues
+	cloneSession := kh.session.Clone()
+	defer cloneSession.Close()
+
+	collection := cloneSession.DB("kubem").C("namespaceusage")
+
+	for _, snapshot := range snapshots {
+		err := collection.Insert(snapshot)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+	}
+}
+
+// Delete namespace usage history older than the retention period
+func (kh K8sHandler) deleteNamespaceUsageFromDB(retention time.Duration) {
+
+	cloneSession := kh.session.Clone()
+	defer cloneSession.Close()
+
+	collection := cloneSession.DB("kubem").C("namespaceusage")
+
+	cutoff := time.Now().Add(-retention)
+	_, err := collection.RemoveAll(bson.M{"timestamp": bson.M{"$lte": cutoff}})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+}
+
+func (kh K8sHandler) GetNamespaceUsageHistory(namespace string, since time.Time) ([]cm.NamespaceUsageSnapshot, error) {
+	var result []cm.NamespaceUsageSnapshot
+	collection := kh.session.DB("kubem").C("namespaceusage")
+
+	filter := bson.M{"namespace": namespace, "timestamp": bson.M{"$gte": since}}
+	err := collection.Find(filter).Sort("timestamp").All(&result)
+	if err != nil {
+		log.Println(err)
+		return result, err
+	}
+	return result, nil
+}
+
+func (kh K8sHandler) StoreCostSnapshotsInDB(pods []cm.PodUsageSnapshot, nodes []cm.NodeCapacitySnapshot) {
+
+	// Use its own session to avoid any concurrent use issues
+	cloneSession := kh.session.Clone()
+	defer cloneSession.Close()
+
+	podCollection := cloneSession.DB("kubem").C("podsnapshot")
+	for _, pod := range pods {
+		err := podCollection.Insert(pod)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+	}
+
+	nodeCollection := cloneSession.DB("kubem").C("nodesnapshot")
+	for _, node := range nodes {
+		err := nodeCollection.Insert(node)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+	}
+}
+
+// Delete pod and node snapshots older than the retention period
+func (kh K8sHandler) deleteCostSnapshotsFromDB(retention time.Duration) {
+
+	cloneSession := kh.session.Clone()
+	defer cloneSession.Close()
+
+	cutoff := time.Now().Add(-retention)
+	for _, name := range []string{"podsnapshot", "nodesnapshot"} {
+		_, err := cloneSession.DB("kubem").C(name).RemoveAll(bson.M{"timestamp": bson.M{"$lte": cutoff}})
+		if err != nil {
+			log.Println(err)
+			return
+		}
+	}
+}
+
+// Call fn for every pod snapshot between from and to, without loading them all in memory
+func (kh K8sHandler) iterPodUsageSnapshots(from time.Time, to time.Time, fn func(cm.PodUsageSnapshot)) error {
+
+	cloneSession := kh.session.Clone()
+	defer cloneSession.Close()
+
+	collection := cloneSession.DB("kubem").C("podsnapshot")
+
+	iter := collection.Find(bson.M{"timestamp": bson.M{"$gte": from, "$lt": to}}).Iter()
+	var snapshot cm.PodUsageSnapshot
+	for iter.Next(&snapshot) {
+		fn(snapshot)
+		snapshot = cm.PodUsageSnapshot{}
+	}
+	if err := iter.Close(); err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+func (kh K8sHandler) iterNodeCapacitySnapshots(from time.Time, to time.Time, fn func(cm.NodeCapacitySnapshot)) error {
+
+	cloneSession := kh.session.Clone()
+	defer cloneSession.Close()
+
+	collection := cloneSession.DB("kubem").C("nodesnapshot")
+
+	iter := collection.Find(bson.M{"timestamp": bson.M{"$gte": from, "$lt": to}}).Iter()
+	var snapshot cm.NodeCapacitySnapshot
+	for iter.Next(&snapshot) {
+		fn(snapshot)
+		snapshot = cm.NodeCapacitySnapshot{}
+	}
+	if err := iter.Close(); err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+// Latest stored usage of every node sampled since then
+func (kh K8sHandler) latestNodeUsage(since time.Time) ([]cm.NodeOverview, error) {
+	var result []cm.NodeOverview
+
+	cloneSession := kh.session.Clone()
+	defer cloneSession.Close()
+
+	collection := cloneSession.DB("kubem").C("node")
+
+	pipeline := []bson.M{
+		{"$addFields": bson.M{"sampled": bson.M{"$toDate": "$timestamp"}}},
+		{"$match": bson.M{"sampled": bson.M{"$gte": since}}},
+		{"$sort": bson.M{"sampled": -1}},
+		{"$group": bson.M{
+			"_id":      "$name",
+			"name":     bson.M{"$first": "$name"},
+			"cpuusage": bson.M{"$first": "$cpuusage"},
+			"ramusage": bson.M{"$first": "$ramusage"},
+			"ip":       bson.M{"$first": "$ip"},
+			"status":   bson.M{"$first": "$status"},
+		}},
+	}
+
+	err := collection.Pipe(pipeline).All(&result)
+	if err != nil {
+		log.Println(err)
+		return result, err
+	}
+	return result, nil
+}
+
+func (kh K8sHandler) StoreAlertTransitionInDB(transition cm.AlertTransition) {
+
+	// Use its own session to avoid any concurrent use issues
+	cloneSession := kh.session.Clone()
+	defer cloneSession.Close()
+
+	collection := cloneSession.DB("kubem").C("alert")
+
+	err := collection.Insert(transition)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+}
+
+func (kh K8sHandler) GetAlertHistory(rule string, state string, page int, perPage int) ([]cm.AlertTransition, error) {
+	var result []cm.AlertTransition
+	collection := kh.session.DB("kubem").C("alert")
+
+	skip := (page - 1) * perPage
+	limit := perPage
+	filter := bson.M{}
+	if rule != "" {
+		filter["rule"] = rule
+	}
+	if state != "" {
+		filter["state"] = state
+	}
+	err := collection.Find(filter).Skip(skip).Limit(limit).Sort("-timestamp").All(&result)
+	if err != nil {
+		log.Println(err)
+		return result, err
+	}
+	return result, nil
+}
+
+func (kh K8sHandler) StoreNotificationInDB(notification cm.Notification) {
+
+	// Use its own session to avoid any concurrent use issues
+	cloneSession := kh.session.Clone()
+	defer cloneSession.Close()
+
+	collection := cloneSession.DB("kubem").C("notification")
+
+	err := collection.Insert(notification)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+}
+
+func (kh K8sHandler) UpdateNotificationInDB(notification cm.Notification) {
+
+	cloneSession := kh.session.Clone()
+	defer cloneSession.Close()
+
+	collection := cloneSession.DB("kubem").C("notification")
+
+	err := collection.Update(bson.M{"id": notification.Id}, notification)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+}
+
+func (kh K8sHandler) GetNotifications(receiver string, status string, page int, perPage int) ([]cm.Notification, error) {
+	var result []cm.Notification
+	collection := kh.session.DB("kubem").C("notification")
+
+	skip := (page - 1) * perPage
+	limit := perPage
+	filter := bson.M{}
+	if receiver != "" {
+		filter["receiver"] = receiver
+	}
+	if status != "" {
+		filter["status"] = status
+	}
+	err := collection.Find(filter).Skip(skip).Limit(limit).Sort("-created").All(&result)
+	if err != nil {
+		log.Println(err)
+		return result, err
+	}
+	return result, nil
+}
+
+func (kh K8sHandler) GetNotification(id string) (cm.Notification, error) {
+	var result cm.Notification
+	collection := kh.session.DB("kubem").C("notification")
+
+	err := collection.Find(bson.M{"id": id}).One(&result)
+	if err != nil {
+		log.Println(err)
+		return result, err
+	}
+	return result, nil
+}
+
+func (kh K8sHandler) StoreSilenceInDB(silence cm.Silence) error {
+
+	// Use its own session to avoid any concurrent use issues
+	cloneSession := kh.session.Clone()
+	defer cloneSession.Close()
+
+	collection := cloneSession.DB("kubem").C("silence")
+
+	err := collection.Insert(silence)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+func (kh K8sHandler) UpdateSilenceInDB(silence cm.Silence) error {
+
+	cloneSession := kh.session.Clone()
+	defer cloneSession.Close()
+
+	collection := cloneSession.DB("kubem").C("silence")
+
+	err := collection.Update(bson.M{"id": silence.Id}, silence)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+func (kh K8sHandler) GetSilence(id string) (cm.Silence, error) {
+	var result cm.Silence
+	collection := kh.session.DB("kubem").C("silence")
+
+	err := collection.Find(bson.M{"id": id}).One(&result)
+	if err != nil {
+		log.Println(err)
+		return result, err
+	}
+	return result, nil
+}
+
+// Silences which started and did not end yet, maintenance windows without end included
+func (kh K8sHandler) GetActiveSilences(now time.Time) ([]cm.Silence, error) {
+	var result []cm.Silence
+
+	cloneSession := kh.session.Clone()
+	defer cloneSession.Close()
+
+	collection := cloneSession.DB("kubem").C("silence")
+
+	filter := bson.M{
+		"startsat": bson.M{"$lte": now},
+		"$or": []bson.M{
+			{"endsat": bson.M{"$gt": now}},
+			{"endsat": time.Time{}},
+		},
+	}
+	err := collection.Find(filter).All(&result)
+	if err != nil {
+		log.Println(err)
+		return result, err
+	}
+	return result, nil
+}
+
+func (kh K8sHandler) GetSilences(active bool, page int, perPage int) ([]cm.Silence, error) {
+	var result []cm.Silence
+
+	if active {
+		silences, err := kh.GetActiveSilences(time.Now())
+		if err != nil {
+			return result, err
+		}
+		skip := (page - 1) * perPage
+		if skip < 0 || skip >= len(silences) {
+			return []cm.Silence{}, nil
+		}
+		end := skip + perPage
+		if end > len(silences) {
+			end = len(silences)
+		}
+		return silences[skip:end], nil
+	}
+
+	collection := kh.session.DB("kubem").C("silence")
+
+	skip := (page - 1) * perPage
+	limit := perPage
+	err := collection.Find(bson.M{}).Skip(skip).Limit(limit).Sort("-created").All(&result)
+	if err != nil {
+		log.Println(err)
+		return result, err
+	}
+	return result, nil
+}
+
+// Upsert the rollout of a Deployment revision
+func (kh K8sHandler) StoreRolloutInDB(rollout cm.Rollout) {
+
+	// Use its own session to avoid any concurrent use issues
+	cloneSession := kh.session.Clone()
+	defer cloneSession.Close()
+
+	collection := cloneSession.DB("kubem").C("rollout")
+
+	filter := bson.M{"namespace": rollout.Namespace, "name": rollout.Name, "revision": rollout.Revision}
+	_, err := collection.Upsert(filter, rollout)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+}
+
+func (kh K8sHandler) GetRollout(namespace string, name string, revision string) (cm.Rollout, error) {
+	var result cm.Rollout
+
+	cloneSession := kh.session.Clone()
+	defer cloneSession.Close()
+
+	collection := cloneSession.DB("kubem").C("rollout")
+
+	filter := bson.M{"namespace": namespace, "name": name, "revision": revision}
+	err := collection.Find(filter).One(&result)
+	if err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// Most recently started rollout of a Deployment
+func (kh K8sHandler) GetLatestRollout(namespace string, name string) (cm.Rollout, error) {
+	var result cm.Rollout
+
+	cloneSession := kh.session.Clone()
+	defer cloneSession.Close()
+
+	collection := cloneSession.DB("kubem").C("rollout")
+
+	filter := bson.M{"namespace": namespace, "name": name}
+	err := collection.Find(filter).Sort("-starttime").One(&result)
+	if err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+func (kh K8sHandler) GetRollouts(namespace string, name string, page int, perPage int) ([]cm.Rollout, error) {
+	var result []cm.Rollout
+	collection := kh.session.DB("kubem").C("rollout")
+
+	skip := (page - 1) * perPage
+	limit := perPage
+	filter := bson.M{"namespace": namespace, "name": name}
+	err := collection.Find(filter).Skip(skip).Limit(limit).Sort("-starttime").All(&result)
+	if err != nil {
+		log.Println(err)
+		return result, err
+	}
+	return result, nil
+}
+
+func (kh K8sHandler) StoreChangeInDB(change cm.Change) {
+
+	// Use its own session to avoid any concurrent use issues
+	cloneSession := kh.session.Clone()
+	defer cloneSession.Close()
+
+	collection := cloneSession.DB("kubem").C("change")
+
+	err := collection.Insert(change)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+}
+
+func (kh K8sHandler) GetChanges(namespace string, from time.Time, to time.Time) ([]cm.Change, error) {
+	var result []cm.Change
+	collection := kh.session.DB("kubem").C("change")
+
+	filter := bson.M{"timestamp": bson.M{"$gte": from, "$lte": to}}
+	if namespace != "" {
+		filter["namespace"] = namespace
+	}
+	err := collection.Find(filter).Sort("-timestamp").All(&result)
+	if err != nil {
+		log.Println(err)
+		return result, err
+	}
+	return result, nil
+}
+
+// Store a Warning event, a repeated event only keeps its last occurrence
+func (kh K8sHandler) StoreWarningEventInDB(event cm.WarningEvent) {
+
+	// Use its own session to avoid any concurrent use issues
+	cloneSession := kh.session.Clone()
+	defer cloneSession.Close()
+
+	collection := cloneSession.DB("kubem").C("warningevent")
+
+	_, err := collection.Upsert(bson.M{"uid": event.Uid}, event)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+}
+
+func (kh K8sHandler) GetWarningEvents(namespace string, from time.Time, to time.Time) ([]cm.WarningEvent, error) {
+	var result []cm.WarningEvent
+	collection := kh.session.DB("kubem").C("warningevent")
+
+	filter := bson.M{"timestamp": bson.M{"$gte": from, "$lte": to}}
+	if namespace != "" {
+		filter["namespace"] = namespace
+	}
+	err := collection.Find(filter).Sort("-timestamp").All(&result)
+	if err != nil {
+		log.Println(err)
+		return result, err
+	}
+	return result, nil
+}
+
+func (kh K8sHandler) GetAlertTransitions(namespace string, from time.Time, to time.Time) ([]cm.AlertTransition, error) {
+	var result []cm.AlertTransition
+	collection := kh.session.DB("kubem").C("alert")
+
+	filter := bson.M{"timestamp": bson.M{"$gte": from, "$lte": to}}
+	if namespace != "" {
+		filter["namespace"] = namespace
+	}
+	err := collection.Find(filter).Sort("-timestamp").All(&result)
+	if err != nil {
+		log.Println(err)
+		return result, err
+	}
+	return result, nil
+}
+
+func (kh K8sHandler) StoreScaleEventInDB(event cm.ScaleEvent) {
+
+	// Use its own session to avoid any concurrent use issues
+	cloneSession := kh.session.Clone()
+	defer cloneSession.Close()
+
+	collection := cloneSession.DB("kubem").C("scaleevent")
+
+	err := collection.Insert(event)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+}
+
+func (kh K8sHandler) GetScaleEvents(namespace string, name string, since time.Time) ([]cm.ScaleEvent, error) {
+	var result []cm.ScaleEvent
+	collection := kh.session.DB("kubem").C("scaleevent")
+
+	filter := bson.M{"namespace": namespace, "name": name, "timestamp": bson.M{"$gte": since}}
+	err := collection.Find(filter).Sort("timestamp").All(&result)
+	if err != nil {
+		log.Println(err)
+		return result, err
+	}
+	return result, nil
+}
+
+// Upsert the run of a Job, identified by the uid of the Job
+func (kh K8sHandler) StoreJobRunInDB(run cm.JobRun) {
+
+	// Use its own session to avoid any concurrent use issues
+	cloneSession := kh.session.Clone()
+	defer cloneSession.Close()
+
+	collection := cloneSession.DB("kubem").C("jobrun")
+
+	_, err := collection.Upsert(bson.M{"uid": run.Uid}, run)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+}
+
+func (kh K8sHandler) GetJobRuns(namespace string, cronJob string, status string, page int, perPage int) ([]cm.JobRun, error) {
+	var result []cm.JobRun
+	collection := kh.session.DB("kubem").C("jobrun")
+
+	skip := (page - 1) * perPage
+	limit := perPage
+	filter := bson.M{}
+	if namespace != "" {
+		filter["namespace"] = namespace
+	}
+	if cronJob != "" {
+		filter["cronjob"] = cronJob
+	}
+	if status != "" {
+		filter["status"] = status
+	}
+	err := collection.Find(filter).Skip(skip).Limit(limit).Sort("-starttime").All(&result)
+	if err != nil {
+		log.Println(err)
+		return result, err
+	}
+	return result, nil
+}
+
+// Audit records are only ever inserted
+func (kh K8sHandler) StoreAuditRecordInDB(record cm.AuditRecord) error {
+
+	// Use its own session to avoid any concurrent use issues
+	cloneSession := kh.session.Clone()
+	defer cloneSession.Close()
+
+	collection := cloneSession.DB("kubem").C("audit")
+
+	err := collection.Insert(record)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+// Audit records of the namespaces, of every namespace and the cluster scoped ones if empty
+func (kh K8sHandler) GetAuditRecords(user string, namespaces []string, page int, perPage int) ([]cm.AuditRecord, error) {
+	var result []cm.AuditRecord
+	collection := kh.session.DB("kubem").C("audit")
+
+	skip := (page - 1) * perPage
+	limit := perPage
+	filter := bson.M{}
+	if user != "" {
+		filter["user"] = user
+	}
+	if len(namespaces) > 0 {
+		filter["namespace"] = bson.M{"$in": namespaces}
+	}
+	err := collection.Find(filter).Skip(skip).Limit(limit).Sort("-timestamp").All(&result)
+	if err != nil {
+		log.Println(err)
+		return result, err
+	}
+	return result, nil
+}
@@ -0,0 +1,208 @@
+package k8s
+
+import (
+	"encoding/json"
+	"fmt"
+	cm "github.com/royroyee/kubem/common"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// API version of a kind with the minor versions of Kubernetes 1.x deprecating and removing it
+type deprecatedAPI struct {
+	apiVersion  string
+	kind        string
+	deprecated  int
+	removed     int
+	replacement string
+}
+
+var deprecatedAPIs = []deprecatedAPI{
+	{"extensions/v1beta1", "Deployment", 9, 16, "apps/v1"},
+	{"extensions/v1beta1", "DaemonSet", 9, 16, "apps/v1"},
+	{"extensions/v1beta1", "ReplicaSet", 9, 16, "apps/v1"},
+	{"apps/v1beta1", "Deployment", 9, 16, "apps/v1"},
+	{"apps/v1beta1", "StatefulSet", 9, 16, "apps/v1"},
+	{"apps/v1beta2", "Deployment", 9, 16, "apps/v1"},
+	{"apps/v1beta2", "StatefulSet", 9, 16, "apps/v1"},
+	{"apps/v1beta2", "DaemonSet", 9, 16, "apps/v1"},
+	{"apps/v1beta2", "ReplicaSet", 9, 16, "apps/v1"},
+	{"extensions/v1beta1", "Ingress", 14, 22, "networking.k8s.io/v1"},
+	{"networking.k8s.io/v1beta1", "Ingress", 19, 22, "networking.k8s.io/v1"},
+	{"batch/v1beta1", "CronJob", 21, 25, "batch/v1"},
+	{"policy/v1beta1", "PodDisruptionBudget", 21, 25, "policy/v1"},
+	{"autoscaling/v2beta1", "HorizontalPodAutoscaler", 22, 25, "autoscaling/v2"},
+	{"autoscaling/v2beta2", "HorizontalPodAutoscaler", 23, 26, "autoscaling/v2"},
+}
+
+// An object and the kind of the lister it comes from, the objects of a lister have no type meta
+type listedObject struct {
+	kind string
+	meta metav1.ObjectMeta
+}
+
+// Objects of the namespace (all namespaces if empty) which were applied or are managed with an API version
+// deprecated or removed in the target version, e.g. 1.25. The target defaults to the next minor version of the cluster
+func (kh K8sHandler) GetDeprecations(namespace string, target string, page int, perPage int) (cm.DeprecationReport, error) {
+	result := cm.DeprecationReport{Objects: []cm.DeprecatedObject{}}
+
+	serverVersion, err := kh.K8sClient.Discovery().ServerVersion()
+	if err != nil {
+		log.Println(err)
+		return result, err
+	}
+	result.ServerVersion = serverVersion.GitVersion
+
+	targetMinor := 0
+	if target == "" {
+		current, err := minorVersion(serverVersion.Major + "." + serverVersion.Minor)
+		if err != nil {
+			return result, err
+		}
+		targetMinor = current + 1
+	} else {
+		targetMinor, err = minorVersion(target)
+		if err != nil {
+			return result, err
+		}
+	}
+	result.TargetVersion = fmt.Sprintf("1.%d", targetMinor)
+
+	objects, err := kh.listedObjects(namespace)
+	if err != nil {
+		return result, err
+	}
+
+	for _, object := range objects {
+		for apiVersion, sources := range appliedVersions(object.meta) {
+			api, ok := findDeprecatedAPI(apiVersion, object.kind)
+			if !ok || api.deprecated > targetMinor {
+				continue
+			}
+			result.Objects = append(result.Objects, cm.DeprecatedObject{
+				Namespace:    object.meta.Namespace,
+				Kind:         object.kind,
+				Name:         object.meta.Name,
+				ApiVersion:   apiVersion,
+				Sources:      sources,
+				DeprecatedIn: fmt.Sprintf("1.%d", api.deprecated),
+				RemovedIn:    fmt.Sprintf("1.%d", api.removed),
+				Replacement:  api.replacement,
+				Breaks:       api.removed <= targetMinor,
+			})
+		}
+	}
+
+	// the objects which break first
+	sort.Slice(result.Objects, func(i, j int) bool {
+		a, b := result.Objects[i], result.Objects[j]
+		if a.Breaks != b.Breaks {
+			return a.Breaks
+		}
+		return a.Namespace+"/"+a.Kind+"/"+a.Name < b.Namespace+"/"+b.Kind+"/"+b.Name
+	})
+	start, end := pageBounds(len(result.Objects), page, perPage)
+	result.Objects = result.Objects[start:end]
+
+	return result, nil
+}
+
+// Minor version of a 1.x version like 1.25, v1.25.3 or 1.25+
+func minorVersion(version string) (int, error) {
+	parts := strings.Split(strings.TrimPrefix(version, "v"), ".")
+	if len(parts) < 2 || parts[0] != "1" {
+		return 0, fmt.Errorf("Invalid version %v", version)
+	}
+	minor, err := strconv.Atoi(strings.TrimRight(parts[1], "+"))
+	if err != nil {
+		return 0, fmt.Errorf("Invalid version %v", version)
+	}
+	return minor, nil
+}
+
+func findDeprecatedAPI(apiVersion string, kind string) (deprecatedAPI, bool) {
+	for _, api := range deprecatedAPIs {
+		if api.apiVersion == apiVersion && api.kind == kind {
+			return api, true
+		}
+	}
+	return deprecatedAPI{}, false
+}
+
+// API versions the object was last applied with or is managed with, with where each was found
+func appliedVersions(meta metav1.ObjectMeta) map[string][]string {
+	result := make(map[string][]string)
+
+	if applied, ok := meta.Annotations[lastAppliedAnnotation]; ok {
+		var object struct {
+			ApiVersion string `json:"apiVersion"`
+		}
+		if err := json.Unmarshal([]byte(applied), &object); err != nil {
+			log.Printf("Invalid %s of %s/%s: %v", lastAppliedAnnotation, meta.Namespace, meta.Name, err)
+		} else if object.ApiVersion != "" {
+			result[object.ApiVersion] = append(result[object.ApiVersion], "last-applied-configuration")
+		}
+	}
+	for _, entry := range meta.ManagedFields {
+		if entry.APIVersion != "" && !contains(result[entry.APIVersion], entry.Manager) {
+			result[entry.APIVersion] = append(result[entry.APIVersion], entry.Manager)
+		}
+	}
+	return result
+}
+
+// Workloads and the objects of the kinds with deprecated API versions, from the informer caches
+func (kh K8sHandler) listedObjects(namespace string) ([]listedObject, error) {
+	var result []listedObject
+
+	workloads, err := kh.workloads(namespace)
+	if err != nil {
+		return result, err
+	}
+	for _, workload := range workloads {
+		result = append(result, listedObject{kind: workload.kind, meta: workload.meta})
+	}
+
+	replicaSets, err := kh.informers.Apps().V1().ReplicaSets().Lister().ReplicaSets(namespace).List(labels.Everything())
+	if err != nil {
+		return result, err
+	}
+	for _, replicaSet := range replicaSets {
+		// the replica sets of a deployment are created by the controller with the current version
+		if metav1.GetControllerOf(replicaSet) == nil {
+			result = append(result, listedObject{kind: "ReplicaSet", meta: replicaSet.ObjectMeta})
+		}
+	}
+
+	ingresses, err := kh.informers.Networking().V1().Ingresses().Lister().Ingresses(namespace).List(labels.Everything())
+	if err != nil {
+		return result, err
+	}
+	for _, ingress := range ingresses {
+		result = append(result, listedObject{kind: "Ingress", meta: ingress.ObjectMeta})
+	}
+
+	budgets, err := kh.informers.Policy().V1().PodDisruptionBudgets().Lister().PodDisruptionBudgets(namespace).List(labels.Everything())
+	if err != nil {
+		return result, err
+	}
+	for _, budget := range budgets {
+		result = append(result, listedObject{kind: "PodDisruptionBudget", meta: budget.ObjectMeta})
+	}
+
+	autoscalers, err := kh.informers.Autoscaling().V2().HorizontalPodAutoscalers().Lister().HorizontalPodAutoscalers(namespace).List(labels.Everything())
+	if err != nil {
+		return result, err
+	}
+	for _, autoscaler := range autoscalers {
+		result = append(result, listedObject{kind: "HorizontalPodAutoscaler", meta: autoscaler.ObjectMeta})
+	}
+
+	return result, nil
+}
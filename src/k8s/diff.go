@@ -0,0 +1,90 @@
+package k8s
+
+import (
+	"encoding/json"
+	"fmt"
+	cm "github.com/royroyee/kubem/common"
+	"sort"
+)
+
+// Field level differences between two objects, compared through their JSON representation
+func diffObjects(from interface{}, to interface{}) ([]cm.FieldChange, error) {
+	var fromValue, toValue interface{}
+
+	if err := jsonRoundTrip(from, &fromValue); err != nil {
+		return nil, err
+	}
+	if err := jsonRoundTrip(to, &toValue); err != nil {
+		return nil, err
+	}
+
+	var result []cm.FieldChange
+	diffValues("", fromValue, toValue, &result)
+	return result, nil
+}
+
+func jsonRoundTrip(object interface{}, value *interface{}) error {
+	data, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
+
+func diffValues(path string, from interface{}, to interface{}, result *[]cm.FieldChange) {
+	fromMap, fromIsMap := from.(map[string]interface{})
+	toMap, toIsMap := to.(map[string]interface{})
+	if fromIsMap && toIsMap {
+		keys := make(map[string]bool)
+		for key := range fromMap {
+			keys[key] = true
+		}
+		for key := range toMap {
+			keys[key] = true
+		}
+		var sorted []string
+		for key := range keys {
+			sorted = append(sorted, key)
+		}
+		sort.Strings(sorted)
+
+		for _, key := range sorted {
+			diffValues(joinPath(path, key), fromMap[key], toMap[key], result)
+		}
+		return
+	}
+
+	fromList, fromIsList := from.([]interface{})
+	toList, toIsList := to.([]interface{})
+	if fromIsList && toIsList && len(fromList) == len(toList) {
+		for i := range fromList {
+			diffValues(fmt.Sprintf("%s[%d]", path, i), fromList[i], toList[i], result)
+		}
+		return
+	}
+
+	fromJSON := encodeValue(from)
+	toJSON := encodeValue(to)
+	if fromJSON != toJSON {
+		*result = append(*result, cm.FieldChange{Path: path, From: fromJSON, To: toJSON})
+	}
+}
+
+func joinPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// JSON encoding of a value, empty if it is absent
+func encodeValue(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(data)
+}
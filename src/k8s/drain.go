@@ -0,0 +1,358 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	cm "github.com/royroyee/kubem/common"
+	"gopkg.in/mgo.v2/bson"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	DrainRunning   = "running"
+	DrainCompleted = "completed"
+	DrainFailed    = "failed"
+
+	ActionCordon   = "cordon"
+	ActionUncordon = "uncordon"
+	ActionDrain    = "drain"
+
+	DefaultDrainTimeout = 5 * time.Minute
+
+	mirrorPodAnnotation   = "kubernetes.io/config.mirror"
+	evictionRetryInterval = 5 * time.Second
+	terminationPoll       = 2 * time.Second
+	// drains kept in memory, the oldest finished ones are forgotten first
+	maxDrains = 50
+)
+
+// Drains started by this process, shared by every copy of the K8sHandler
+type DrainTracker struct {
+	mutex  sync.Mutex
+	drains map[string]*cm.Drain
+	order  []string
+}
+
+func NewDrainTracker() *DrainTracker {
+	return &DrainTracker{drains: make(map[string]*cm.Drain)}
+}
+
+// Track the drain, unless the node is already being drained
+func (tracker *DrainTracker) add(drain *cm.Drain) error {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	for _, other := range tracker.drains {
+		if other.Node == drain.Node && other.Status == DrainRunning {
+			return fmt.Errorf("Node %v is already being drained by drain %v", drain.Node, other.Id)
+		}
+	}
+
+	tracker.drains[drain.Id] = drain
+	tracker.order = append(tracker.order, drain.Id)
+
+	for i := 0; len(tracker.order) > maxDrains && i < len(tracker.order); {
+		id := tracker.order[i]
+		if tracker.drains[id].Status == DrainRunning {
+			i++
+			continue
+		}
+		delete(tracker.drains, id)
+		tracker.order = append(tracker.order[:i], tracker.order[i+1:]...)
+	}
+	return nil
+}
+
+func (tracker *DrainTracker) update(id string, change func(drain *cm.Drain)) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	if drain, ok := tracker.drains[id]; ok {
+		change(drain)
+	}
+}
+
+// Copy of the drain, which keeps changing while it runs
+func (tracker *DrainTracker) get(id string) (cm.Drain, bool) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	drain, ok := tracker.drains[id]
+	if !ok {
+		return cm.Drain{}, false
+	}
+	result := *drain
+	result.Evicted = append([]string{}, drain.Evicted...)
+	result.Skipped = append([]string{}, drain.Skipped...)
+	result.Failed = append([]cm.DrainFailure{}, drain.Failed...)
+	result.Progress = append([]cm.DrainEvent{}, drain.Progress...)
+	return result, true
+}
+
+func (tracker *DrainTracker) progress(id string, pod string, message string) {
+	tracker.update(id, func(drain *cm.Drain) {
+		drain.Progress = append(drain.Progress, cm.DrainEvent{Timestamp: time.Now(), Pod: pod, Message: message})
+	})
+}
+
+// Mark the node unschedulable, or schedulable again
+func (kh K8sHandler) CordonNode(user string, name string, unschedulable bool, dryRun bool) (cm.AuditRecord, error) {
+	record := cm.AuditRecord{User: user, Action: ActionUncordon, Kind: "Node", Name: name, DryRun: dryRun}
+	if unschedulable {
+		record.Action = ActionCordon
+	}
+	ctx := context.TODO()
+
+	node, err := kh.K8sClient.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return kh.audit(record, err)
+	}
+	record.Before = map[string]string{"unschedulable": strconv.FormatBool(node.Spec.Unschedulable)}
+
+	patch := []byte(fmt.Sprintf(`{"spec":{"unschedulable":%t}}`, unschedulable))
+	node, err = kh.K8sClient.CoreV1().Nodes().Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{DryRun: dryRunOption(dryRun)})
+	if err != nil {
+		return kh.audit(record, err)
+	}
+	record.After = map[string]string{"unschedulable": strconv.FormatBool(node.Spec.Unschedulable)}
+
+	return kh.audit(record, nil)
+}
+
+// Cordon the node and evict its pods in the background, the drain is followed with GetDrain.
+// A negative grace period keeps the grace period of every pod. Pods without a controller are only evicted with force
+// and pods with emptyDir volumes with deleteEmptyDirData, they are skipped otherwise
+func (kh K8sHandler) DrainNode(user string, name string, gracePeriod int64, timeout time.Duration, force bool, deleteEmptyDirData bool, dryRun bool) (cm.Drain, error) {
+	if _, err := kh.K8sClient.CoreV1().Nodes().Get(context.TODO(), name, metav1.GetOptions{}); err != nil {
+		return cm.Drain{}, err
+	}
+	if timeout <= 0 {
+		return cm.Drain{}, fmt.Errorf("Invalid timeout %v", timeout)
+	}
+
+	drain := &cm.Drain{
+		Id:                 bson.NewObjectId().Hex(),
+		Node:               name,
+		User:               user,
+		DryRun:             dryRun,
+		GracePeriod:        gracePeriod,
+		Timeout:            timeout.String(),
+		Force:              force,
+		DeleteEmptyDirData: deleteEmptyDirData,
+		Status:             DrainRunning,
+		Evicted:            []string{},
+		Skipped:            []string{},
+		Failed:             []cm.DrainFailure{},
+		Progress:           []cm.DrainEvent{},
+		Started:            time.Now(),
+	}
+	if err := kh.drains.add(drain); err != nil {
+		return cm.Drain{}, err
+	}
+	go kh.drain(drain.Id, name, user, gracePeriod, timeout, force, deleteEmptyDirData, dryRun)
+
+	result, _ := kh.drains.get(drain.Id)
+	return result, nil
+}
+
+func (kh K8sHandler) drain(id string, node string, user string, gracePeriod int64, timeout time.Duration, force bool, deleteEmptyDirData bool, dryRun bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	tracker := kh.drains
+	finish := func(err error) {
+		tracker.update(id, func(drain *cm.Drain) {
+			drain.Status = DrainCompleted
+			if err != nil {
+				drain.Progress = append(drain.Progress, cm.DrainEvent{Timestamp: time.Now(), Message: err.Error()})
+			}
+			if err != nil || len(drain.Failed) > 0 {
+				drain.Status = DrainFailed
+			}
+			drain.Finished = time.Now()
+		})
+
+		drain, _ := tracker.get(id)
+		record := cm.AuditRecord{User: user, Action: ActionDrain, Kind: "Node", Name: node, DryRun: dryRun,
+			Before: map[string]string{"drain": id},
+			After: map[string]string{
+				"status":  drain.Status,
+				"evicted": strconv.Itoa(len(drain.Evicted)),
+				"skipped": strconv.Itoa(len(drain.Skipped)),
+				"failed":  strconv.Itoa(len(drain.Failed)),
+			},
+		}
+		kh.audit(record, err)
+	}
+
+	if _, err := kh.CordonNode(user, node, true, dryRun); err != nil {
+		finish(fmt.Errorf("Failed to cordon node %v: %v", node, err))
+		return
+	}
+	tracker.progress(id, "", "node cordoned")
+
+	pods, err := kh.K8sClient.CoreV1().Pods("").List(ctx, metav1.ListOptions{FieldSelector: "spec.nodeName=" + node})
+	if err != nil {
+		finish(fmt.Errorf("Failed to list the pods of node %v: %v", node, err))
+		return
+	}
+	sort.Slice(pods.Items, func(i, j int) bool {
+		return pods.Items[i].Namespace+"/"+pods.Items[i].Name < pods.Items[j].Namespace+"/"+pods.Items[j].Name
+	})
+
+	var wg sync.WaitGroup
+	for _, pod := range pods.Items {
+		key := pod.Namespace + "/" + pod.Name
+		if reason := drainSkipReason(&pod, force, deleteEmptyDirData); reason != "" {
+			tracker.update(id, func(drain *cm.Drain) { drain.Skipped = append(drain.Skipped, key) })
+			tracker.progress(id, key, "skipped, "+reason)
+			continue
+		}
+
+		wg.Add(1)
+		go func(pod corev1.Pod) {
+			defer wg.Done()
+			err := kh.evictPod(ctx, id, &pod, gracePeriod, dryRun)
+			tracker.update(id, func(drain *cm.Drain) {
+				if err != nil {
+					drain.Failed = append(drain.Failed, cm.DrainFailure{Pod: key, Reason: err.Error()})
+				} else {
+					drain.Evicted = append(drain.Evicted, key)
+				}
+			})
+			if err != nil {
+				tracker.progress(id, key, "failed, "+err.Error())
+				return
+			}
+			message := "evicted"
+			if metav1.GetControllerOf(&pod) == nil {
+				message += ", not recreated as it has no controller"
+			}
+			if usesEmptyDir(&pod) {
+				message += ", its emptyDir data is deleted"
+			}
+			tracker.progress(id, key, message)
+		}(pod)
+	}
+	wg.Wait()
+
+	finish(nil)
+}
+
+// Why the pod is left on the node, empty if it must be evicted. A pod without a controller is not recreated
+// and the emptyDir data is lost, so they are only evicted when asked for
+func drainSkipReason(pod *corev1.Pod, force bool, deleteEmptyDirData bool) string {
+	if _, ok := pod.Annotations[mirrorPodAnnotation]; ok {
+		return "mirror pod of a static pod"
+	}
+	owner := metav1.GetControllerOf(pod)
+	if owner != nil && owner.Kind == "DaemonSet" {
+		return "managed by DaemonSet " + owner.Name
+	}
+	if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return "already finished"
+	}
+	if owner == nil && !force {
+		return "not managed by a controller, evicted only with force"
+	}
+	if usesEmptyDir(pod) && !deleteEmptyDirData {
+		return "uses emptyDir data, evicted only with delete_emptydir_data"
+	}
+	return ""
+}
+
+func usesEmptyDir(pod *corev1.Pod) bool {
+	for _, volume := range pod.Spec.Volumes {
+		if volume.EmptyDir != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Evict the pod through the eviction API, which respects the PodDisruptionBudgets, and wait for it to terminate
+func (kh K8sHandler) evictPod(ctx context.Context, id string, pod *corev1.Pod, gracePeriod int64, dryRun bool) error {
+	key := pod.Namespace + "/" + pod.Name
+
+	options := &metav1.DeleteOptions{DryRun: dryRunOption(dryRun)}
+	if gracePeriod >= 0 {
+		options.GracePeriodSeconds = &gracePeriod
+	}
+	eviction := &policyv1.Eviction{
+		ObjectMeta:    metav1.ObjectMeta{Namespace: pod.Namespace, Name: pod.Name},
+		DeleteOptions: options,
+	}
+
+	blocked := false
+	for {
+		err := kh.K8sClient.CoreV1().Pods(pod.Namespace).EvictV1(ctx, eviction)
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err == nil {
+			break
+		}
+		// the eviction would violate a PodDisruptionBudget, it may be allowed once other pods are ready again
+		if !apierrors.IsTooManyRequests(err) {
+			return err
+		}
+		if !blocked {
+			kh.drains.progress(id, key, "waiting for the PodDisruptionBudget to allow the eviction")
+			blocked = true
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("eviction still blocked by a PodDisruptionBudget at the timeout: %v", err)
+		case <-time.After(evictionRetryInterval):
+		}
+	}
+	if dryRun {
+		return nil
+	}
+
+	// a pod of a StatefulSet is recreated with the same name, but not the same uid
+	for {
+		current, err := kh.K8sClient.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) || (err == nil && current.UID != pod.UID) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("pod was not terminated before the timeout")
+		case <-time.After(terminationPoll):
+		}
+	}
+}
+
+func (kh K8sHandler) GetDrain(id string) (cm.Drain, error) {
+	drain, ok := kh.drains.get(id)
+	if !ok {
+		return drain, fmt.Errorf("Drain %v not found", id)
+	}
+	return drain, nil
+}
+
+// Drains kept in memory, the latest first
+func (kh K8sHandler) GetDrains() []cm.Drain {
+	tracker := kh.drains
+	tracker.mutex.Lock()
+	ids := append([]string{}, tracker.order...)
+	tracker.mutex.Unlock()
+
+	result := []cm.Drain{}
+	for i := len(ids) - 1; i >= 0; i-- {
+		if drain, ok := tracker.get(ids[i]); ok {
+			drain.Progress = nil
+			result = append(result, drain)
+		}
+	}
+	return result
+}
@@ -0,0 +1,59 @@
+package k8s
+
+import (
+	cm "github.com/royroyee/kubem/common"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+)
+
+func TestDrainSkipReason(t *testing.T) {
+	controller := true
+	managed := metav1.ObjectMeta{OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "api", Controller: &controller}}}
+	emptyDir := corev1.PodSpec{Volumes: []corev1.Volume{{Name: "cache", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}}}
+
+	tests := []struct {
+		name               string
+		pod                corev1.Pod
+		force              bool
+		deleteEmptyDirData bool
+		skipped            bool
+	}{
+		{name: "managed", pod: corev1.Pod{ObjectMeta: managed}},
+		{name: "DaemonSet", pod: corev1.Pod{ObjectMeta: metav1.ObjectMeta{OwnerReferences: []metav1.OwnerReference{{Kind: "DaemonSet", Name: "agent", Controller: &controller}}}}, force: true, skipped: true},
+		{name: "mirror", pod: corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{mirrorPodAnnotation: "x"}}}, force: true, skipped: true},
+		{name: "finished", pod: corev1.Pod{ObjectMeta: managed, Status: corev1.PodStatus{Phase: corev1.PodSucceeded}}, skipped: true},
+		{name: "unmanaged", pod: corev1.Pod{}, skipped: true},
+		{name: "unmanaged with force", pod: corev1.Pod{}, force: true},
+		{name: "emptyDir", pod: corev1.Pod{ObjectMeta: managed, Spec: emptyDir}, skipped: true},
+		{name: "emptyDir with delete_emptydir_data", pod: corev1.Pod{ObjectMeta: managed, Spec: emptyDir}, deleteEmptyDirData: true},
+		{name: "unmanaged emptyDir with force only", pod: corev1.Pod{Spec: emptyDir}, force: true, skipped: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reason := drainSkipReason(&test.pod, test.force, test.deleteEmptyDirData)
+			if skipped := reason != ""; skipped != test.skipped {
+				t.Errorf("expected skipped %v, got reason %q", test.skipped, reason)
+			}
+		})
+	}
+}
+
+func TestDrainTrackerRejectsRunningNode(t *testing.T) {
+	tracker := NewDrainTracker()
+	first := &cm.Drain{Id: "1", Node: "node-1", Status: DrainRunning}
+	if err := tracker.add(first); err != nil {
+		t.Fatal(err)
+	}
+	if err := tracker.add(&cm.Drain{Id: "2", Node: "node-1", Status: DrainRunning}); err == nil {
+		t.Error("expected a second drain of the node to be rejected")
+	}
+	if err := tracker.add(&cm.Drain{Id: "3", Node: "node-2", Status: DrainRunning}); err != nil {
+		t.Errorf("expected a drain of another node, got %v", err)
+	}
+
+	tracker.update("1", func(drain *cm.Drain) { drain.Status = DrainCompleted })
+	if err := tracker.add(&cm.Drain{Id: "4", Node: "node-1", Status: DrainRunning}); err != nil {
+		t.Errorf("expected a new drain once the first is done, got %v", err)
+	}
+}
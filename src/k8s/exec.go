@@ -0,0 +1,94 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	cm "github.com/royroyee/kubem/common"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/remotecommand"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ActionExec   = "exec"
+	ActionAttach = "attach"
+)
+
+// Run the command in the container, or attach to its main process, until the stream ends.
+// The session is audited when it starts, and again with its duration when it ends
+func (kh K8sHandler) Exec(ctx context.Context, user string, namespace string, name string, container string, command []string, attach bool, streams remotecommand.StreamOptions) error {
+	record := cm.AuditRecord{User: user, Action: ActionExec, Kind: "Pod", Namespace: namespace, Name: name}
+	if attach {
+		record.Action = ActionAttach
+	}
+	record.Before = map[string]string{
+		"container": container,
+		"command":   strings.Join(command, " "),
+		"tty":       strconv.FormatBool(streams.Tty),
+	}
+	log.Printf("User %s starts to %s %s/%s %s", user, record.Action, namespace, name, container)
+
+	// the session is audited before it starts, it may not end cleanly
+	start, err := kh.audit(record, nil)
+	if err != nil {
+		return fmt.Errorf("Session is not started as it could not be audited")
+	}
+
+	started := time.Now()
+	err = kh.stream(ctx, namespace, name, container, command, attach, streams)
+	record.After = map[string]string{"session": start.Id, "duration": time.Since(started).Round(time.Second).String()}
+
+	_, err = kh.audit(record, err)
+	return err
+}
+
+func (kh K8sHandler) stream(ctx context.Context, namespace string, name string, container string, command []string, attach bool, streams remotecommand.StreamOptions) error {
+	request := kh.K8sClient.CoreV1().RESTClient().Post().
+		Resource("pods").Namespace(namespace).Name(name)
+
+	// with a TTY stderr is merged into stdout
+	if attach {
+		request = request.SubResource("attach").VersionedParams(&corev1.PodAttachOptions{
+			Container: container,
+			Stdin:     streams.Stdin != nil,
+			Stdout:    streams.Stdout != nil,
+			Stderr:    streams.Stderr != nil && !streams.Tty,
+			TTY:       streams.Tty,
+		}, scheme.ParameterCodec)
+	} else {
+		request = request.SubResource("exec").VersionedParams(&corev1.PodExecOptions{
+			Container: container,
+			Command:   command,
+			Stdin:     streams.Stdin != nil,
+			Stdout:    streams.Stdout != nil,
+			Stderr:    streams.Stderr != nil && !streams.Tty,
+			TTY:       streams.Tty,
+		}, scheme.ParameterCodec)
+	}
+	if streams.Tty {
+		streams.Stderr = nil
+	}
+
+	// WebSocket first, SPDY for API servers which do not support it yet, as kubectl does
+	spdyExecutor, err := remotecommand.NewSPDYExecutor(kh.config, "POST", request.URL())
+	if err != nil {
+		return err
+	}
+	websocketExecutor, err := remotecommand.NewWebSocketExecutor(kh.config, "GET", request.URL().String())
+	if err != nil {
+		return err
+	}
+	executor, err := remotecommand.NewFallbackExecutor(websocketExecutor, spdyExecutor, func(err error) bool {
+		return httpstream.IsUpgradeFailure(err) || httpstream.IsHTTPSProxyError(err)
+	})
+	if err != nil {
+		return err
+	}
+
+	return executor.StreamWithContext(ctx, streams)
+}
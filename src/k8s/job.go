@@ -0,0 +1,242 @@
+package k8s
+
+import (
+	"fmt"
+	"github.com/robfig/cron/v3"
+	cm "github.com/royroyee/kubem/common"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	"log"
+	"sort"
+	"time"
+)
+
+const (
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+
+	// a schedule is missed if no run started this long after it
+	missedScheduleGrace = time.Minute
+	// bound of the schedules counted since a point in time
+	maxScheduleCount = 1000
+	// runs of a CronJob checked for overlaps
+	overlapRuns = 50
+)
+
+// Record every Job run as the informer sees the Jobs change
+func (kh K8sHandler) TrackJobs() {
+	informer := kh.informers.Batch().V1().Jobs().Informer()
+	kh.informers.Batch().V1().CronJobs().Informer()
+
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if job, ok := obj.(*batchv1.Job); ok {
+				kh.recordJobRun(job)
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			if job, ok := newObj.(*batchv1.Job); ok {
+				kh.recordJobRun(job)
+			}
+		},
+	})
+}
+
+func (kh K8sHandler) recordJobRun(job *batchv1.Job) {
+	run := cm.JobRun{
+		Namespace: job.Namespace,
+		Name:      job.Name,
+		Uid:       string(job.UID),
+		Status:    JobRunning,
+		StartTime: job.CreationTimestamp.Time,
+		Active:    job.Status.Active,
+		Succeeded: job.Status.Succeeded,
+		Failed:    job.Status.Failed,
+	}
+	if owner := metav1.GetControllerOf(job); owner != nil && owner.Kind == "CronJob" {
+		run.CronJob = owner.Name
+	}
+	if job.Status.StartTime != nil {
+		run.StartTime = job.Status.StartTime.Time
+	}
+
+	for _, condition := range job.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch condition.Type {
+		case batchv1.JobComplete:
+			run.Status = JobSucceeded
+			run.CompletionTime = condition.LastTransitionTime.Time
+		case batchv1.JobFailed:
+			run.Status = JobFailed
+			run.CompletionTime = condition.LastTransitionTime.Time
+			run.Reason = condition.Reason
+			run.Message = condition.Message
+		}
+	}
+	if job.Status.CompletionTime != nil {
+		run.CompletionTime = job.Status.CompletionTime.Time
+	}
+	if !run.CompletionTime.IsZero() {
+		run.Duration = int64(run.CompletionTime.Sub(run.StartTime).Seconds())
+	}
+
+	if run.Status == JobFailed {
+		if problem, ok := kh.jobPodProblem(job); ok {
+			run.Message = fmt.Sprintf("%s (pod %s: %s %s)", run.Message, problem.Name, problem.Reason, problem.Message)
+		}
+	}
+
+	kh.StoreJobRunInDB(run)
+}
+
+// Problem of a failed pod of the job, which is usually the actual failure reason
+func (kh K8sHandler) jobPodProblem(job *batchv1.Job) (cm.PodProblem, bool) {
+	if job.Spec.Selector == nil {
+		return cm.PodProblem{}, false
+	}
+	selector, err := metav1.LabelSelectorAsSelector(job.Spec.Selector)
+	if err != nil {
+		log.Println(err)
+		return cm.PodProblem{}, false
+	}
+	pods, err := kh.informers.Core().V1().Pods().Lister().Pods(job.Namespace).List(selector)
+	if err != nil {
+		log.Println(err)
+		return cm.PodProblem{}, false
+	}
+	for _, pod := range pods {
+		if problem, ok := classifyPod(pod); ok {
+			return problem, true
+		}
+	}
+	return cm.PodProblem{}, false
+}
+
+// CronJobs of the namespace (all namespaces if empty)
+func (kh K8sHandler) GetCronJobs(namespace string, page int, perPage int) ([]cm.CronJobInfo, error) {
+	result := []cm.CronJobInfo{}
+
+	cronJobs, err := kh.informers.Batch().V1().CronJobs().Lister().CronJobs(namespace).List(labels.Everything())
+	if err != nil {
+		return result, err
+	}
+	now := time.Now()
+	for _, cronJob := range cronJobs {
+		info, err := kh.cronJobInfo(cronJob, now)
+		if err != nil {
+			return result, err
+		}
+		result = append(result, info)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Namespace+"/"+result[i].Name < result[j].Namespace+"/"+result[j].Name
+	})
+
+	start, end := pageBounds(len(result), page, perPage)
+	return result[start:end], nil
+}
+
+// CronJob with its recent runs
+func (kh K8sHandler) GetCronJob(namespace string, name string) (cm.CronJobInfo, error) {
+	cronJob, err := kh.informers.Batch().V1().CronJobs().Lister().CronJobs(namespace).Get(name)
+	if err != nil {
+		return cm.CronJobInfo{}, err
+	}
+	info, err := kh.cronJobInfo(cronJob, time.Now())
+	if err != nil {
+		return info, err
+	}
+	info.Runs, err = kh.GetJobRuns(namespace, name, "", 1, 10)
+	if err != nil {
+		return info, err
+	}
+	return info, nil
+}
+
+func (kh K8sHandler) cronJobInfo(cronJob *batchv1.CronJob, now time.Time) (cm.CronJobInfo, error) {
+	result := cm.CronJobInfo{
+		Namespace:         cronJob.Namespace,
+		Name:              cronJob.Name,
+		Schedule:          cronJob.Spec.Schedule,
+		Suspended:         cronJob.Spec.Suspend != nil && *cronJob.Spec.Suspend,
+		ConcurrencyPolicy: string(cronJob.Spec.ConcurrencyPolicy),
+	}
+	for _, active := range cronJob.Status.Active {
+		result.ActiveRuns = append(result.ActiveRuns, active.Name)
+	}
+
+	lastSchedule := cronJob.CreationTimestamp.Time
+	if cronJob.Status.LastScheduleTime != nil {
+		lastSchedule = cronJob.Status.LastScheduleTime.Time
+		result.LastScheduleTime = lastSchedule.Format("2006-01-02 15:04")
+	}
+	lastSuccess := cronJob.CreationTimestamp.Time
+	if cronJob.Status.LastSuccessfulTime != nil {
+		lastSuccess = cronJob.Status.LastSuccessfulTime.Time
+		result.LastSuccessfulTime = lastSuccess.Format("2006-01-02 15:04")
+	}
+
+	schedule, err := cronJobSchedule(cronJob)
+	if err != nil {
+		// the schedule is validated by the API server, only the derived fields are missing
+		log.Println(err)
+	} else {
+		result.NextScheduleTime = schedule.Next(now).Format("2006-01-02 15:04")
+		result.IntervalsSinceOk = countSchedules(schedule, lastSuccess, now)
+
+		if !result.Suspended {
+			grace := missedScheduleGrace
+			if cronJob.Spec.StartingDeadlineSeconds != nil {
+				grace = time.Duration(*cronJob.Spec.StartingDeadlineSeconds) * time.Second
+			}
+			result.MissedSchedules = countSchedules(schedule, lastSchedule, now.Add(-grace))
+		}
+	}
+
+	runs, err := kh.GetJobRuns(cronJob.Namespace, cronJob.Name, "", 1, overlapRuns)
+	if err != nil {
+		return result, err
+	}
+	result.OverlappingRuns = overlappingRuns(runs)
+
+	return result, nil
+}
+
+// Schedule of the CronJob in its time zone
+func cronJobSchedule(cronJob *batchv1.CronJob) (cron.Schedule, error) {
+	spec := cronJob.Spec.Schedule
+	if cronJob.Spec.TimeZone != nil {
+		spec = "CRON_TZ=" + *cronJob.Spec.TimeZone + " " + spec
+	}
+	return cron.ParseStandard(spec)
+}
+
+// Number of scheduled times after from and not after to
+func countSchedules(schedule cron.Schedule, from time.Time, to time.Time) int {
+	count := 0
+	for next := schedule.Next(from); !next.After(to) && count < maxScheduleCount; next = schedule.Next(next) {
+		count++
+	}
+	return count
+}
+
+// Runs which started before the previous run ended
+func overlappingRuns(runs []cm.JobRun) int {
+	sorted := append([]cm.JobRun{}, runs...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].StartTime.Before(sorted[j].StartTime) })
+
+	count := 0
+	for i := 1; i < len(sorted); i++ {
+		previous := sorted[i-1]
+		if previous.Status == JobRunning || sorted[i].StartTime.Before(previous.CompletionTime) {
+			count++
+		}
+	}
+	return count
+}
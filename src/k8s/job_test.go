@@ -0,0 +1,110 @@
+package k8s
+
+import (
+	"github.com/robfig/cron/v3"
+	cm "github.com/royroyee/kubem/common"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+	"time"
+)
+
+func mustSchedule(t *testing.T, spec string) cron.Schedule {
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return schedule
+}
+
+func TestCountSchedules(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		schedule string
+		from     time.Time
+		to       time.Time
+		expected int
+	}{
+		{"hourly over a day", "0 * * * *", base, base.Add(24 * time.Hour), 24},
+		{"from is excluded", "0 * * * *", base, base.Add(time.Hour), 1},
+		{"to is included", "0 * * * *", base.Add(-time.Minute), base, 1},
+		{"before the first schedule", "0 * * * *", base, base.Add(59 * time.Minute), 0},
+		{"daily over a week", "30 2 * * *", base, base.AddDate(0, 0, 7), 7},
+		{"empty range", "* * * * *", base, base, 0},
+		{"capped", "* * * * *", base, base.AddDate(1, 0, 0), maxScheduleCount},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if count := countSchedules(mustSchedule(t, test.schedule), test.from, test.to); count != test.expected {
+				t.Errorf("expected %d schedules, got %d", test.expected, count)
+			}
+		})
+	}
+}
+
+func TestFailedIntervals(t *testing.T) {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	schedule := mustSchedule(t, "0 * * * *")
+
+	tests := []struct {
+		name        string
+		lastSuccess time.Time
+		active      bool
+		now         time.Time
+		expected    int
+	}{
+		{"run of the current schedule in progress", created, true, created.Add(time.Hour + time.Minute), 0},
+		{"run of the current schedule done", created.Add(time.Hour + time.Minute), false, created.Add(time.Hour + 2*time.Minute), 0},
+		{"never succeeded", time.Time{}, false, created.Add(3*time.Hour + time.Minute), 3},
+		{"two missed and one in progress", created, true, created.Add(3*time.Hour + time.Minute), 2},
+		{"before the first schedule", time.Time{}, false, created.Add(30 * time.Minute), 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cronJob := &batchv1.CronJob{ObjectMeta: metav1.ObjectMeta{CreationTimestamp: metav1.NewTime(created)}}
+			if !test.lastSuccess.IsZero() {
+				lastSuccess := metav1.NewTime(test.lastSuccess)
+				cronJob.Status.LastSuccessfulTime = &lastSuccess
+			}
+			if test.active {
+				cronJob.Status.Active = []corev1.ObjectReference{{Name: "job"}}
+			}
+			if intervals := failedIntervals(cronJob, schedule, test.now); intervals != test.expected {
+				t.Errorf("expected %d failed intervals, got %d", test.expected, intervals)
+			}
+		})
+	}
+}
+
+func TestOverlappingRuns(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	run := func(start int, end int, status string) cm.JobRun {
+		result := cm.JobRun{Status: status, StartTime: base.Add(time.Duration(start) * time.Minute)}
+		if end > 0 {
+			result.CompletionTime = base.Add(time.Duration(end) * time.Minute)
+		}
+		return result
+	}
+
+	tests := []struct {
+		name     string
+		runs     []cm.JobRun
+		expected int
+	}{
+		{"no runs", nil, 0},
+		{"sequential", []cm.JobRun{run(0, 5, JobSucceeded), run(10, 15, JobSucceeded)}, 0},
+		{"overlapping", []cm.JobRun{run(0, 15, JobSucceeded), run(10, 20, JobSucceeded)}, 1},
+		{"unsorted", []cm.JobRun{run(10, 20, JobSucceeded), run(0, 15, JobSucceeded)}, 1},
+		{"after a running one", []cm.JobRun{run(0, 0, JobRunning), run(60, 0, JobRunning)}, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if count := overlappingRuns(test.runs); count != test.expected {
+				t.Errorf("expected %d overlapping runs, got %d", test.expected, count)
+			}
+		})
+	}
+}
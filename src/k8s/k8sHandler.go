@@ -8,7 +8,11 @@ import (
 	"gopkg.in/mgo.v2"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/metrics/pkg/client/clientset/versioned"
 	"log"
 	"regexp"
@@ -16,27 +20,40 @@ import (
 
 // K8s
 type K8sHandler struct {
-	K8sClient       *kubernetes.Clientset
+	K8sClient       kubernetes.Interface // not the Clientset, so that the fake clientset can stand in
 	MetricK8sClient *versioned.Clientset
+	config          *rest.Config // for the exec and attach streams
+	metadataClient  metadata.Interface
 	session         *mgo.Session
+	informers       informers.SharedInformerFactory
+	alerts          *AlertEngine
+	notifier        *Notifier
+	drains          *DrainTracker
+	certificates    *CertificateCache
 }
 
 func NewK8sHandler() *K8sHandler {
 
-	//In Cluster
-	kh := &K8sHandler{
+	// the clients and the exec streams use the same config
+	//// In Cluster
+	//config := cm.ConfigInCluster()
+	// Out of Cluster
+	config := cm.ConfigOutofCluster()
 
-		K8sClient:       cm.ClientSetOutofCluster(),
-		MetricK8sClient: cm.MetricClientSetOutofCluster(),
+	kh := &K8sHandler{
+		K8sClient:       kubernetes.NewForConfigOrDie(config),
+		MetricK8sClient: versioned.NewForConfigOrDie(config),
+		config:          config,
 		session:         GetDBSession(),
 	}
 
-	////// Out of Cluster
-	//kh := &K8sHandler{
-	//	K8sClient:       cm.ClientSetOutofCluster(),
-	//	MetricK8sClient: cm.MetricClientSetOutofCluster(),
-	//	session:         GetDBSession(),
-	//}
+	// lists objects without their content, e.g. Secrets
+	kh.metadataClient = metadata.NewForConfigOrDie(kh.config)
+	kh.informers = informers.NewSharedInformerFactory(kh.K8sClient, 0)
+	kh.alerts = NewAlertEngine()
+	kh.notifier = NewNotifier()
+	kh.drains = NewDrainTracker()
+	kh.certificates = NewCertificateCache()
 
 	return kh
 }
@@ -70,6 +87,74 @@ func (kh K8sHandler) WatchEvents() {
 	}
 }
 
+// Start the shared informers and wait for their caches, the listers are only usable afterwards
+func (kh K8sHandler) StartInformers() {
+	log.Println("Start Informers .. ")
+
+	// Register the informers before starting the factory
+	kh.informers.Core().V1().Nodes().Informer()
+	kh.informers.Core().V1().Pods().Informer()
+	kh.informers.Core().V1().Events().Informer()
+	kh.informers.Core().V1().Services().Informer()
+	kh.informers.Discovery().V1().EndpointSlices().Informer()
+	kh.informers.Networking().V1().Ingresses().Informer()
+	kh.informers.Policy().V1().PodDisruptionBudgets().Informer()
+
+	stopCh := make(chan struct{})
+	kh.informers.Start(stopCh)
+	kh.informers.WaitForCacheSync(stopCh)
+
+	log.Println("Success to Sync Informers")
+}
+
+// Record every node condition transition so that flapping nodes can be tracked over time.
+// The Nodes informer re-establishes its watch, which the API server closes every few minutes
+func (kh K8sHandler) TrackNodeConditions() {
+	informer := kh.informers.Core().V1().Nodes().Informer()
+
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if node, ok := obj.(*v1.Node); ok {
+				kh.recordNodeConditions(node, nil)
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldNode, ok := oldObj.(*v1.Node)
+			if !ok {
+				return
+			}
+			if node, ok := newObj.(*v1.Node); ok {
+				kh.recordNodeConditions(node, oldNode)
+			}
+		},
+	})
+}
+
+// Store the conditions of the node which changed since the old node, all of them without it.
+// Heartbeats update the node every few seconds without any transition
+func (kh K8sHandler) recordNodeConditions(node *v1.Node, oldNode *v1.Node) {
+	previous := map[v1.NodeConditionType]metav1.Time{}
+	if oldNode != nil {
+		for _, condition := range oldNode.Status.Conditions {
+			previous[condition.Type] = condition.LastTransitionTime
+		}
+	}
+
+	for _, condition := range node.Status.Conditions {
+		if transition, ok := previous[condition.Type]; ok && transition.Equal(&condition.LastTransitionTime) {
+			continue
+		}
+		kh.StoreNodeConditionInDB(cm.NodeConditionTransition{
+			Name:      node.Name,
+			Type:      string(condition.Type),
+			Status:    string(condition.Status),
+			Reason:    condition.Reason,
+			Message:   condition.Message,
+			Timestamp: condition.LastTransitionTime.Time,
+		})
+	}
+}
+
 // overview
 func (kh K8sHandler) GetOverviewStatus() (cm.Overview, error) {
 	var result cm.Overview
@@ -78,7 +163,7 @@ func (kh K8sHandler) GetOverviewStatus() (cm.Overview, error) {
 	if err != nil {
 		return result, err
 	}
-	running, pending, errorStatus, err := kh.podStatus()
+	running, pending, errorStatus, problems, err := kh.podStatus()
 	if err != nil {
 		return result, err
 	}
@@ -89,9 +174,10 @@ func (kh K8sHandler) GetOverviewStatus() (cm.Overview, error) {
 			Ready:    ready,
 		},
 		PodStatus: cm.PodStatus{
-			Error:   errorStatus,
-			Pending: pending,
-			Running: running,
+			Error:    errorStatus,
+			Pending:  pending,
+			Running:  running,
+			Problems: problems,
 		},
 	}
 
@@ -130,10 +216,45 @@ func (kh K8sHandler) GetNodeInfo(nodeName string) (cm.NodeInfo, error) {
 	result.CpuCores = capacity.Cpu().Value()
 	result.RamCapacity = node.Status.Capacity.Memory().Value() / 1024 / 1024 / 1024
 
+	result.Conditions = nodeConditions(node)
+	result.Taints = nodeTaints(node)
+	for key, value := range node.Labels {
+		result.Labels = append(result.Labels, fmt.Sprintf("%s=%s", key, value))
+	}
+	result.Unschedulable = node.Spec.Unschedulable
+	result.HeartbeatAge = kh.nodeHeartbeatAge(nodeName)
+
 	return result, nil
 
 }
 
+// Every pod scheduled on the node, sorted by the given column (json field name)
+func (kh K8sHandler) GetPodsOfNode(nodeName string, sortBy string, order string) ([]cm.PodResource, error) {
+	var result []cm.PodResource
+
+	pods, err := kh.K8sClient.CoreV1().Pods(metav1.NamespaceAll).List(context.Background(), metav1.ListOptions{FieldSelector: "spec.nodeName=" + nodeName})
+	if err != nil {
+		log.Println(err)
+		return result, err
+	}
+
+	usages, err := kh.podUsages(metav1.NamespaceAll)
+	if err != nil {
+		// usage is optional, metrics-server may not be installed
+		log.Println(err)
+	}
+
+	for _, pod := range pods.Items {
+		result = append(result, podResource(&pod, usages[pod.Namespace+"/"+pod.Name]))
+	}
+
+	if err := sortPodResources(result, sortBy, order == "desc"); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
 func (kh K8sHandler) NumberOfNodes() (cm.Count, error) {
 	var result cm.Count
 
@@ -0,0 +1,509 @@
+package k8s
+
+import (
+	"fmt"
+	cm "github.com/royroyee/kubem/common"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"os"
+	"regexp"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Custom rules, evaluated with the built-in ones over the object of every workload, e.g.
+//
+//	rules:
+//	  - name: team-label
+//	    severity: medium
+//	    message: workloads must have a team label
+//	    require:
+//	      - path: metadata.labels[team]
+//	        exists: true
+//	  - name: pinned-registry
+//	    kinds: [Deployment, StatefulSet]
+//	    require:
+//	      - path: spec.template.spec.containers[*].image
+//	        matches: ^registry\.example\.com/
+//	  - name: replicated-memory
+//	    kinds: [Deployment]
+//	    when:
+//	      - path: spec.replicas
+//	        min: 2
+//	    require:
+//	      - path: spec.template.spec.containers[*].resources.requests.memory
+//	        min: 128Mi
+var lintRulesPath = cm.GetEnv("KUBEM_LINT_RULES", "lint-rules.yaml")
+
+// Annotation of a workload listing the rules waived for it, e.g. kubem.io/lint-waivers: probes,team-label
+const lintWaiverAnnotation = "kubem.io/lint-waivers"
+
+var workloadKinds = []string{"Deployment", "StatefulSet", "DaemonSet", "CronJob", "Job", "Pod"}
+
+type lintRule struct {
+	cm.LintRule
+	// messages of the violations of the rule by the workload, object is the workload as unstructured content
+	check func(kh K8sHandler, workload workload, object map[string]interface{}) ([]string, error)
+}
+
+type lintCondition struct {
+	cm.LintCondition
+	path    []pathSegment
+	matches *regexp.Regexp
+	min     *float64
+	max     *float64
+}
+
+// Key of a map or index of a list, * for all of them
+type pathSegment struct {
+	key      string
+	wildcard bool
+}
+
+type pathValue struct {
+	value interface{}
+	found bool
+}
+
+var builtInLintRules = []lintRule{
+	{
+		LintRule: cm.LintRule{Name: "probes", Severity: SeverityMedium, Message: "containers have readiness and liveness probes",
+			Kinds: []string{"Deployment", "StatefulSet", "DaemonSet"}, BuiltIn: true},
+		check: lintProbes,
+	},
+	{
+		LintRule: cm.LintRule{Name: "pod-disruption-budget", Severity: SeverityMedium, Message: "replicated workloads are covered by a PodDisruptionBudget",
+			Kinds: []string{"Deployment", "StatefulSet"}, BuiltIn: true},
+		check: lintDisruptionBudget,
+	},
+	{
+		LintRule: cm.LintRule{Name: "pod-anti-affinity", Severity: SeverityLow, Message: "replicas of replicated workloads are spread over nodes",
+			Kinds: []string{"Deployment", "StatefulSet"}, BuiltIn: true},
+		check: lintAntiAffinity,
+	},
+}
+
+// Built-in rules and the custom rules of the rules file, which is optional
+func loadLintRules() ([]lintRule, error) {
+	result := append([]lintRule{}, builtInLintRules...)
+
+	data, err := os.ReadFile(lintRulesPath)
+	if os.IsNotExist(err) {
+		return result, nil
+	}
+	if err != nil {
+		return result, fmt.Errorf("Failed to read lint rules: %v", err)
+	}
+	rules, err := parseLintRules(data)
+	if err != nil {
+		return result, err
+	}
+	return append(result, rules...), nil
+}
+
+func parseLintRules(data []byte) ([]lintRule, error) {
+	var file struct {
+		Rules []cm.LintRule `json:"rules"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("Failed to parse lint rules: %v", err)
+	}
+
+	var result []lintRule
+	names := make(map[string]bool)
+	for _, rule := range builtInLintRules {
+		names[rule.Name] = true
+	}
+	for _, rule := range file.Rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("Lint rule without name")
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("Duplicate lint rule %v", rule.Name)
+		}
+		names[rule.Name] = true
+
+		if rule.Severity == "" {
+			rule.Severity = SeverityLow
+		}
+		if severityRank[rule.Severity] == 0 {
+			return nil, fmt.Errorf("Invalid severity %v of lint rule %v", rule.Severity, rule.Name)
+		}
+		for _, kind := range rule.Kinds {
+			if !contains(workloadKinds, kind) {
+				return nil, fmt.Errorf("Invalid kind %v of lint rule %v", kind, rule.Name)
+			}
+		}
+		if len(rule.Require) == 0 {
+			return nil, fmt.Errorf("Lint rule %v requires nothing", rule.Name)
+		}
+		rule.BuiltIn = false
+
+		when, err := compileLintConditions(rule.When)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid condition of lint rule %v: %v", rule.Name, err)
+		}
+		require, err := compileLintConditions(rule.Require)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid condition of lint rule %v: %v", rule.Name, err)
+		}
+
+		message := rule.Message
+		result = append(result, lintRule{
+			LintRule: rule,
+			check: func(kh K8sHandler, workload workload, object map[string]interface{}) ([]string, error) {
+				for _, condition := range when {
+					if !condition.holds(object) {
+						return nil, nil
+					}
+				}
+				for _, condition := range require {
+					if condition.holds(object) {
+						continue
+					}
+					if message == "" {
+						return []string{fmt.Sprintf("requirement on %s does not hold", condition.Path)}, nil
+					}
+					return []string{message}, nil
+				}
+				return nil, nil
+			},
+		})
+	}
+	return result, nil
+}
+
+func compileLintConditions(conditions []cm.LintCondition) ([]lintCondition, error) {
+	var result []lintCondition
+	for _, condition := range conditions {
+		compiled := lintCondition{LintCondition: condition}
+
+		path, err := parsePath(condition.Path)
+		if err != nil {
+			return nil, err
+		}
+		compiled.path = path
+
+		if condition.Matches != "" {
+			compiled.matches, err = regexp.Compile(condition.Matches)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if condition.Min != "" {
+			bound, ok := numberOf(string(condition.Min))
+			if !ok {
+				return nil, fmt.Errorf("Invalid min %v", condition.Min)
+			}
+			compiled.min = &bound
+		}
+		if condition.Max != "" {
+			bound, ok := numberOf(string(condition.Max))
+			if !ok {
+				return nil, fmt.Errorf("Invalid max %v", condition.Max)
+			}
+			compiled.max = &bound
+		}
+		result = append(result, compiled)
+	}
+	return result, nil
+}
+
+// Segments of a path like spec.template.spec.containers[*].image, keys with dots are written in brackets,
+// e.g. metadata.labels[app.kubernetes.io/name]
+func parsePath(path string) ([]pathSegment, error) {
+	var result []pathSegment
+	for i := 0; i < len(path); {
+		switch path[i] {
+		case '.':
+			i++
+		case '[':
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("Unclosed [ in path %v", path)
+			}
+			key := path[i+1 : i+end]
+			result = append(result, pathSegment{key: key, wildcard: key == "*"})
+			i += end + 1
+		default:
+			end := strings.IndexAny(path[i:], ".[")
+			if end < 0 {
+				end = len(path) - i
+			}
+			result = append(result, pathSegment{key: path[i : i+end]})
+			i += end
+		}
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("Empty path")
+	}
+	return result, nil
+}
+
+// Values at the path, one for every element a wildcard goes through
+func lookupPath(value interface{}, path []pathSegment) []pathValue {
+	if len(path) == 0 {
+		return []pathValue{{value: value, found: true}}
+	}
+	segment := path[0]
+
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		if segment.wildcard {
+			var result []pathValue
+			for _, element := range typed {
+				result = append(result, lookupPath(element, path[1:])...)
+			}
+			return result
+		}
+		if element, ok := typed[segment.key]; ok && element != nil {
+			return lookupPath(element, path[1:])
+		}
+	case []interface{}:
+		if segment.wildcard {
+			var result []pathValue
+			for _, element := range typed {
+				result = append(result, lookupPath(element, path[1:])...)
+			}
+			return result
+		}
+		if index, err := strconv.Atoi(segment.key); err == nil && index >= 0 && index < len(typed) {
+			return lookupPath(typed[index], path[1:])
+		}
+	}
+	return []pathValue{{found: false}}
+}
+
+// The condition holds if it holds for every value at the path, e.g. for every container
+func (condition lintCondition) holds(object map[string]interface{}) bool {
+	for _, value := range lookupPath(object, condition.path) {
+		if !condition.holdsFor(value) {
+			return false
+		}
+	}
+	return true
+}
+
+func (condition lintCondition) holdsFor(value pathValue) bool {
+	if condition.Exists != nil && value.found != *condition.Exists {
+		return false
+	}
+	// only exists: false is met by a missing value
+	if !value.found {
+		return condition.Exists != nil
+	}
+
+	text := fmt.Sprint(value.value)
+	if condition.Equals != nil && text != *condition.Equals {
+		return false
+	}
+	if len(condition.OneOf) > 0 && !contains(condition.OneOf, text) {
+		return false
+	}
+	if condition.matches != nil && !condition.matches.MatchString(text) {
+		return false
+	}
+	if condition.min != nil || condition.max != nil {
+		number, ok := numberOf(value.value)
+		if !ok {
+			return false
+		}
+		if condition.min != nil && number < *condition.min {
+			return false
+		}
+		if condition.max != nil && number > *condition.max {
+			return false
+		}
+	}
+	return true
+}
+
+// Numeric value of a number or of a quantity like 500m or 128Mi
+func numberOf(value interface{}) (float64, bool) {
+	switch typed := value.(type) {
+	case int64:
+		return float64(typed), true
+	case float64:
+		return typed, true
+	case string:
+		quantity, err := resource.ParseQuantity(typed)
+		if err != nil {
+			return 0, false
+		}
+		return quantity.AsApproximateFloat64(), true
+	}
+	return 0, false
+}
+
+// Rules waived by the annotation of the workload
+func lintWaivers(meta metav1.ObjectMeta) []string {
+	var result []string
+	for _, name := range strings.Split(meta.Annotations[lintWaiverAnnotation], ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+// Findings of the workloads of the namespace (all namespaces if empty) and a summary of every namespace,
+// only those of the given rule if not empty
+func (kh K8sHandler) GetLintFindings(namespace string, rule string, page int, perPage int) (cm.LintReport, error) {
+	result := cm.LintReport{Namespaces: []cm.NamespaceLint{}, Workloads: []cm.WorkloadLint{}}
+
+	rules, err := loadLintRules()
+	if err != nil {
+		return result, err
+	}
+	if rule != "" {
+		var selected []lintRule
+		for _, candidate := range rules {
+			if candidate.Name == rule {
+				selected = append(selected, candidate)
+			}
+		}
+		if len(selected) == 0 {
+			return result, fmt.Errorf("Unknown lint rule %v", rule)
+		}
+		rules = selected
+	}
+
+	workloads, err := kh.workloads(namespace)
+	if err != nil {
+		return result, err
+	}
+
+	summaries := make(map[string]*cm.NamespaceLint)
+	for _, workload := range workloads {
+		object, err := runtime.DefaultUnstructuredConverter.ToUnstructured(workload.object)
+		if err != nil {
+			return result, err
+		}
+		waivers := lintWaivers(workload.meta)
+
+		entry := cm.WorkloadLint{
+			Namespace: workload.meta.Namespace,
+			Kind:      workload.kind,
+			Name:      workload.meta.Name,
+			Findings:  []cm.LintFinding{},
+		}
+		for _, rule := range rules {
+			if len(rule.Kinds) > 0 && !contains(rule.Kinds, workload.kind) {
+				continue
+			}
+			messages, err := rule.check(kh, workload, object)
+			if err != nil {
+				return result, err
+			}
+			if len(messages) == 0 {
+				continue
+			}
+			if contains(waivers, rule.Name) {
+				entry.Waived = append(entry.Waived, rule.Name)
+				continue
+			}
+			for _, message := range messages {
+				entry.Findings = append(entry.Findings, cm.LintFinding{Rule: rule.Name, Severity: rule.Severity, Message: message})
+			}
+		}
+
+		summary, ok := summaries[entry.Namespace]
+		if !ok {
+			summary = &cm.NamespaceLint{Namespace: entry.Namespace, Findings: make(map[string]int)}
+			summaries[entry.Namespace] = summary
+		}
+		summary.Workloads++
+		summary.Waived += len(entry.Waived)
+		if len(entry.Findings) == 0 {
+			summary.Passing++
+		}
+		for _, finding := range entry.Findings {
+			summary.Findings[finding.Rule]++
+		}
+
+		if len(entry.Findings) > 0 || len(entry.Waived) > 0 {
+			result.Workloads = append(result.Workloads, entry)
+		}
+	}
+
+	for _, summary := range summaries {
+		result.Namespaces = append(result.Namespaces, *summary)
+	}
+	sort.Slice(result.Namespaces, func(i, j int) bool { return result.Namespaces[i].Namespace < result.Namespaces[j].Namespace })
+
+	sort.Slice(result.Workloads, func(i, j int) bool {
+		a, b := result.Workloads[i], result.Workloads[j]
+		return a.Namespace+"/"+a.Kind+"/"+a.Name < b.Namespace+"/"+b.Kind+"/"+b.Name
+	})
+	start, end := pageBounds(len(result.Workloads), page, perPage)
+	result.Workloads = result.Workloads[start:end]
+
+	return result, nil
+}
+
+func (kh K8sHandler) GetLintRules() ([]cm.LintRule, error) {
+	result := []cm.LintRule{}
+
+	rules, err := loadLintRules()
+	if err != nil {
+		return result, err
+	}
+	for _, rule := range rules {
+		result = append(result, rule.LintRule)
+	}
+	return result, nil
+}
+
+func lintProbes(kh K8sHandler, workload workload, object map[string]interface{}) ([]string, error) {
+	var result []string
+	for _, container := range workload.spec.Containers {
+		var missing []string
+		if container.ReadinessProbe == nil {
+			missing = append(missing, "readiness")
+		}
+		if container.LivenessProbe == nil {
+			missing = append(missing, "liveness")
+		}
+		if len(missing) > 0 {
+			result = append(result, fmt.Sprintf("container %s has no %s probe", container.Name, strings.Join(missing, " or ")))
+		}
+	}
+	return result, nil
+}
+
+func lintDisruptionBudget(kh K8sHandler, workload workload, object map[string]interface{}) ([]string, error) {
+	if workload.replicas < 2 {
+		return nil, nil
+	}
+	budgets, err := kh.informers.Policy().V1().PodDisruptionBudgets().Lister().PodDisruptionBudgets(workload.meta.Namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, budget := range budgets {
+		selector, err := metav1.LabelSelectorAsSelector(budget.Spec.Selector)
+		if err != nil {
+			continue
+		}
+		if selector.Matches(labels.Set(workload.labels)) {
+			return nil, nil
+		}
+	}
+	return []string{fmt.Sprintf("%d replicas are not covered by a PodDisruptionBudget", workload.replicas)}, nil
+}
+
+func lintAntiAffinity(kh K8sHandler, workload workload, object map[string]interface{}) ([]string, error) {
+	if workload.replicas < 2 {
+		return nil, nil
+	}
+	if affinity := workload.spec.Affinity; affinity != nil && affinity.PodAntiAffinity != nil {
+		return nil, nil
+	}
+	if len(workload.spec.TopologySpreadConstraints) > 0 {
+		return nil, nil
+	}
+	return []string{fmt.Sprintf("%d replicas may run on the same node, there is no pod anti-affinity or topology spread constraint", workload.replicas)}, nil
+}
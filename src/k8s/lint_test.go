@@ -0,0 +1,193 @@
+package k8s
+
+import (
+	"reflect"
+	"sigs.k8s.io/yaml"
+	"strings"
+	"testing"
+)
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected []pathSegment
+		invalid  bool
+	}{
+		{path: "spec.replicas", expected: []pathSegment{{key: "spec"}, {key: "replicas"}}},
+		{path: "spec.containers[*].image", expected: []pathSegment{{key: "spec"}, {key: "containers"}, {key: "*", wildcard: true}, {key: "image"}}},
+		{path: "spec.containers[0]", expected: []pathSegment{{key: "spec"}, {key: "containers"}, {key: "0"}}},
+		{path: "metadata.labels[app.kubernetes.io/name]", expected: []pathSegment{{key: "metadata"}, {key: "labels"}, {key: "app.kubernetes.io/name"}}},
+		{path: "metadata.labels[team", invalid: true},
+		{path: "", invalid: true},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			segments, err := parsePath(test.path)
+			if test.invalid {
+				if err == nil {
+					t.Errorf("expected an error, got %v", segments)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(segments, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, segments)
+			}
+		})
+	}
+}
+
+const lintTestObject = `
+metadata:
+  labels:
+    team: payments
+    app.kubernetes.io/name: api
+spec:
+  replicas: 3
+  template:
+    spec:
+      containers:
+        - name: api
+          image: registry.example.com/api:1.2
+          resources:
+            requests:
+              memory: 256Mi
+              cpu: 500m
+        - name: sidecar
+          image: envoy:1.27
+          resources:
+            requests:
+              memory: 64Mi
+`
+
+func TestLookupPath(t *testing.T) {
+	var object map[string]interface{}
+	if err := yaml.Unmarshal([]byte(lintTestObject), &object); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path     string
+		expected []pathValue
+	}{
+		{"spec.replicas", []pathValue{{value: float64(3), found: true}}},
+		{"metadata.labels[app.kubernetes.io/name]", []pathValue{{value: "api", found: true}}},
+		{"spec.template.spec.containers[1].name", []pathValue{{value: "sidecar", found: true}}},
+		{"spec.template.spec.containers[*].name", []pathValue{{value: "api", found: true}, {value: "sidecar", found: true}}},
+		{"spec.template.spec.containers[*].resources.requests.cpu", []pathValue{{value: "500m", found: true}, {found: false}}},
+		{"spec.template.spec.containers[2].name", []pathValue{{found: false}}},
+		{"metadata.annotations", []pathValue{{found: false}}},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			path, err := parsePath(test.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if values := lookupPath(object, path); !reflect.DeepEqual(values, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, values)
+			}
+		})
+	}
+}
+
+func TestLintConditions(t *testing.T) {
+	var object map[string]interface{}
+	if err := yaml.Unmarshal([]byte(lintTestObject), &object); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		condition string
+		holds     bool
+	}{
+		{"label exists", "path: metadata.labels[team]\nexists: true", true},
+		{"label missing", "path: metadata.labels[owner]\nexists: true", false},
+		{"label absent", "path: metadata.labels[owner]\nexists: false", true},
+		{"missing value without exists", "path: metadata.labels[owner]\nequals: x", false},
+		{"equals", "path: metadata.labels[team]\nequals: payments", true},
+		{"one of", "path: metadata.labels[team]\none_of: [web, search]", false},
+		{"every image matches", "path: spec.template.spec.containers[*].image\nmatches: ^registry\\.example\\.com/", false},
+		{"any image tag", "path: spec.template.spec.containers[*].image\nmatches: ':'", true},
+		{"number min", "path: spec.replicas\nmin: 2", true},
+		{"number max", "path: spec.replicas\nmax: 2", false},
+		{"quantity min", "path: spec.template.spec.containers[*].resources.requests.memory\nmin: 64Mi", true},
+		{"quantity min of every container", "path: spec.template.spec.containers[*].resources.requests.memory\nmin: 128Mi", false},
+		{"quantity range", "path: spec.template.spec.containers[0].resources.requests.cpu\nmin: 100m\nmax: \"1\"", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rules, err := parseLintRules([]byte("rules:\n  - name: test\n    require:\n      - " + strings.ReplaceAll(test.condition, "\n", "\n        ")))
+			if err != nil {
+				t.Fatal(err)
+			}
+			violations, err := rules[0].check(K8sHandler{}, workload{}, object)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if holds := len(violations) == 0; holds != test.holds {
+				t.Errorf("expected holds %v, got violations %v", test.holds, violations)
+			}
+		})
+	}
+}
+
+func TestLintWhen(t *testing.T) {
+	var object map[string]interface{}
+	if err := yaml.Unmarshal([]byte(lintTestObject), &object); err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := parseLintRules([]byte(`
+rules:
+  - name: replicated-owner
+    message: replicated workloads need an owner
+    when:
+      - path: spec.replicas
+        min: 2
+    require:
+      - path: metadata.labels[owner]
+        exists: true
+  - name: single-owner
+    when:
+      - path: spec.replicas
+        max: 1
+    require:
+      - path: metadata.labels[owner]
+        exists: true
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	violations, _ := rules[0].check(K8sHandler{}, workload{}, object)
+	if !reflect.DeepEqual(violations, []string{"replicated workloads need an owner"}) {
+		t.Errorf("expected the rule message, got %v", violations)
+	}
+	if violations, _ := rules[1].check(K8sHandler{}, workload{}, object); len(violations) != 0 {
+		t.Errorf("expected the rule to be skipped, got %v", violations)
+	}
+}
+
+func TestParseLintRulesErrors(t *testing.T) {
+	tests := map[string]string{
+		"without name":      "rules:\n  - require:\n      - path: spec\n        exists: true",
+		"built-in name":     "rules:\n  - name: probes\n    require:\n      - path: spec\n        exists: true",
+		"invalid severity":  "rules:\n  - name: x\n    severity: urgent\n    require:\n      - path: spec\n        exists: true",
+		"invalid kind":      "rules:\n  - name: x\n    kinds: [Service]\n    require:\n      - path: spec\n        exists: true",
+		"requires nothing":  "rules:\n  - name: x",
+		"invalid regexp":    "rules:\n  - name: x\n    require:\n      - path: spec\n        matches: '('",
+		"invalid min":       "rules:\n  - name: x\n    require:\n      - path: spec\n        min: lots",
+		"unclosed bracket":  "rules:\n  - name: x\n    require:\n      - path: metadata.labels[team\n        exists: true",
+		"duplicate of rule": "rules:\n  - name: x\n    require:\n      - path: spec\n        exists: true\n  - name: x\n    require:\n      - path: spec\n        exists: true",
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseLintRules([]byte(data)); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package k8s
+
+import (
+	"context"
+	cm "github.com/royroyee/kubem/common"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"log"
+	"sort"
+	"time"
+)
+
+const (
+	namespaceUsageInterval  = 10 * time.Minute
+	namespaceUsageRetention = 90 * 24 * time.Hour
+)
+
+// Periodically store the consumption of every namespace, used for the history and chargeback
+func (kh K8sHandler) RecordNamespaceUsage() {
+
+	ticker := time.NewTicker(namespaceUsageInterval)
+	defer ticker.Stop()
+
+	for ; true; <-ticker.C {
+		pods, err := kh.podResourcesOf(metav1.NamespaceAll)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		now := time.Now()
+		var snapshots []cm.NamespaceUsageSnapshot
+		for _, usage := range aggregateNamespaces(pods) {
+			snapshot := cm.NamespaceUsageSnapshot{
+				Namespace:   usage.Namespace,
+				CpuRequests: usage.CpuRequests,
+				RamRequests: usage.RamRequests,
+				CpuLimits:   usage.CpuLimits,
+				RamLimits:   usage.RamLimits,
+				CpuUsage:    usage.CpuUsage,
+				RamUsage:    usage.RamUsage,
+				Timestamp:   now,
+			}
+			for _, count := range usage.Pods {
+				snapshot.Pods += count
+			}
+			snapshots = append(snapshots, snapshot)
+		}
+		kh.StoreNamespaceUsageInDB(snapshots)
+		kh.deleteNamespaceUsageFromDB(namespaceUsageRetention)
+	}
+}
+
+// Aggregates of every namespace, sorted by name
+func (kh K8sHandler) GetNamespaceUsages(page int, perPage int) ([]cm.NamespaceUsage, error) {
+	result := []cm.NamespaceUsage{}
+
+	pods, err := kh.podResourcesOf(metav1.NamespaceAll)
+	if err != nil {
+		return result, err
+	}
+
+	for _, usage := range aggregateNamespaces(pods) {
+		result = append(result, *usage)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Namespace < result[j].Namespace })
+
+	start, end := pageBounds(len(result), page, perPage)
+	return result[start:end], nil
+}
+
+// Aggregates of the namespace with its quotas, limit range defaults and the pods using the most cpu
+func (kh K8sHandler) GetNamespaceUsage(namespace string, top int) (cm.NamespaceUsage, error) {
+	result := cm.NamespaceUsage{Namespace: namespace, Pods: map[string]int{}}
+
+	pods, err := kh.podResourcesOf(namespace)
+	if err != nil {
+		return result, err
+	}
+	if usage, ok := aggregateNamespaces(pods)[namespace]; ok {
+		result = *usage
+	}
+
+	quotas, err := kh.K8sClient.CoreV1().ResourceQuotas(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		log.Println(err)
+		return result, err
+	}
+	for _, quota := range quotas.Items {
+		for resourceName, hard := range quota.Status.Hard {
+			used := quota.Status.Used[resourceName]
+			result.Quotas = append(result.Quotas, cm.ResourceQuotaUsage{
+				Name:     quota.Name,
+				Resource: string(resourceName),
+				Hard:     hard.String(),
+				Used:     used.String(),
+			})
+		}
+	}
+
+	limitRanges, err := kh.K8sClient.CoreV1().LimitRanges(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		log.Println(err)
+		return result, err
+	}
+	for _, limitRange := range limitRanges.Items {
+		for _, limit := range limitRange.Spec.Limits {
+			for _, resourceName := range limitRangeResources(limit) {
+				result.LimitRanges = append(result.LimitRanges, cm.LimitRangeDefault{
+					Name:           limitRange.Name,
+					Type:           string(limit.Type),
+					Resource:       string(resourceName),
+					Default:        quantityString(limit.Default, resourceName),
+					DefaultRequest: quantityString(limit.DefaultRequest, resourceName),
+					Min:            quantityString(limit.Min, resourceName),
+					Max:            quantityString(limit.Max, resourceName),
+				})
+			}
+		}
+	}
+
+	if err := sortPodResources(pods, "cpu_usage", true); err != nil {
+		return result, err
+	}
+	if top < 0 {
+		top = 0
+	}
+	if len(pods) > top {
+		pods = pods[:top]
+	}
+	result.TopConsumers = pods
+
+	return result, nil
+}
+
+// Footprint of every pod in the namespace (all namespaces if empty)
+func (kh K8sHandler) podResourcesOf(namespace string) ([]cm.PodResource, error) {
+	var result []cm.PodResource
+
+	pods, err := kh.K8sClient.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		log.Println(err)
+		return result, err
+	}
+
+	usages, err := kh.podUsages(namespace)
+	if err != nil {
+		// usage is optional, metrics-server may not be installed
+		log.Println(err)
+	}
+
+	for _, pod := range pods.Items {
+		result = append(result, podResource(&pod, usages[pod.Namespace+"/"+pod.Name]))
+	}
+	return result, nil
+}
+
+// Sum the footprint of the pods per namespace, finished pods are only counted by phase
+func aggregateNamespaces(pods []cm.PodResource) map[string]*cm.NamespaceUsage {
+	result := make(map[string]*cm.NamespaceUsage)
+
+	for _, pod := range pods {
+		usage, ok := result[pod.Namespace]
+		if !ok {
+			usage = &cm.NamespaceUsage{Namespace: pod.Namespace, Pods: map[string]int{}}
+			result[pod.Namespace] = usage
+		}
+		usage.Pods[pod.Phase]++
+
+		if pod.Phase == string(corev1.PodSucceeded) || pod.Phase == string(corev1.PodFailed) {
+			continue
+		}
+		usage.CpuRequests += pod.CpuRequests
+		usage.RamRequests += pod.RamRequests
+		usage.CpuLimits += pod.CpuLimits
+		usage.RamLimits += pod.RamLimits
+		usage.CpuUsage += pod.CpuUsage
+		usage.RamUsage += pod.RamUsage
+	}
+	return result
+}
+
+// Every resource mentioned by a limit range item
+func limitRangeResources(limit corev1.LimitRangeItem) []corev1.ResourceName {
+	var result []corev1.ResourceName
+	seen := make(map[corev1.ResourceName]bool)
+
+	for _, list := range []corev1.ResourceList{limit.Default, limit.DefaultRequest, limit.Min, limit.Max} {
+		for resourceName := range list {
+			if !seen[resourceName] {
+				seen[resourceName] = true
+				result = append(result, resourceName)
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
+
+func quantityString(list corev1.ResourceList, resourceName corev1.ResourceName) string {
+	if quantity, ok := list[resourceName]; ok {
+		return quantity.String()
+	}
+	return ""
+}
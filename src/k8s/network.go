@@ -0,0 +1,360 @@
+package k8s
+
+import (
+	"fmt"
+	cm "github.com/royroyee/kubem/common"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sort"
+)
+
+// Problems of Services and Ingresses
+const (
+	ProblemNoReadyEndpoints       = "NoReadyEndpoints"
+	ProblemSelectorMatchesNothing = "SelectorMatchesNothing"
+	ProblemMissingBackend         = "MissingBackend"
+	ProblemNoReadyBackend         = "NoReadyBackend"
+)
+
+const ingressClassAnnotation = "kubernetes.io/ingress.class"
+
+// Services of the namespace (all namespaces if empty), only those with a problem if problemsOnly is set
+func (kh K8sHandler) GetServices(namespace string, problemsOnly bool, page int, perPage int) ([]cm.ServiceOverview, error) {
+	result := []cm.ServiceOverview{}
+
+	services, err := kh.informers.Core().V1().Services().Lister().Services(namespace).List(labels.Everything())
+	if err != nil {
+		return result, err
+	}
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].Namespace+"/"+services[i].Name < services[j].Namespace+"/"+services[j].Name
+	})
+
+	for _, service := range services {
+		overview, _, err := kh.serviceOverview(service)
+		if err != nil {
+			return result, err
+		}
+		if problemsOnly && overview.Problem == "" {
+			continue
+		}
+		result = append(result, overview)
+	}
+
+	start, end := pageBounds(len(result), page, perPage)
+	return result[start:end], nil
+}
+
+func (kh K8sHandler) NumberOfServices(namespace string, problemsOnly bool) (cm.Count, error) {
+	var result cm.Count
+
+	services, err := kh.informers.Core().V1().Services().Lister().Services(namespace).List(labels.Everything())
+	if err != nil {
+		return result, err
+	}
+	if !problemsOnly {
+		result.Count = len(services)
+		return result, nil
+	}
+	for _, service := range services {
+		overview, _, err := kh.serviceOverview(service)
+		if err != nil {
+			return result, err
+		}
+		if overview.Problem != "" {
+			result.Count++
+		}
+	}
+	return result, nil
+}
+
+func (kh K8sHandler) GetServiceDetail(namespace string, name string) (cm.ServiceDetail, error) {
+	var result cm.ServiceDetail
+
+	service, err := kh.informers.Core().V1().Services().Lister().Services(namespace).Get(name)
+	if err != nil {
+		return result, err
+	}
+	overview, endpoints, err := kh.serviceOverview(service)
+	if err != nil {
+		return result, err
+	}
+	result.ServiceOverview = overview
+	result.Endpoints = endpoints
+
+	if len(service.Spec.Selector) > 0 {
+		pods, err := kh.informers.Core().V1().Pods().Lister().Pods(namespace).List(labels.SelectorFromSet(service.Spec.Selector))
+		if err != nil {
+			return result, err
+		}
+		for _, pod := range pods {
+			result.Pods = append(result.Pods, pod.Name)
+		}
+		sort.Strings(result.Pods)
+	}
+
+	ingresses, err := kh.informers.Networking().V1().Ingresses().Lister().Ingresses(namespace).List(labels.Everything())
+	if err != nil {
+		return result, err
+	}
+	for _, ingress := range ingresses {
+		for _, backend := range ingressPaths(ingress) {
+			if backend.Service == name {
+				result.Ingresses = append(result.Ingresses, ingress.Name)
+				break
+			}
+		}
+	}
+	sort.Strings(result.Ingresses)
+
+	return result, nil
+}
+
+// Overview of the service with its endpoints, from the EndpointSlices of the service
+func (kh K8sHandler) serviceOverview(service *corev1.Service) (cm.ServiceOverview, []cm.EndpointInfo, error) {
+	result := cm.ServiceOverview{
+		Namespace: service.Namespace,
+		Name:      service.Name,
+		Type:      string(service.Spec.Type),
+		ClusterIP: service.Spec.ClusterIP,
+	}
+	for _, port := range service.Spec.Ports {
+		result.Ports = append(result.Ports, fmt.Sprintf("%d/%s->%s", port.Port, port.Protocol, port.TargetPort.String()))
+	}
+	for key, value := range service.Spec.Selector {
+		result.Selector = append(result.Selector, fmt.Sprintf("%s=%s", key, value))
+	}
+	sort.Strings(result.Selector)
+
+	endpoints, err := kh.serviceEndpoints(service)
+	if err != nil {
+		return result, endpoints, err
+	}
+	for _, endpoint := range endpoints {
+		if endpoint.Ready {
+			result.ReadyEndpoints++
+		} else {
+			result.NotReadyEndpoints++
+		}
+	}
+
+	if service.Spec.Type == corev1.ServiceTypeExternalName {
+		return result, endpoints, nil
+	}
+	if len(service.Spec.Selector) > 0 {
+		pods, err := kh.informers.Core().V1().Pods().Lister().Pods(service.Namespace).List(labels.SelectorFromSet(service.Spec.Selector))
+		if err != nil {
+			return result, endpoints, err
+		}
+		if len(pods) == 0 {
+			result.Problem = ProblemSelectorMatchesNothing
+			return result, endpoints, nil
+		}
+	}
+	// a service without selector only has a problem if its endpoints are managed but not ready
+	if result.ReadyEndpoints == 0 && (len(service.Spec.Selector) > 0 || result.NotReadyEndpoints > 0) {
+		result.Problem = ProblemNoReadyEndpoints
+	}
+	return result, endpoints, nil
+}
+
+// Endpoints of the service, an endpoint present in several slices (e.g. dual stack) is reported once
+func (kh K8sHandler) serviceEndpoints(service *corev1.Service) ([]cm.EndpointInfo, error) {
+	var result []cm.EndpointInfo
+
+	selector := labels.SelectorFromSet(labels.Set{discoveryv1.LabelServiceName: service.Name})
+	slices, err := kh.informers.Discovery().V1().EndpointSlices().Lister().EndpointSlices(service.Namespace).List(selector)
+	if err != nil {
+		return result, err
+	}
+
+	index := make(map[string]int)
+	for _, slice := range slices {
+		var ports []string
+		for _, port := range slice.Ports {
+			if port.Port == nil {
+				continue
+			}
+			protocol := corev1.ProtocolTCP
+			if port.Protocol != nil {
+				protocol = *port.Protocol
+			}
+			ports = append(ports, fmt.Sprintf("%d/%s", *port.Port, protocol))
+		}
+
+		for _, endpoint := range slice.Endpoints {
+			info := cm.EndpointInfo{
+				Addresses: endpoint.Addresses,
+				// an unknown condition is interpreted as ready
+				Ready: endpoint.Conditions.Ready == nil || *endpoint.Conditions.Ready,
+				Ports: ports,
+			}
+			if endpoint.TargetRef != nil && endpoint.TargetRef.Kind == "Pod" {
+				info.Pod = endpoint.TargetRef.Name
+			}
+			if endpoint.NodeName != nil {
+				info.Node = *endpoint.NodeName
+			}
+
+			key := info.Pod
+			if key == "" && len(info.Addresses) > 0 {
+				key = info.Addresses[0]
+			}
+			if i, ok := index[key]; ok && key != "" {
+				result[i].Addresses = append(result[i].Addresses, info.Addresses...)
+				continue
+			}
+			index[key] = len(result)
+			result = append(result, info)
+		}
+	}
+	return result, nil
+}
+
+// Ingresses of the namespace (all namespaces if empty), only those with a problem if problemsOnly is set
+func (kh K8sHandler) GetIngresses(namespace string, problemsOnly bool, page int, perPage int) ([]cm.IngressOverview, error) {
+	result := []cm.IngressOverview{}
+
+	ingresses, err := kh.informers.Networking().V1().Ingresses().Lister().Ingresses(namespace).List(labels.Everything())
+	if err != nil {
+		return result, err
+	}
+	sort.Slice(ingresses, func(i, j int) bool {
+		return ingresses[i].Namespace+"/"+ingresses[i].Name < ingresses[j].Namespace+"/"+ingresses[j].Name
+	})
+
+	for _, ingress := range ingresses {
+		overview, err := kh.ingressOverview(ingress)
+		if err != nil {
+			return result, err
+		}
+		if problemsOnly && overview.Problem == "" {
+			continue
+		}
+		result = append(result, overview)
+	}
+
+	start, end := pageBounds(len(result), page, perPage)
+	return result[start:end], nil
+}
+
+func (kh K8sHandler) NumberOfIngresses(namespace string, problemsOnly bool) (cm.Count, error) {
+	var result cm.Count
+
+	ingresses, err := kh.informers.Networking().V1().Ingresses().Lister().Ingresses(namespace).List(labels.Everything())
+	if err != nil {
+		return result, err
+	}
+	if !problemsOnly {
+		result.Count = len(ingresses)
+		return result, nil
+	}
+	for _, ingress := range ingresses {
+		overview, err := kh.ingressOverview(ingress)
+		if err != nil {
+			return result, err
+		}
+		if overview.Problem != "" {
+			result.Count++
+		}
+	}
+	return result, nil
+}
+
+func (kh K8sHandler) GetIngressDetail(namespace string, name string) (cm.IngressOverview, error) {
+	ingress, err := kh.informers.Networking().V1().Ingresses().Lister().Ingresses(namespace).Get(name)
+	if err != nil {
+		return cm.IngressOverview{}, err
+	}
+	return kh.ingressOverview(ingress)
+}
+
+func (kh K8sHandler) ingressOverview(ingress *networkingv1.Ingress) (cm.IngressOverview, error) {
+	result := cm.IngressOverview{
+		Namespace: ingress.Namespace,
+		Name:      ingress.Name,
+		Class:     ingress.Annotations[ingressClassAnnotation],
+	}
+	if ingress.Spec.IngressClassName != nil {
+		result.Class = *ingress.Spec.IngressClassName
+	}
+	for _, rule := range ingress.Spec.Rules {
+		if rule.Host != "" && !contains(result.Hosts, rule.Host) {
+			result.Hosts = append(result.Hosts, rule.Host)
+		}
+	}
+	for _, address := range ingress.Status.LoadBalancer.Ingress {
+		if address.IP != "" {
+			result.Addresses = append(result.Addresses, address.IP)
+		}
+		if address.Hostname != "" {
+			result.Addresses = append(result.Addresses, address.Hostname)
+		}
+	}
+	for _, tls := range ingress.Spec.TLS {
+		if tls.SecretName != "" {
+			result.TLS = append(result.TLS, tls.SecretName)
+		}
+	}
+
+	services := kh.informers.Core().V1().Services().Lister().Services(ingress.Namespace)
+	for _, backend := range ingressPaths(ingress) {
+		if backend.Service != "" {
+			service, err := services.Get(backend.Service)
+			if err != nil {
+				backend.Problem = ProblemMissingBackend
+			} else {
+				overview, _, err := kh.serviceOverview(service)
+				if err != nil {
+					return result, err
+				}
+				if overview.Problem != "" {
+					backend.Problem = ProblemNoReadyBackend
+				}
+			}
+		}
+		// a missing backend is worse than one without ready endpoints
+		if backend.Problem == ProblemMissingBackend || result.Problem == "" {
+			result.Problem = backend.Problem
+		}
+		result.Backends = append(result.Backends, backend)
+	}
+	return result, nil
+}
+
+// Backends of the default backend and of every path
+func ingressPaths(ingress *networkingv1.Ingress) []cm.IngressPath {
+	var result []cm.IngressPath
+
+	if backend := ingress.Spec.DefaultBackend; backend != nil {
+		result = append(result, ingressBackend(cm.IngressPath{}, *backend))
+	}
+	for _, rule := range ingress.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		for _, path := range rule.HTTP.Paths {
+			ingressPath := cm.IngressPath{Host: rule.Host, Path: path.Path}
+			if path.PathType != nil {
+				ingressPath.PathType = string(*path.PathType)
+			}
+			result = append(result, ingressBackend(ingressPath, path.Backend))
+		}
+	}
+	return result
+}
+
+func ingressBackend(path cm.IngressPath, backend networkingv1.IngressBackend) cm.IngressPath {
+	if service := backend.Service; service != nil {
+		path.Service = service.Name
+		path.Port = service.Port.Name
+		if service.Port.Number != 0 {
+			path.Port = fmt.Sprintf("%d", service.Port.Number)
+		}
+	} else if resource := backend.Resource; resource != nil {
+		path.Resource = resource.Kind + "/" + resource.Name
+	}
+	return path
+}
@@ -0,0 +1,373 @@
+package k8s
+
+import (
+	"fmt"
+	cm "github.com/royroyee/kubem/common"
+	"gopkg.in/mgo.v2/bson"
+	"log"
+	"net/http"
+	"os"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Notifier configuration, e.g.
+//
+//	receivers:
+//	  - name: ops
+//	    slack:
+//	      url: https://hooks.slack.com/services/...
+//	      channel: "#ops"
+//	  - name: oncall
+//	    smtp:
+//	      host: smtp.example.com:587
+//	      from: kubem@example.com
+//	      to: [oncall@example.com]
+//	routes:
+//	  - receiver: oncall
+//	    severities: [critical]
+//	  - receiver: ops
+//	    rules: [NodeHighCpu, PodRestarting]
+//	    group_by: [rule, namespace]
+//	    repeat_interval: 1h
+var notifiersPath = cm.GetEnv("KUBEM_NOTIFIERS", "notifiers.yaml")
+
+const (
+	notifyInterval        = 10 * time.Second
+	defaultGroupWait      = 30 * time.Second
+	defaultRepeatInterval = 4 * time.Hour
+	notifyAttempts        = 4
+	notifyBackoff         = 2 * time.Second
+
+	NotificationPending = "pending"
+	NotificationSent    = "sent"
+	NotificationFailed  = "failed"
+)
+
+type receiverConfig struct {
+	Name    string         `json:"name"`
+	Webhook *webhookConfig `json:"webhook,omitempty"`
+	Slack   *slackConfig   `json:"slack,omitempty"`
+	Smtp    *smtpConfig    `json:"smtp,omitempty"`
+}
+
+// Alerts matching every non empty field are sent to the receiver
+type routeConfig struct {
+	Receiver       string   `json:"receiver"`
+	Rules          []string `json:"rules,omitempty"`
+	Severities     []string `json:"severities,omitempty"`
+	Namespaces     []string `json:"namespaces,omitempty"`
+	GroupBy        []string `json:"group_by,omitempty"` // rule, severity, namespace, subject
+	GroupWait      string   `json:"group_wait,omitempty"`
+	RepeatInterval string   `json:"repeat_interval,omitempty"`
+
+	groupWait      time.Duration
+	repeatInterval time.Duration
+}
+
+type notifierConfig struct {
+	Receivers []receiverConfig `json:"receivers"`
+	Routes    []routeConfig    `json:"routes"`
+}
+
+// Alerts of a route waiting to be (re)sent together
+type notificationGroup struct {
+	route    int
+	key      string
+	alerts   map[string]cm.Alert
+	changed  time.Time // first change not sent yet, zero if none
+	lastSent time.Time
+}
+
+// State of the notifications, shared by every copy of the K8sHandler
+type Notifier struct {
+	mutex      sync.Mutex
+	routes     []routeConfig
+	sinks      map[string]notificationSink
+	modTime    time.Time
+	groups     map[string]*notificationGroup
+	httpClient *http.Client
+}
+
+func NewNotifier() *Notifier {
+	return &Notifier{
+		sinks:      make(map[string]notificationSink),
+		groups:     make(map[string]*notificationGroup),
+		httpClient: &http.Client{Timeout: sinkTimeout},
+	}
+}
+
+func (notifier *Notifier) load(data []byte) error {
+	var config notifierConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("Failed to parse notifiers: %v", err)
+	}
+
+	sinks := make(map[string]notificationSink)
+	for _, receiver := range config.Receivers {
+		switch {
+		case receiver.Webhook != nil:
+			sinks[receiver.Name] = webhookSink{config: *receiver.Webhook, client: notifier.httpClient}
+		case receiver.Slack != nil:
+			sinks[receiver.Name] = slackSink{config: *receiver.Slack, client: notifier.httpClient}
+		case receiver.Smtp != nil:
+			sinks[receiver.Name] = smtpSink{config: *receiver.Smtp}
+		default:
+			return fmt.Errorf("Receiver %v has no webhook, slack or smtp", receiver.Name)
+		}
+	}
+
+	for i := range config.Routes {
+		route := &config.Routes[i]
+		if _, ok := sinks[route.Receiver]; !ok {
+			return fmt.Errorf("Unknown receiver %v", route.Receiver)
+		}
+		if len(route.GroupBy) == 0 {
+			route.GroupBy = []string{"rule"}
+		}
+		route.groupWait = defaultGroupWait
+		if route.GroupWait != "" {
+			duration, err := time.ParseDuration(route.GroupWait)
+			if err != nil {
+				return fmt.Errorf("Invalid group_wait of route to %v: %v", route.Receiver, err)
+			}
+			route.groupWait = duration
+		}
+		route.repeatInterval = defaultRepeatInterval
+		if route.RepeatInterval != "" {
+			duration, err := time.ParseDuration(route.RepeatInterval)
+			if err != nil {
+				return fmt.Errorf("Invalid repeat_interval of route to %v: %v", route.Receiver, err)
+			}
+			route.repeatInterval = duration
+		}
+	}
+
+	notifier.mutex.Lock()
+	defer notifier.mutex.Unlock()
+
+	notifier.sinks = sinks
+	notifier.routes = config.Routes
+	// groups refer to routes by index
+	notifier.groups = make(map[string]*notificationGroup)
+	log.Printf("Loaded %d receivers and %d routes", len(sinks), len(config.Routes))
+	return nil
+}
+
+func (route routeConfig) matches(alert cm.Alert) bool {
+	return matchesAny(route.Rules, alert.Rule) &&
+		matchesAny(route.Severities, alert.Severity) &&
+		matchesAny(route.Namespaces, alert.Namespace)
+}
+
+// An empty list matches everything
+func matchesAny(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (route routeConfig) groupKey(alert cm.Alert) string {
+	var parts []string
+	for _, field := range route.GroupBy {
+		switch field {
+		case "rule":
+			parts = append(parts, alert.Rule)
+		case "severity":
+			parts = append(parts, alert.Severity)
+		case "namespace":
+			parts = append(parts, alert.Namespace)
+		case "subject":
+			parts = append(parts, alert.Subject)
+		default:
+			parts = append(parts, alert.Labels[field])
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// Queue a firing or resolved alert on every matching route.
+// A resolved alert is only queued where it was queued firing, not when its firing was silenced
+func (notifier *Notifier) notify(alert cm.Alert, now time.Time) {
+	notifier.mutex.Lock()
+	defer notifier.mutex.Unlock()
+
+	alertKey := alert.Rule + "/" + alert.Namespace + "/" + alert.Subject
+	for i, route := range notifier.routes {
+		if !route.matches(alert) {
+			continue
+		}
+
+		key := fmt.Sprintf("%d/%s", i, route.groupKey(alert))
+		group, ok := notifier.groups[key]
+		if alert.State != AlertFiring {
+			if !ok {
+				continue
+			}
+			if _, queued := group.alerts[alertKey]; !queued {
+				continue
+			}
+		}
+		if !ok {
+			group = &notificationGroup{route: i, key: route.groupKey(alert), alerts: make(map[string]cm.Alert)}
+			notifier.groups[key] = group
+		}
+		group.alerts[alertKey] = alert
+		if group.changed.IsZero() {
+			group.changed = now
+		}
+	}
+}
+
+// Groups which are due, either after their group wait or their repeat interval
+func (notifier *Notifier) due(now time.Time) []cm.Notification {
+	notifier.mutex.Lock()
+	defer notifier.mutex.Unlock()
+
+	var result []cm.Notification
+	for key, group := range notifier.groups {
+		route := notifier.routes[group.route]
+
+		firing := false
+		for _, alert := range group.alerts {
+			if alert.State == AlertFiring {
+				firing = true
+			}
+		}
+
+		newChanges := !group.changed.IsZero() && now.Sub(group.changed) >= route.groupWait
+		repeat := firing && !group.lastSent.IsZero() && now.Sub(group.lastSent) >= route.repeatInterval
+		if !newChanges && !repeat {
+			continue
+		}
+
+		notification := cm.Notification{
+			Id:       bson.NewObjectId().Hex(),
+			Receiver: route.Receiver,
+			Group:    group.key,
+			Status:   NotificationPending,
+			Created:  now,
+		}
+		for alertKey, alert := range group.alerts {
+			notification.Alerts = append(notification.Alerts, alert)
+			// resolved alerts are only sent once
+			if alert.State != AlertFiring {
+				delete(group.alerts, alertKey)
+			}
+		}
+		sort.Slice(notification.Alerts, func(i, j int) bool {
+			return notification.Alerts[i].ActiveSince.Before(notification.Alerts[j].ActiveSince)
+		})
+		result = append(result, notification)
+
+		group.changed = time.Time{}
+		group.lastSent = now
+		if len(group.alerts) == 0 {
+			delete(notifier.groups, key)
+		}
+	}
+	return result
+}
+
+func (notifier *Notifier) sink(receiver string) (notificationSink, bool) {
+	notifier.mutex.Lock()
+	defer notifier.mutex.Unlock()
+
+	sink, ok := notifier.sinks[receiver]
+	return sink, ok
+}
+
+// Send the due notifications periodically, the configuration is reloaded whenever it changes
+func (kh K8sHandler) SendNotifications() {
+
+	ticker := time.NewTicker(notifyInterval)
+	defer ticker.Stop()
+
+	for ; true; <-ticker.C {
+		info, err := os.Stat(notifiersPath)
+		if err == nil && !info.ModTime().Equal(kh.notifier.modTime) {
+			kh.notifier.modTime = info.ModTime()
+			data, err := os.ReadFile(notifiersPath)
+			if err == nil {
+				err = kh.notifier.load(data)
+			}
+			if err != nil {
+				log.Println(err)
+			}
+		}
+
+		now := time.Now()
+		// better notify too much than nothing if the silences cannot be read
+		silences, _ := kh.GetActiveSilences(now)
+		for _, notification := range kh.notifier.due(now) {
+			notification.Alerts = unsilencedAlerts(notification.Alerts, silences, now)
+			if len(notification.Alerts) == 0 {
+				continue
+			}
+
+			kh.StoreNotificationInDB(notification)
+			go kh.deliver(notification)
+		}
+	}
+}
+
+// Alerts which are not silenced, they may have been silenced since they fired or resolved while silenced
+func unsilencedAlerts(alerts []cm.Alert, silences []cm.Silence, now time.Time) []cm.Alert {
+	var result []cm.Alert
+	for _, alert := range alerts {
+		if matchingSilence(silences, alert, now) == "" {
+			result = append(result, alert)
+		}
+	}
+	return result
+}
+
+// Send a notification, retrying with exponential backoff, and record its delivery status
+func (kh K8sHandler) deliver(notification cm.Notification) {
+	sink, ok := kh.notifier.sink(notification.Receiver)
+	if !ok {
+		notification.Status = NotificationFailed
+		notification.Error = fmt.Sprintf("Unknown receiver %v", notification.Receiver)
+		kh.UpdateNotificationInDB(notification)
+		return
+	}
+
+	sendWithBackoff(sink, notification, notifyBackoff, kh.UpdateNotificationInDB)
+}
+
+// Every attempt is passed to update, the backoff doubles after each failure
+func sendWithBackoff(sink notificationSink, notification cm.Notification, backoff time.Duration, update func(cm.Notification)) cm.Notification {
+	for notification.Attempts < notifyAttempts {
+		notification.Attempts++
+		err := sink.send(notification)
+		if err == nil {
+			notification.Status = NotificationSent
+			notification.Error = ""
+			notification.Delivered = time.Now()
+			update(notification)
+			return notification
+		}
+
+		log.Printf("Failed to send notification %s to %s (attempt %d): %v", notification.Id, notification.Receiver, notification.Attempts, err)
+		notification.Error = err.Error()
+		update(notification)
+
+		if notification.Attempts < notifyAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+
+	notification.Status = NotificationFailed
+	update(notification)
+	return notification
+}
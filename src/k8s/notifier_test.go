@@ -0,0 +1,336 @@
+package k8s
+
+import (
+	"bufio"
+	"encoding/json"
+	cm "github.com/royroyee/kubem/common"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Local stand-in of a webhook receiver, failing the first requests
+type webhookServer struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	failures int
+	payloads []map[string]interface{}
+	times    []time.Time
+}
+
+func newWebhookServer(failures int) *webhookServer {
+	server := &webhookServer{failures: failures}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.mutex.Lock()
+		defer server.mutex.Unlock()
+
+		server.times = append(server.times, time.Now())
+		if server.failures > 0 {
+			server.failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var payload map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		server.payloads = append(server.payloads, payload)
+	}))
+	return server
+}
+
+// Minimal SMTP server accepting a single message
+func newSMTPServer(t *testing.T) (string, chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	messages := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+		reply("220 localhost ESMTP")
+
+		var data []string
+		inData := false
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			if inData {
+				if line == "." {
+					inData = false
+					messages <- strings.Join(data, "\n")
+					reply("250 OK")
+				} else {
+					data = append(data, line)
+				}
+				continue
+			}
+			switch command := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); command {
+			case "EHLO", "HELO":
+				reply("250 localhost")
+			case "DATA":
+				inData = true
+				reply("354 End data with <CR><LF>.<CR><LF>")
+			case "QUIT":
+				reply("221 Bye")
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+	return listener.Addr().String(), messages
+}
+
+func testNotifier(t *testing.T, config string) *Notifier {
+	notifier := NewNotifier()
+	if err := notifier.load([]byte(config)); err != nil {
+		t.Fatal(err)
+	}
+	return notifier
+}
+
+func testAlert(rule string, namespace string, subject string, state string, since time.Time) cm.Alert {
+	return cm.Alert{Rule: rule, Severity: "warning", Namespace: namespace, Subject: subject, State: state, ActiveSince: since}
+}
+
+func TestNotifierGroupsPerRoute(t *testing.T) {
+	notifier := testNotifier(t, `
+receivers:
+  - name: ops
+    webhook:
+      url: http://localhost
+  - name: oncall
+    webhook:
+      url: http://localhost
+routes:
+  - receiver: ops
+    group_by: [rule, namespace]
+    group_wait: 30s
+  - receiver: oncall
+    severities: [critical]
+`)
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	notifier.notify(testAlert("PodRestarting", "web", "api-1", AlertFiring, now), now)
+	notifier.notify(testAlert("PodRestarting", "web", "api-2", AlertFiring, now), now)
+	notifier.notify(testAlert("PodRestarting", "batch", "job-1", AlertFiring, now), now)
+	notifier.notify(testAlert("NodeNotReady", "", "node-1", AlertFiring, now), now)
+
+	if notifications := notifier.due(now.Add(10 * time.Second)); len(notifications) != 0 {
+		t.Fatalf("expected nothing before the group wait, got %d notifications", len(notifications))
+	}
+
+	notifications := notifier.due(now.Add(30 * time.Second))
+	groups := map[string]int{}
+	for _, notification := range notifications {
+		if notification.Receiver != "ops" {
+			t.Errorf("unexpected receiver %v", notification.Receiver)
+		}
+		groups[notification.Group] = len(notification.Alerts)
+	}
+	expected := map[string]int{"PodRestarting/web": 2, "PodRestarting/batch": 1, "NodeNotReady/": 1}
+	if len(groups) != len(expected) {
+		t.Fatalf("expected groups %v, got %v", expected, groups)
+	}
+	for group, count := range expected {
+		if groups[group] != count {
+			t.Errorf("group %v: expected %d alerts, got %d", group, count, groups[group])
+		}
+	}
+}
+
+func TestNotifierRepeatInterval(t *testing.T) {
+	notifier := testNotifier(t, `
+receivers:
+  - name: ops
+    webhook:
+      url: http://localhost
+routes:
+  - receiver: ops
+    group_wait: 0s
+    repeat_interval: 1h
+`)
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	notifier.notify(testAlert("NodeHighCpu", "", "node-1", AlertFiring, now), now)
+
+	if notifications := notifier.due(now); len(notifications) != 1 {
+		t.Fatalf("expected the first notification, got %d", len(notifications))
+	}
+	if notifications := notifier.due(now.Add(59 * time.Minute)); len(notifications) != 0 {
+		t.Fatalf("expected no repeat within the interval, got %d", len(notifications))
+	}
+	if notifications := notifier.due(now.Add(time.Hour)); len(notifications) != 1 {
+		t.Fatalf("expected a repeat after the interval, got %d", len(notifications))
+	}
+
+	// resolved alerts are sent once and not repeated
+	resolved := now.Add(90 * time.Minute)
+	notifier.notify(testAlert("NodeHighCpu", "", "node-1", AlertResolved, now), resolved)
+	notifications := notifier.due(resolved)
+	if len(notifications) != 1 || notificationStatus(notifications[0]) != AlertResolved {
+		t.Fatalf("expected one resolved notification, got %v", notifications)
+	}
+	if notifications := notifier.due(resolved.Add(2 * time.Hour)); len(notifications) != 0 {
+		t.Fatalf("expected no repeat of a resolved alert, got %d", len(notifications))
+	}
+}
+
+func TestResolvedAfterSilencedFiring(t *testing.T) {
+	notifier := testNotifier(t, `
+receivers:
+  - name: ops
+    webhook:
+      url: http://localhost
+routes:
+  - receiver: ops
+    group_wait: 0s
+`)
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// the firing transition was silenced, so it never reached the notifier
+	notifier.notify(testAlert("NodeHighCpu", "", "node-1", AlertResolved, now), now)
+	if notifications := notifier.due(now); len(notifications) != 0 {
+		t.Fatalf("expected no resolved notification without a firing one, got %v", notifications)
+	}
+
+	notifier.notify(testAlert("NodeHighCpu", "", "node-2", AlertFiring, now), now)
+	notifier.due(now)
+	notifier.notify(testAlert("NodeHighCpu", "", "node-2", AlertResolved, now), now)
+	if notifications := notifier.due(now); len(notifications) != 1 || notificationStatus(notifications[0]) != AlertResolved {
+		t.Fatalf("expected one resolved notification, got %v", notifications)
+	}
+}
+
+func TestSendWithBackoff(t *testing.T) {
+	server := newWebhookServer(2)
+	defer server.Close()
+
+	sink := webhookSink{config: webhookConfig{URL: server.URL}, client: server.Client()}
+	notification := cm.Notification{Receiver: "ops", Group: "NodeHighCpu", Status: NotificationPending,
+		Alerts: []cm.Alert{testAlert("NodeHighCpu", "", "node-1", AlertFiring, time.Now())}}
+
+	var updates []cm.Notification
+	backoff := 20 * time.Millisecond
+	result := sendWithBackoff(sink, notification, backoff, func(n cm.Notification) { updates = append(updates, n) })
+
+	if result.Status != NotificationSent || result.Attempts != 3 || result.Error != "" {
+		t.Fatalf("expected sent after 3 attempts, got %v after %d (%v)", result.Status, result.Attempts, result.Error)
+	}
+	if len(updates) != 3 || updates[0].Error == "" {
+		t.Errorf("expected every attempt to be recorded with its error, got %v", updates)
+	}
+	if gap := server.times[1].Sub(server.times[0]); gap < backoff {
+		t.Errorf("expected a backoff of at least %v, got %v", backoff, gap)
+	}
+	if gap := server.times[2].Sub(server.times[1]); gap < 2*backoff {
+		t.Errorf("expected the backoff to double to %v, got %v", 2*backoff, gap)
+	}
+
+	failing := newWebhookServer(notifyAttempts)
+	defer failing.Close()
+	sink = webhookSink{config: webhookConfig{URL: failing.URL}, client: failing.Client()}
+	result = sendWithBackoff(sink, notification, time.Millisecond, func(cm.Notification) {})
+	if result.Status != NotificationFailed || result.Attempts != notifyAttempts || result.Error == "" {
+		t.Errorf("expected failed after %d attempts, got %v after %d", notifyAttempts, result.Status, result.Attempts)
+	}
+}
+
+func TestResolvedMessage(t *testing.T) {
+	now := time.Now()
+	notification := cm.Notification{Receiver: "ops", Group: "PodRestarting/web",
+		Alerts: []cm.Alert{testAlert("PodRestarting", "web", "api-1", AlertResolved, now)}}
+	notification.Alerts[0].Message = "Pod api-1 restarted 6 times in 10m0s"
+
+	webhook := newWebhookServer(0)
+	defer webhook.Close()
+	if err := (webhookSink{config: webhookConfig{URL: webhook.URL}, client: webhook.Client()}).send(notification); err != nil {
+		t.Fatal(err)
+	}
+	if status := webhook.payloads[0]["status"]; status != AlertResolved {
+		t.Errorf("expected webhook status resolved, got %v", status)
+	}
+
+	slack := newWebhookServer(0)
+	defer slack.Close()
+	if err := (slackSink{config: slackConfig{URL: slack.URL, Channel: "#ops"}, client: slack.Client()}).send(notification); err != nil {
+		t.Fatal(err)
+	}
+	text, _ := slack.payloads[0]["text"].(string)
+	if !strings.HasPrefix(text, "[RESOLVED:0] PodRestarting/web\n[RESOLVED] PodRestarting web/api-1") {
+		t.Errorf("unexpected slack text %q", text)
+	}
+	if channel := slack.payloads[0]["channel"]; channel != "#ops" {
+		t.Errorf("expected channel #ops, got %v", channel)
+	}
+
+	host, messages := newSMTPServer(t)
+	sink := smtpSink{config: smtpConfig{Host: host, From: "kubem@example.com", To: []string{"oncall@example.com"}}}
+	if err := sink.send(notification); err != nil {
+		t.Fatal(err)
+	}
+	message := <-messages
+	if !strings.Contains(message, "Subject: [RESOLVED:0] PodRestarting/web") || !strings.Contains(message, "restarted 6 times") {
+		t.Errorf("unexpected mail %q", message)
+	}
+}
+
+func TestResolvedWhileSilenced(t *testing.T) {
+	notifier := testNotifier(t, `
+receivers:
+  - name: ops
+    webhook:
+      url: http://localhost
+routes:
+  - receiver: ops
+    group_wait: 0s
+    repeat_interval: 1h
+`)
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	alert := testAlert("NodeHighCpu", "", "node-1", AlertFiring, now)
+
+	notifier.notify(alert, now)
+	if notifications := notifier.due(now); len(notifications) != 1 {
+		t.Fatalf("expected the firing notification, got %d", len(notifications))
+	}
+
+	// silenced after it fired, the repeats are dropped when sent
+	silences := []cm.Silence{{Id: "maintenance", Matchers: []cm.Matcher{{Name: "subject", Value: "node-1"}}}}
+	repeat := now.Add(time.Hour)
+	notifications := notifier.due(repeat)
+	if len(notifications) != 1 || len(unsilencedAlerts(notifications[0].Alerts, silences, repeat)) != 0 {
+		t.Fatalf("expected a silenced repeat, got %v", notifications)
+	}
+
+	// resolved while silenced, the resolved copy replaces the firing one and is dropped too
+	resolved := repeat.Add(10 * time.Minute)
+	alert.State = AlertResolved
+	notifier.notify(alert, resolved)
+	notifications = notifier.due(resolved)
+	if len(notifications) != 1 || notificationStatus(notifications[0]) != AlertResolved {
+		t.Fatalf("expected one resolved notification, got %v", notifications)
+	}
+	if alerts := unsilencedAlerts(notifications[0].Alerts, silences, resolved); len(alerts) != 0 {
+		t.Errorf("expected the resolved alert to be silenced, got %v", alerts)
+	}
+
+	// once the silence expired, the resolved alert is not repeated
+	expired := resolved.Add(3 * time.Hour)
+	if notifications := notifier.due(expired); len(notifications) != 0 {
+		t.Errorf("expected no notification after the silence expired, got %v", notifications)
+	}
+}
@@ -0,0 +1,347 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	cm "github.com/royroyee/kubem/common"
+	"gopkg.in/mgo.v2/bson"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/client-go/kubernetes"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const restartedAtAnnotation = "kubectl.kubernetes.io/restartedAt"
+
+// Actions of the audit records
+const (
+	ActionRestart  = "restart"
+	ActionScale    = "scale"
+	ActionRollback = "rollback"
+	ActionSuspend  = "suspend"
+	ActionResume   = "resume"
+	ActionDelete   = "delete"
+	ActionDebug    = "debug"
+)
+
+const (
+	DefaultDebugImage    = "busybox:1.36"
+	debugContainerPrefix = "debugger-"
+)
+
+// Images allowed for debug containers, comma separated
+var debugImages = strings.Split(cm.GetEnv("KUBEM_DEBUG_IMAGES", DefaultDebugImage), ",")
+
+// Server side dry run, the API server validates and admits the change without persisting it
+func dryRunOption(dryRun bool) []string {
+	if dryRun {
+		return []string{metav1.DryRunAll}
+	}
+	return nil
+}
+
+// Store the record of the operation, also if it failed, and return the error of the operation
+func (kh K8sHandler) audit(record cm.AuditRecord, err error) (cm.AuditRecord, error) {
+	record.Id = bson.NewObjectId().Hex()
+	record.Timestamp = time.Now()
+	if err != nil {
+		record.Error = err.Error()
+	}
+	if storeErr := kh.StoreAuditRecordInDB(record); storeErr != nil && err == nil {
+		return record, fmt.Errorf("Operation is done but could not be audited: %v", storeErr)
+	}
+	return record, err
+}
+
+// Rollout restart, which replaces the pods by changing the restartedAt annotation of the pod template like kubectl does.
+// The controller types are those of GetControllerInfo
+func (kh K8sHandler) RestartWorkload(user string, controllerType string, namespace string, name string, dryRun bool) (cm.AuditRecord, error) {
+	record := cm.AuditRecord{User: user, Action: ActionRestart, Namespace: namespace, Name: name, DryRun: dryRun}
+	ctx := context.TODO()
+
+	patch := []byte(fmt.Sprintf(`{"spec":{"template":{"metadata":{"annotations":{%q:%q}}}}}`,
+		restartedAtAnnotation, time.Now().Format(time.RFC3339)))
+	options := metav1.PatchOptions{DryRun: dryRunOption(dryRun)}
+
+	switch controllerType {
+	case "deployment":
+		record.Kind = "Deployment"
+		deployment, err := kh.K8sClient.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return kh.audit(record, err)
+		}
+		record.Before = map[string]string{"restarted_at": deployment.Spec.Template.Annotations[restartedAtAnnotation]}
+		deployment, err = kh.K8sClient.AppsV1().Deployments(namespace).Patch(ctx, name, types.StrategicMergePatchType, patch, options)
+		if err != nil {
+			return kh.audit(record, err)
+		}
+		record.After = map[string]string{"restarted_at": deployment.Spec.Template.Annotations[restartedAtAnnotation]}
+
+	// the read endpoints spell it staefulset
+	case "statefulset", "staefulset":
+		record.Kind = "StatefulSet"
+		statefulSet, err := kh.K8sClient.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return kh.audit(record, err)
+		}
+		record.Before = map[string]string{"restarted_at": statefulSet.Spec.Template.Annotations[restartedAtAnnotation]}
+		statefulSet, err = kh.K8sClient.AppsV1().StatefulSets(namespace).Patch(ctx, name, types.StrategicMergePatchType, patch, options)
+		if err != nil {
+			return kh.audit(record, err)
+		}
+		record.After = map[string]string{"restarted_at": statefulSet.Spec.Template.Annotations[restartedAtAnnotation]}
+
+	case "daemonset":
+		record.Kind = "DaemonSet"
+		daemonSet, err := kh.K8sClient.AppsV1().DaemonSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return kh.audit(record, err)
+		}
+		record.Before = map[string]string{"restarted_at": daemonSet.Spec.Template.Annotations[restartedAtAnnotation]}
+		daemonSet, err = kh.K8sClient.AppsV1().DaemonSets(namespace).Patch(ctx, name, types.StrategicMergePatchType, patch, options)
+		if err != nil {
+			return kh.audit(record, err)
+		}
+		record.After = map[string]string{"restarted_at": daemonSet.Spec.Template.Annotations[restartedAtAnnotation]}
+
+	default:
+		return kh.audit(record, fmt.Errorf("Cannot restart a workload of type %v", controllerType))
+	}
+
+	return kh.audit(record, nil)
+}
+
+// Set the replicas through the scale subresource
+func (kh K8sHandler) ScaleWorkload(user string, controllerType string, namespace string, name string, replicas int32, dryRun bool) (cm.AuditRecord, error) {
+	record := cm.AuditRecord{User: user, Action: ActionScale, Namespace: namespace, Name: name, DryRun: dryRun}
+	ctx := context.TODO()
+
+	if replicas < 0 {
+		return kh.audit(record, fmt.Errorf("Invalid replicas %d", replicas))
+	}
+	options := metav1.UpdateOptions{DryRun: dryRunOption(dryRun)}
+
+	switch controllerType {
+	case "deployment":
+		record.Kind = "Deployment"
+		scale, err := kh.K8sClient.AppsV1().Deployments(namespace).GetScale(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return kh.audit(record, err)
+		}
+		record.Before = map[string]string{"replicas": strconv.Itoa(int(scale.Spec.Replicas))}
+		scale.Spec.Replicas = replicas
+		scale, err = kh.K8sClient.AppsV1().Deployments(namespace).UpdateScale(ctx, name, scale, options)
+		if err != nil {
+			return kh.audit(record, err)
+		}
+		record.After = map[string]string{"replicas": strconv.Itoa(int(scale.Spec.Replicas))}
+
+	case "statefulset", "staefulset":
+		record.Kind = "StatefulSet"
+		scale, err := kh.K8sClient.AppsV1().StatefulSets(namespace).GetScale(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return kh.audit(record, err)
+		}
+		record.Before = map[string]string{"replicas": strconv.Itoa(int(scale.Spec.Replicas))}
+		scale.Spec.Replicas = replicas
+		scale, err = kh.K8sClient.AppsV1().StatefulSets(namespace).UpdateScale(ctx, name, scale, options)
+		if err != nil {
+			return kh.audit(record, err)
+		}
+		record.After = map[string]string{"replicas": strconv.Itoa(int(scale.Spec.Replicas))}
+
+	default:
+		return kh.audit(record, fmt.Errorf("Cannot scale a workload of type %v", controllerType))
+	}
+
+	return kh.audit(record, nil)
+}
+
+// Roll a Deployment back to the pod template of one of its revisions, as kubectl rollout undo does
+func (kh K8sHandler) RollbackDeployment(user string, namespace string, name string, revision string, dryRun bool) (cm.AuditRecord, error) {
+	record := cm.AuditRecord{User: user, Action: ActionRollback, Kind: "Deployment", Namespace: namespace, Name: name, DryRun: dryRun}
+	ctx := context.TODO()
+
+	deployment, err := kh.K8sClient.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return kh.audit(record, err)
+	}
+	current := deployment.Annotations[revisionAnnotation]
+	record.Before = map[string]string{
+		"revision": current,
+		"images":   strings.Join(templateImages(deployment.Spec.Template), ","),
+	}
+	if revision == current {
+		return kh.audit(record, fmt.Errorf("Deployment %v/%v is already at revision %v", namespace, name, revision))
+	}
+
+	replicaSet, err := kh.replicaSetOfRevision(deployment, revision)
+	if err != nil {
+		return kh.audit(record, err)
+	}
+	template := replicaSet.Spec.Template.DeepCopy()
+	delete(template.Labels, podTemplateHashLabel)
+	deployment.Spec.Template = *template
+
+	deployment, err = kh.K8sClient.AppsV1().Deployments(namespace).Update(ctx, deployment, metav1.UpdateOptions{DryRun: dryRunOption(dryRun)})
+	if err != nil {
+		return kh.audit(record, err)
+	}
+	// the template of the revision is rolled out again as a new revision
+	record.After = map[string]string{
+		"revision": revision,
+		"images":   strings.Join(templateImages(deployment.Spec.Template), ","),
+	}
+
+	return kh.audit(record, nil)
+}
+
+// Suspend or resume the scheduling of a CronJob, running jobs are not affected
+func (kh K8sHandler) SuspendCronJob(user string, namespace string, name string, suspend bool, dryRun bool) (cm.AuditRecord, error) {
+	record := cm.AuditRecord{User: user, Action: ActionResume, Kind: "CronJob", Namespace: namespace, Name: name, DryRun: dryRun}
+	if suspend {
+		record.Action = ActionSuspend
+	}
+	ctx := context.TODO()
+
+	cronJob, err := kh.K8sClient.BatchV1().CronJobs(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return kh.audit(record, err)
+	}
+	record.Before = map[string]string{"suspend": strconv.FormatBool(cronJob.Spec.Suspend != nil && *cronJob.Spec.Suspend)}
+
+	patch := []byte(fmt.Sprintf(`{"spec":{"suspend":%t}}`, suspend))
+	cronJob, err = kh.K8sClient.BatchV1().CronJobs(namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{DryRun: dryRunOption(dryRun)})
+	if err != nil {
+		return kh.audit(record, err)
+	}
+	record.After = map[string]string{"suspend": strconv.FormatBool(cronJob.Spec.Suspend != nil && *cronJob.Spec.Suspend)}
+
+	return kh.audit(record, nil)
+}
+
+// Delete a pod, e.g. a stuck one. A negative grace period keeps the grace period of the pod
+func (kh K8sHandler) DeletePod(user string, namespace string, name string, gracePeriod int64, dryRun bool) (cm.AuditRecord, error) {
+	record := cm.AuditRecord{User: user, Action: ActionDelete, Kind: "Pod", Namespace: namespace, Name: name, DryRun: dryRun}
+
+	before, after, err := deletePod(kh.K8sClient, namespace, name, gracePeriod, dryRun)
+	record.Before = before
+	record.After = after
+	return kh.audit(record, err)
+}
+
+// Add an ephemeral container running the image to the pod, sharing the process namespace of the target container
+// if not empty. The image must be one of KUBEM_DEBUG_IMAGES, the first one if empty.
+// The name of the container is in the audit record, to attach to it
+func (kh K8sHandler) DebugPod(user string, namespace string, name string, image string, target string, dryRun bool) (cm.AuditRecord, error) {
+	record := cm.AuditRecord{User: user, Action: ActionDebug, Kind: "Pod", Namespace: namespace, Name: name, DryRun: dryRun}
+
+	before, after, err := addDebugContainer(kh.K8sClient, namespace, name, image, target, dryRun)
+	record.Before = before
+	record.After = after
+	return kh.audit(record, err)
+}
+
+// The pod actions only need a client, and return the state before and after for the audit record
+func deletePod(client kubernetes.Interface, namespace string, name string, gracePeriod int64, dryRun bool) (map[string]string, map[string]string, error) {
+	ctx := context.TODO()
+
+	pod, err := client.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	before := map[string]string{"uid": string(pod.UID), "phase": string(pod.Status.Phase), "node": pod.Spec.NodeName}
+
+	options := metav1.DeleteOptions{
+		DryRun: dryRunOption(dryRun),
+		// do not delete a pod recreated with the same name in the meantime
+		Preconditions: metav1.NewUIDPreconditions(string(pod.UID)),
+	}
+	if gracePeriod >= 0 {
+		options.GracePeriodSeconds = &gracePeriod
+	}
+	if err := client.CoreV1().Pods(namespace).Delete(ctx, name, options); err != nil {
+		return before, nil, err
+	}
+
+	after := map[string]string{"status": "deleted"}
+	current, err := client.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+	switch {
+	case err == nil && current.UID == pod.UID && !dryRun:
+		after["status"] = "terminating"
+	case err != nil && !apierrors.IsNotFound(err):
+		after["status"] = "unknown"
+	}
+	if gracePeriod >= 0 {
+		after["grace_period"] = strconv.FormatInt(gracePeriod, 10)
+	}
+	return before, after, nil
+}
+
+func addDebugContainer(client kubernetes.Interface, namespace string, name string, image string, target string, dryRun bool) (map[string]string, map[string]string, error) {
+	ctx := context.TODO()
+
+	if image == "" {
+		image = strings.TrimSpace(debugImages[0])
+	}
+	allowed := false
+	for _, debugImage := range debugImages {
+		allowed = allowed || strings.TrimSpace(debugImage) == image
+	}
+	if !allowed {
+		return nil, nil, fmt.Errorf("Image %v is not allowed for debug containers", image)
+	}
+
+	pod, err := client.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	if target != "" {
+		found := false
+		for _, container := range pod.Spec.Containers {
+			found = found || container.Name == target
+		}
+		if !found {
+			return nil, nil, fmt.Errorf("Pod %v/%v has no container %v", namespace, name, target)
+		}
+	}
+	before := map[string]string{"ephemeral_containers": ephemeralContainerNames(pod)}
+
+	container := corev1.EphemeralContainer{
+		EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+			Name:                     debugContainerPrefix + utilrand.String(5),
+			Image:                    image,
+			ImagePullPolicy:          corev1.PullIfNotPresent,
+			Stdin:                    true,
+			TTY:                      true,
+			TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+		},
+		TargetContainerName: target,
+	}
+	pod.Spec.EphemeralContainers = append(pod.Spec.EphemeralContainers, container)
+
+	pod, err = client.CoreV1().Pods(namespace).UpdateEphemeralContainers(ctx, name, pod, metav1.UpdateOptions{DryRun: dryRunOption(dryRun)})
+	if err != nil {
+		return before, nil, err
+	}
+	after := map[string]string{
+		"ephemeral_containers": ephemeralContainerNames(pod),
+		"container":            container.Name,
+		"image":                image,
+		"target":               target,
+	}
+	return before, after, nil
+}
+
+func ephemeralContainerNames(pod *corev1.Pod) string {
+	var names []string
+	for _, container := range pod.Spec.EphemeralContainers {
+		names = append(names, container.Name)
+	}
+	return strings.Join(names, ",")
+}
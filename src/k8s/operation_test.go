@@ -0,0 +1,188 @@
+package k8s
+
+import (
+	"context"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testPod() *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "api-1", UID: "uid-1"},
+		Spec: corev1.PodSpec{
+			NodeName:   "node-1",
+			Containers: []corev1.Container{{Name: "api", Image: "api:1.0"}},
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+}
+
+// Client with the pod, recording the options of the deletes. A dry run delete is not persisted, like the API server does
+func deleteClient(options *[]metav1.DeleteOptions) *fake.Clientset {
+	client := fake.NewSimpleClientset(testPod())
+	client.PrependReactor("delete", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		deleteOptions := action.(k8stesting.DeleteAction).GetDeleteOptions()
+		*options = append(*options, deleteOptions)
+		return len(deleteOptions.DryRun) > 0, nil, nil
+	})
+	return client
+}
+
+func TestDeletePod(t *testing.T) {
+	tests := []struct {
+		name        string
+		gracePeriod int64
+		dryRun      bool
+		status      string
+	}{
+		{"pod grace period", -1, false, "deleted"},
+		{"immediately", 0, false, "deleted"},
+		{"grace period", 30, false, "deleted"},
+		{"dry run", 30, true, "deleted"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var options []metav1.DeleteOptions
+			client := deleteClient(&options)
+
+			before, after, err := deletePod(client, "web", "api-1", test.gracePeriod, test.dryRun)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(options) != 1 {
+				t.Fatalf("expected one delete, got %d", len(options))
+			}
+			option := options[0]
+
+			if option.Preconditions == nil || option.Preconditions.UID == nil || *option.Preconditions.UID != "uid-1" {
+				t.Errorf("expected the uid precondition of the pod, got %v", option.Preconditions)
+			}
+			if test.gracePeriod < 0 {
+				if option.GracePeriodSeconds != nil {
+					t.Errorf("expected the grace period of the pod, got %d", *option.GracePeriodSeconds)
+				}
+				if _, ok := after["grace_period"]; ok {
+					t.Errorf("expected no grace period in the record, got %v", after)
+				}
+			} else if option.GracePeriodSeconds == nil || *option.GracePeriodSeconds != test.gracePeriod {
+				t.Errorf("expected grace period %d, got %v", test.gracePeriod, option.GracePeriodSeconds)
+			}
+			if dryRun := reflect.DeepEqual(option.DryRun, []string{metav1.DryRunAll}); dryRun != test.dryRun {
+				t.Errorf("expected dry run %v, got %v", test.dryRun, option.DryRun)
+			}
+
+			if before["uid"] != "uid-1" || before["node"] != "node-1" || before["phase"] != "Running" {
+				t.Errorf("unexpected before %v", before)
+			}
+			if after["status"] != test.status {
+				t.Errorf("expected status %v, got %v", test.status, after["status"])
+			}
+			_, err = client.CoreV1().Pods("web").Get(context.TODO(), "api-1", metav1.GetOptions{})
+			if exists := err == nil; exists != test.dryRun {
+				t.Errorf("expected the pod to exist only after a dry run, exists %v", exists)
+			}
+		})
+	}
+}
+
+func TestDeletePodTerminating(t *testing.T) {
+	client := fake.NewSimpleClientset(testPod())
+	// the pod is still there during its grace period
+	client.PrependReactor("delete", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, nil
+	})
+
+	_, after, err := deletePod(client, "web", "api-1", 30, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after["status"] != "terminating" || after["grace_period"] != "30" {
+		t.Errorf("expected terminating with grace period 30, got %v", after)
+	}
+}
+
+func TestDeletePodNotFound(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	if _, _, err := deletePod(client, "web", "api-1", -1, false); err == nil {
+		t.Error("expected an error for a missing pod")
+	}
+}
+
+func TestAddDebugContainer(t *testing.T) {
+	client := fake.NewSimpleClientset(testPod())
+
+	before, after, err := addDebugContainer(client, "web", "api-1", "", "api", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before["ephemeral_containers"] != "" {
+		t.Errorf("expected no ephemeral containers before, got %v", before)
+	}
+	name := after["container"]
+	if !strings.HasPrefix(name, debugContainerPrefix) || len(name) == len(debugContainerPrefix) {
+		t.Errorf("expected a generated container name, got %v", name)
+	}
+	if after["image"] != DefaultDebugImage || after["target"] != "api" || after["ephemeral_containers"] != name {
+		t.Errorf("unexpected after %v", after)
+	}
+
+	pod, err := client.CoreV1().Pods("web").Get(context.TODO(), "api-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pod.Spec.EphemeralContainers) != 1 {
+		t.Fatalf("expected one ephemeral container, got %v", pod.Spec.EphemeralContainers)
+	}
+	container := pod.Spec.EphemeralContainers[0]
+	if container.Name != name || container.Image != DefaultDebugImage || container.TargetContainerName != "api" || !container.TTY {
+		t.Errorf("unexpected ephemeral container %v", container)
+	}
+}
+
+func TestAddDebugContainerRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		image  string
+		target string
+	}{
+		{"unknown target container", "", "sidecar"},
+		{"image not allowed", "attacker/shell:latest", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(testPod())
+			if _, _, err := addDebugContainer(client, "web", "api-1", test.image, test.target, false); err == nil {
+				t.Fatal("expected an error")
+			}
+			for _, action := range client.Actions() {
+				if action.GetVerb() == "update" {
+					t.Errorf("expected the pod to be left unchanged, got %v", action)
+				}
+			}
+		})
+	}
+}
+
+func TestAddDebugContainerDryRun(t *testing.T) {
+	client := fake.NewSimpleClientset(testPod())
+	var options []metav1.UpdateOptions
+	client.PrependReactor("update", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() == "ephemeralcontainers" {
+			options = append(options, action.(k8stesting.UpdateActionImpl).GetUpdateOptions())
+		}
+		return false, nil, nil
+	})
+
+	if _, _, err := addDebugContainer(client, "web", "api-1", DefaultDebugImage, "", true); err != nil {
+		t.Fatal(err)
+	}
+	if len(options) != 1 || !reflect.DeepEqual(options[0].DryRun, []string{metav1.DryRunAll}) {
+		t.Errorf("expected a dry run update, got %v", options)
+	}
+}
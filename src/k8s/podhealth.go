@@ -0,0 +1,158 @@
+package k8s
+
+import (
+	"context"
+	cm "github.com/royroyee/kubem/common"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"log"
+	"sort"
+	"time"
+)
+
+// How long a running container is still reported after being OOMKilled
+const recentOOMWindow = time.Hour
+
+// Problem categories of a pod
+const (
+	ProblemCrashLoop = "CrashLoopBackOff"
+	ProblemOOMKilled = "OOMKilled"
+	ProblemImagePull = "ImagePullBackOff"
+	ProblemConfig    = "ContainerConfigError"
+	ProblemError     = "Error"
+	ProblemEvicted   = "Evicted"
+	ProblemFailed    = "Failed"
+	ProblemUnknown   = "Unknown"
+)
+
+// Reasons reported by the kubelet
+const (
+	reasonOOMKilled     = "OOMKilled"
+	reasonEvicted       = "Evicted"
+	reasonCrashLoop     = "CrashLoopBackOff"
+	reasonErrImagePull  = "ErrImagePull"
+	reasonImagePullBack = "ImagePullBackOff"
+	reasonInvalidImage  = "InvalidImageName"
+)
+
+// Problem pods grouped by reason, filtered by namespace and reason if not empty
+func (kh K8sHandler) GetPodProblems(namespace string, reason string) ([]cm.PodProblemGroup, error) {
+	var result []cm.PodProblemGroup
+
+	podList, err := kh.K8sClient.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		log.Println(err)
+		return result, err
+	}
+
+	var problems []cm.PodProblem
+	for _, pod := range podList.Items {
+		if problem, ok := classifyPod(&pod); ok && (reason == "" || problem.Reason == reason) {
+			problems = append(problems, problem)
+		}
+	}
+	return groupPodProblems(problems), nil
+}
+
+func groupPodProblems(problems []cm.PodProblem) []cm.PodProblemGroup {
+	result := []cm.PodProblemGroup{}
+
+	index := make(map[string]int)
+	for _, problem := range problems {
+		i, ok := index[problem.Reason]
+		if !ok {
+			i = len(result)
+			index[problem.Reason] = i
+			result = append(result, cm.PodProblemGroup{Reason: problem.Reason})
+		}
+		result[i].Pods = append(result[i].Pods, problem)
+	}
+
+	sort.Slice(result, func(i, j int) bool { return len(result[i].Pods) > len(result[j].Pods) })
+	return result
+}
+
+// Inspect the container statuses of a pod for a concrete problem, ok is false for a healthy pod
+func classifyPod(pod *corev1.Pod) (problem cm.PodProblem, ok bool) {
+	problem = cm.PodProblem{
+		Name:      pod.Name,
+		Namespace: pod.Namespace,
+		Owner:     podOwner(pod),
+		Restarts:  podRestarts(pod),
+	}
+
+	if pod.Status.Reason == reasonEvicted {
+		problem.Reason = ProblemEvicted
+		problem.Message = pod.Status.Message
+		return problem, true
+	}
+
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		if reason, ok := classifyContainer(pod, status); ok {
+			problem.Container = status.Name
+			problem.Reason = reason
+
+			if waiting := status.State.Waiting; waiting != nil {
+				problem.Message = waiting.Message
+			}
+			if terminated := status.State.Terminated; terminated != nil {
+				problem.ExitCode = terminated.ExitCode
+				problem.Message = terminated.Message
+			} else if last := status.LastTerminationState.Terminated; last != nil {
+				problem.ExitCode = last.ExitCode
+				if problem.Message == "" {
+					problem.Message = last.Message
+				}
+			}
+			return problem, true
+		}
+	}
+
+	switch pod.Status.Phase {
+	case corev1.PodFailed:
+		problem.Reason = ProblemFailed
+		problem.Message = pod.Status.Message
+		return problem, true
+	case corev1.PodUnknown:
+		problem.Reason = ProblemUnknown
+		problem.Message = pod.Status.Message
+		return problem, true
+	}
+	return problem, false
+}
+
+func classifyContainer(pod *corev1.Pod, status corev1.ContainerStatus) (string, bool) {
+	lastTerminated := status.LastTerminationState.Terminated
+
+	if waiting := status.State.Waiting; waiting != nil {
+		switch waiting.Reason {
+		case reasonCrashLoop:
+			if lastTerminated != nil && lastTerminated.Reason == reasonOOMKilled {
+				return ProblemOOMKilled, true
+			}
+			return ProblemCrashLoop, true
+		case reasonErrImagePull, reasonImagePullBack, reasonInvalidImage:
+			return ProblemImagePull, true
+		case "CreateContainerConfigError", "CreateContainerError", "RunContainerError":
+			return ProblemConfig, true
+		}
+	}
+
+	if terminated := status.State.Terminated; terminated != nil {
+		if terminated.Reason == reasonOOMKilled {
+			return ProblemOOMKilled, true
+		}
+		// a completed pod may have terminated containers, only failures are problems
+		if terminated.ExitCode != 0 && pod.Status.Phase != corev1.PodSucceeded {
+			return ProblemError, true
+		}
+	}
+
+	// a running container which was recently killed for memory
+	if status.State.Running != nil && lastTerminated != nil && lastTerminated.Reason == reasonOOMKilled &&
+		time.Since(lastTerminated.FinishedAt.Time) < recentOOMWindow {
+		return ProblemOOMKilled, true
+	}
+	return "", false
+}
@@ -0,0 +1,212 @@
+package k8s
+
+import (
+	"fmt"
+	cm "github.com/royroyee/kubem/common"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	revisionAnnotation    = "deployment.kubernetes.io/revision"
+	changeCauseAnnotation = "kubernetes.io/change-cause"
+	podTemplateHashLabel  = "pod-template-hash"
+
+	RolloutProgressing = "progressing"
+	RolloutComplete    = "complete"
+	RolloutStalled     = "stalled"
+)
+
+// Record the rollout of every Deployment revision as the informer sees the Deployments change
+func (kh K8sHandler) TrackRollouts() {
+	informer := kh.informers.Apps().V1().Deployments().Informer()
+	kh.informers.Apps().V1().ReplicaSets().Informer()
+
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if deployment, ok := obj.(*appsv1.Deployment); ok {
+				kh.recordRollout(deployment, true)
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			if deployment, ok := newObj.(*appsv1.Deployment); ok {
+				kh.recordRollout(deployment, false)
+			}
+		},
+	})
+}
+
+// Store the progress of the current revision of a Deployment.
+// Revisions seen at startup are dated from their ReplicaSet, later ones from when they are first seen
+func (kh K8sHandler) recordRollout(deployment *appsv1.Deployment, initial bool) {
+	revision := deployment.Annotations[revisionAnnotation]
+	if revision == "" {
+		return
+	}
+
+	rollout := cm.Rollout{
+		Namespace:         deployment.Namespace,
+		Name:              deployment.Name,
+		Revision:          revision,
+		Images:            templateImages(deployment.Spec.Template),
+		Status:            rolloutStatus(deployment),
+		UpdatedReplicas:   deployment.Status.UpdatedReplicas,
+		AvailableReplicas: deployment.Status.AvailableReplicas,
+	}
+	if deployment.Spec.Replicas != nil {
+		rollout.Replicas = *deployment.Spec.Replicas
+	}
+
+	stored, err := kh.GetRollout(rollout.Namespace, rollout.Name, revision)
+	if err != nil {
+		// first time this revision is seen
+		rollout.StartTime = time.Now()
+		if initial {
+			if replicaSet, err := kh.replicaSetOfRevision(deployment, revision); err == nil {
+				rollout.StartTime = replicaSet.CreationTimestamp.Time
+			}
+		}
+		if previous, err := kh.GetLatestRollout(rollout.Namespace, rollout.Name); err == nil {
+			rollout.PreviousImages = previous.Images
+		}
+	} else {
+		rollout.StartTime = stored.StartTime
+		rollout.EndTime = stored.EndTime
+		rollout.PreviousImages = stored.PreviousImages
+	}
+
+	if rollout.Status == RolloutProgressing {
+		rollout.EndTime = time.Time{}
+	} else if rollout.EndTime.IsZero() {
+		rollout.EndTime = time.Now()
+		if initial && rollout.Status == RolloutComplete {
+			rollout.EndTime = rollout.StartTime
+		}
+	}
+
+	kh.StoreRolloutInDB(rollout)
+}
+
+func rolloutStatus(deployment *appsv1.Deployment) string {
+	for _, condition := range deployment.Status.Conditions {
+		if condition.Type == appsv1.DeploymentProgressing && condition.Reason == "ProgressDeadlineExceeded" {
+			return RolloutStalled
+		}
+	}
+
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	status := deployment.Status
+	if status.ObservedGeneration >= deployment.Generation &&
+		status.UpdatedReplicas == replicas &&
+		status.Replicas == replicas &&
+		status.AvailableReplicas == replicas {
+		return RolloutComplete
+	}
+	return RolloutProgressing
+}
+
+func templateImages(template corev1.PodTemplateSpec) []string {
+	var result []string
+	for _, container := range template.Spec.InitContainers {
+		result = append(result, fmt.Sprintf("%s=%s", container.Name, container.Image))
+	}
+	for _, container := range template.Spec.Containers {
+		result = append(result, fmt.Sprintf("%s=%s", container.Name, container.Image))
+	}
+	return result
+}
+
+// ReplicaSets owned by the Deployment, from the informer cache
+func (kh K8sHandler) replicaSetsOf(deployment *appsv1.Deployment) ([]*appsv1.ReplicaSet, error) {
+	var result []*appsv1.ReplicaSet
+
+	replicaSets, err := kh.informers.Apps().V1().ReplicaSets().Lister().ReplicaSets(deployment.Namespace).List(labels.Everything())
+	if err != nil {
+		return result, err
+	}
+	for _, replicaSet := range replicaSets {
+		if owner := metav1.GetControllerOf(replicaSet); owner != nil && owner.UID == deployment.UID {
+			result = append(result, replicaSet)
+		}
+	}
+	return result, nil
+}
+
+func (kh K8sHandler) replicaSetOfRevision(deployment *appsv1.Deployment, revision string) (*appsv1.ReplicaSet, error) {
+	replicaSets, err := kh.replicaSetsOf(deployment)
+	if err != nil {
+		return nil, err
+	}
+	for _, replicaSet := range replicaSets {
+		if replicaSet.Annotations[revisionAnnotation] == revision {
+			return replicaSet, nil
+		}
+	}
+	return nil, fmt.Errorf("Revision %v of %v/%v not found", revision, deployment.Namespace, deployment.Name)
+}
+
+// Revisions of a Deployment, newest first, with the pod template diff between two of them if both are given
+func (kh K8sHandler) GetRevisions(namespace string, name string, from string, to string) (cm.RevisionHistory, error) {
+	var result cm.RevisionHistory
+
+	deployment, err := kh.informers.Apps().V1().Deployments().Lister().Deployments(namespace).Get(name)
+	if err != nil {
+		return result, err
+	}
+	replicaSets, err := kh.replicaSetsOf(deployment)
+	if err != nil {
+		return result, err
+	}
+
+	for _, replicaSet := range replicaSets {
+		revision := cm.Revision{
+			Revision:    replicaSet.Annotations[revisionAnnotation],
+			ReplicaSet:  replicaSet.Name,
+			Images:      templateImages(replicaSet.Spec.Template),
+			ChangeCause: replicaSet.Annotations[changeCauseAnnotation],
+			Replicas:    replicaSet.Status.Replicas,
+			Created:     replicaSet.CreationTimestamp.Time,
+		}
+		result.Revisions = append(result.Revisions, revision)
+	}
+	sort.Slice(result.Revisions, func(i, j int) bool {
+		a, _ := strconv.Atoi(result.Revisions[i].Revision)
+		b, _ := strconv.Atoi(result.Revisions[j].Revision)
+		return a > b
+	})
+
+	if from == "" || to == "" {
+		return result, nil
+	}
+
+	fromReplicaSet, err := kh.replicaSetOfRevision(deployment, from)
+	if err != nil {
+		return result, err
+	}
+	toReplicaSet, err := kh.replicaSetOfRevision(deployment, to)
+	if err != nil {
+		return result, err
+	}
+
+	result.Diff, err = diffObjects(comparableTemplate(fromReplicaSet.Spec.Template), comparableTemplate(toReplicaSet.Spec.Template))
+	if err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// Pod template without the label which differs between every ReplicaSet
+func comparableTemplate(template corev1.PodTemplateSpec) corev1.PodTemplateSpec {
+	result := *template.DeepCopy()
+	delete(result.Labels, podTemplateHashLabel)
+	return result
+}
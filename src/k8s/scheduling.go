@@ -0,0 +1,394 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	cm "github.com/royroyee/kubem/common"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Causes of a pending pod
+const (
+	CauseInsufficientCPU    = "InsufficientCPU"
+	CauseInsufficientMemory = "InsufficientMemory"
+	CauseTaint              = "TaintNotTolerated"
+	CauseNodeAffinity       = "NodeAffinity"
+	CauseNodeUnschedulable  = "NodeUnschedulable"
+	CauseUnboundPVC         = "UnboundPVC"
+	CauseQuota              = "QuotaExceeded"
+)
+
+// Fragments of the scheduler messages for every cause
+var schedulerMessages = map[string][]string{
+	CauseInsufficientCPU:    {"Insufficient cpu"},
+	CauseInsufficientMemory: {"Insufficient memory"},
+	CauseTaint:              {"untolerated taint", "had taint", "had taints"},
+	CauseNodeAffinity:       {"didn't match Pod's node affinity", "didn't match node selector", "node(s) didn't match"},
+	CauseNodeUnschedulable:  {"node(s) were unschedulable"},
+	CauseUnboundPVC:         {"unbound immediate PersistentVolumeClaims", "persistentvolumeclaim", "PersistentVolumeClaim"},
+	CauseQuota:              {"exceeded quota"},
+}
+
+// Information of the pod, with a scheduling diagnosis if it is pending
+func (kh K8sHandler) GetPodInfo(podName string) (cm.PodInfo, error) {
+
+	result, err := kh.GetInfoOfPod(podName)
+	if err != nil {
+		return result, err
+	}
+
+	pod, err := kh.K8sClient.CoreV1().Pods(result.Namespace).Get(context.TODO(), podName, metav1.GetOptions{})
+	if err != nil {
+		// the stored information is still useful
+		log.Println(err)
+		return result, nil
+	}
+	if unscheduled(pod) {
+		diagnoses, err := kh.diagnosePendingPods([]corev1.Pod{*pod})
+		if err != nil {
+			log.Println(err)
+			return result, nil
+		}
+		if len(diagnoses) > 0 {
+			result.Diagnosis = &diagnoses[0]
+		}
+	}
+
+	return result, nil
+}
+
+// Diagnosis of every pod in the namespace (all namespaces if empty) which is not scheduled yet.
+// Pods rejected by a quota are never created, their controllers are diagnosed instead
+func (kh K8sHandler) GetPendingDiagnoses(namespace string) ([]cm.PendingDiagnosis, error) {
+	result := []cm.PendingDiagnosis{}
+
+	pods, err := kh.K8sClient.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{FieldSelector: "status.phase=Pending"})
+	if err != nil {
+		log.Println(err)
+		return result, err
+	}
+
+	// bound pods are pending while their images are pulled or their containers created
+	var pending []corev1.Pod
+	for _, pod := range pods.Items {
+		if unscheduled(&pod) {
+			pending = append(pending, pod)
+		}
+	}
+	if len(pending) > 0 {
+		diagnoses, err := kh.diagnosePendingPods(pending)
+		if err != nil {
+			return result, err
+		}
+		result = append(result, diagnoses...)
+	}
+
+	quotas, err := kh.quotaDiagnoses(namespace)
+	if err != nil {
+		return result, err
+	}
+	return append(result, quotas...), nil
+}
+
+// Whether the scheduler did not place the pod yet
+func unscheduled(pod *corev1.Pod) bool {
+	if pod.Status.Phase != corev1.PodPending {
+		return false
+	}
+	if pod.Spec.NodeName == "" {
+		return true
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodScheduled && condition.Status == corev1.ConditionFalse {
+			return true
+		}
+	}
+	return false
+}
+
+// Read the PodScheduled condition and the FailedScheduling events of each pod,
+// and check every node against the pod as the scheduler would
+func (kh K8sHandler) diagnosePendingPods(pending []corev1.Pod) ([]cm.PendingDiagnosis, error) {
+	var result []cm.PendingDiagnosis
+
+	nodes, err := kh.K8sClient.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		log.Println(err)
+		return result, err
+	}
+	pods, err := kh.K8sClient.CoreV1().Pods(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		log.Println(err)
+		return result, err
+	}
+
+	diagnosed := make(map[string]bool)
+	for _, pod := range pending {
+		diagnosed[string(pod.UID)] = true
+	}
+
+	// resources already requested on every node, without the pods being diagnosed
+	requested := make(map[string]corev1.ResourceList)
+	for _, pod := range pods.Items {
+		if pod.Spec.NodeName == "" || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		if diagnosed[string(pod.UID)] {
+			continue
+		}
+		requests, _ := podResources(&pod)
+		if _, ok := requested[pod.Spec.NodeName]; !ok {
+			requested[pod.Spec.NodeName] = corev1.ResourceList{}
+		}
+		addResources(requested[pod.Spec.NodeName], requests)
+	}
+
+	for _, pod := range pending {
+		diagnosis := cm.PendingDiagnosis{Kind: "Pod", Name: pod.Name, Namespace: pod.Namespace}
+		causes := make(map[string]bool)
+
+		for _, condition := range pod.Status.Conditions {
+			if condition.Type == corev1.PodScheduled && condition.Status != corev1.ConditionTrue {
+				diagnosis.Message = condition.Message
+			}
+		}
+
+		events, err := kh.K8sClient.CoreV1().Events(pod.Namespace).List(context.TODO(), metav1.ListOptions{
+			FieldSelector: "involvedObject.name=" + pod.Name + ",reason=FailedScheduling",
+		})
+		if err != nil {
+			log.Println(err)
+		} else {
+			for _, event := range events.Items {
+				diagnosis.Events = append(diagnosis.Events, event.Message)
+			}
+		}
+
+		for _, message := range append([]string{diagnosis.Message}, diagnosis.Events...) {
+			for cause, fragments := range schedulerMessages {
+				for _, fragment := range fragments {
+					if strings.Contains(message, fragment) {
+						causes[cause] = true
+					}
+				}
+			}
+		}
+
+		for _, cause := range kh.unboundClaims(&pod) {
+			causes[cause] = true
+		}
+
+		for _, node := range nodes.Items {
+			reasons := nodeRejections(&pod, &node, requested[node.Name])
+			if len(reasons) == 0 {
+				continue
+			}
+			var messages []string
+			for _, reason := range reasons {
+				causes[reason.cause] = true
+				messages = append(messages, reason.message)
+			}
+			diagnosis.RejectedNodes = append(diagnosis.RejectedNodes, cm.NodeRejection{Node: node.Name, Reasons: messages})
+		}
+
+		for cause := range causes {
+			diagnosis.Causes = append(diagnosis.Causes, cause)
+		}
+		sort.Strings(diagnosis.Causes)
+
+		result = append(result, diagnosis)
+	}
+	return result, nil
+}
+
+// Causes of the claims of the pod which are not bound yet
+func (kh K8sHandler) unboundClaims(pod *corev1.Pod) []string {
+	var result []string
+	for _, volume := range pod.Spec.Volumes {
+		if volume.PersistentVolumeClaim == nil {
+			continue
+		}
+		claim, err := kh.K8sClient.CoreV1().PersistentVolumeClaims(pod.Namespace).Get(context.TODO(), volume.PersistentVolumeClaim.ClaimName, metav1.GetOptions{})
+		if err != nil || claim.Status.Phase != corev1.ClaimBound {
+			result = append(result, CauseUnboundPVC)
+		}
+	}
+	return result
+}
+
+// Controllers which failed to create their pods because of a quota, from their FailedCreate events
+func (kh K8sHandler) quotaDiagnoses(namespace string) ([]cm.PendingDiagnosis, error) {
+	var result []cm.PendingDiagnosis
+
+	events, err := kh.K8sClient.CoreV1().Events(namespace).List(context.TODO(), metav1.ListOptions{FieldSelector: "reason=FailedCreate"})
+	if err != nil {
+		log.Println(err)
+		return result, err
+	}
+
+	controllers := make(map[string]*cm.PendingDiagnosis)
+	var keys []string
+	for _, event := range events.Items {
+		if !strings.Contains(event.Message, "exceeded quota") {
+			continue
+		}
+		object := event.InvolvedObject
+		key := object.Namespace + "/" + object.Kind + "/" + object.Name
+		diagnosis, ok := controllers[key]
+		if !ok {
+			diagnosis = &cm.PendingDiagnosis{Kind: object.Kind, Name: object.Name, Namespace: object.Namespace, Causes: []string{CauseQuota}}
+			controllers[key] = diagnosis
+			keys = append(keys, key)
+		}
+		if !contains(diagnosis.Events, event.Message) {
+			diagnosis.Events = append(diagnosis.Events, event.Message)
+		}
+	}
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		result = append(result, *controllers[key])
+	}
+	return result, nil
+}
+
+type nodeRejection struct {
+	cause   string
+	message string
+}
+
+// Why the node cannot run the pod, empty if it can
+func nodeRejections(pod *corev1.Pod, node *corev1.Node, requested corev1.ResourceList) []nodeRejection {
+	var result []nodeRejection
+
+	if node.Spec.Unschedulable {
+		result = append(result, nodeRejection{CauseNodeUnschedulable, "node is cordoned"})
+	}
+
+	for i := range node.Spec.Taints {
+		taint := node.Spec.Taints[i]
+		if taint.Effect == corev1.TaintEffectPreferNoSchedule {
+			continue
+		}
+		if !toleratesTaint(pod.Spec.Tolerations, &taint) {
+			result = append(result, nodeRejection{CauseTaint, fmt.Sprintf("untolerated taint %s", taint.ToString())})
+		}
+	}
+
+	for key, value := range pod.Spec.NodeSelector {
+		if node.Labels[key] != value {
+			result = append(result, nodeRejection{CauseNodeAffinity, fmt.Sprintf("node selector %s=%s does not match", key, value)})
+		}
+	}
+	if affinity := pod.Spec.Affinity; affinity != nil && affinity.NodeAffinity != nil {
+		if required := affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution; required != nil {
+			if !matchesNodeSelectorTerms(required.NodeSelectorTerms, node.Labels) {
+				result = append(result, nodeRejection{CauseNodeAffinity, "required node affinity does not match"})
+			}
+		}
+	}
+
+	requests, _ := podResources(pod)
+	allocatable := node.Status.Allocatable
+	if free := freeResource(allocatable.Cpu(), requested.Cpu()); requests.Cpu().Cmp(free) > 0 {
+		result = append(result, nodeRejection{CauseInsufficientCPU, fmt.Sprintf("insufficient cpu (requested %s, free %s)", requests.Cpu().String(), free.String())})
+	}
+	if free := freeResource(allocatable.Memory(), requested.Memory()); requests.Memory().Cmp(free) > 0 {
+		result = append(result, nodeRejection{CauseInsufficientMemory, fmt.Sprintf("insufficient memory (requested %s, free %s)", requests.Memory().String(), free.String())})
+	}
+
+	return result
+}
+
+func freeResource(allocatable *resource.Quantity, requested *resource.Quantity) resource.Quantity {
+	free := allocatable.DeepCopy()
+	free.Sub(*requested)
+	return free
+}
+
+func toleratesTaint(tolerations []corev1.Toleration, taint *corev1.Taint) bool {
+	for _, toleration := range tolerations {
+		if toleration.Effect != "" && toleration.Effect != taint.Effect {
+			continue
+		}
+		if toleration.Key != "" && toleration.Key != taint.Key {
+			continue
+		}
+		switch toleration.Operator {
+		case "", corev1.TolerationOpEqual:
+			if toleration.Value == taint.Value {
+				return true
+			}
+		case corev1.TolerationOpExists:
+			return true
+		}
+	}
+	return false
+}
+
+// Terms are ORed, the expressions of a term are ANDed
+func matchesNodeSelectorTerms(terms []corev1.NodeSelectorTerm, labels map[string]string) bool {
+	for _, term := range terms {
+		if len(term.MatchExpressions) == 0 && len(term.MatchFields) == 0 {
+			continue
+		}
+		matches := true
+		for _, expression := range term.MatchExpressions {
+			if !matchesNodeSelectorRequirement(expression, labels) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesNodeSelectorRequirement(requirement corev1.NodeSelectorRequirement, labels map[string]string) bool {
+	value, exists := labels[requirement.Key]
+
+	switch requirement.Operator {
+	case corev1.NodeSelectorOpIn:
+		return exists && contains(requirement.Values, value)
+	case corev1.NodeSelectorOpNotIn:
+		return !exists || !contains(requirement.Values, value)
+	case corev1.NodeSelectorOpExists:
+		return exists
+	case corev1.NodeSelectorOpDoesNotExist:
+		return !exists
+	case corev1.NodeSelectorOpGt, corev1.NodeSelectorOpLt:
+		if !exists || len(requirement.Values) != 1 {
+			return false
+		}
+		actual, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return false
+		}
+		limit, err := strconv.ParseInt(requirement.Values[0], 10, 64)
+		if err != nil {
+			return false
+		}
+		if requirement.Operator == corev1.NodeSelectorOpGt {
+			return actual > limit
+		}
+		return actual < limit
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,366 @@
+package k8s
+
+import (
+	"fmt"
+	cm "github.com/royroyee/kubem/common"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sort"
+	"strings"
+)
+
+// Severities of the findings, with the penalty they take from the score of a workload
+const (
+	SeverityCritical = "critical"
+	SeverityHigh     = "high"
+	SeverityMedium   = "medium"
+	SeverityLow      = "low"
+)
+
+var severityPenalty = map[string]int{
+	SeverityCritical: 40,
+	SeverityHigh:     20,
+	SeverityMedium:   10,
+	SeverityLow:      3,
+}
+
+var severityRank = map[string]int{
+	SeverityCritical: 4,
+	SeverityHigh:     3,
+	SeverityMedium:   2,
+	SeverityLow:      1,
+}
+
+// Capabilities which are as good as privileged
+var dangerousCapabilities = []string{"ALL", "SYS_ADMIN", "NET_ADMIN", "SYS_PTRACE", "SYS_MODULE"}
+
+// Workload with the pod template it runs
+type workload struct {
+	kind     string
+	meta     metav1.ObjectMeta
+	replicas int32 // 0 unless the kind has replicas
+	object   interface{}
+	spec     *corev1.PodSpec
+	labels   map[string]string // of the pods
+}
+
+type securityCheck struct {
+	id       string
+	severity string
+	check    func(spec *corev1.PodSpec) []cm.SecurityFinding
+}
+
+// Built-in checks of the pod templates
+var securityChecks = []securityCheck{
+	{"privileged", SeverityCritical, checkPrivileged},
+	{"host-path", SeverityHigh, checkHostPath},
+	{"host-network", SeverityHigh, checkHostNetwork},
+	{"host-pid", SeverityHigh, checkHostPID},
+	{"run-as-root", SeverityMedium, checkRunAsRoot},
+	{"added-capabilities", SeverityMedium, checkAddedCapabilities},
+	{"writable-root-filesystem", SeverityLow, checkReadOnlyRootFilesystem},
+	{"latest-tag", SeverityLow, checkLatestTag},
+	{"missing-limits", SeverityLow, checkMissingLimits},
+}
+
+// Findings of every workload of the namespace (all namespaces if empty) with at least the given severity,
+// and the score of every namespace
+func (kh K8sHandler) GetSecurityFindings(namespace string, severity string, page int, perPage int) (cm.SecurityReport, error) {
+	result := cm.SecurityReport{Scores: []cm.NamespaceScore{}, Workloads: []cm.WorkloadFindings{}}
+
+	if severity != "" && severityRank[severity] == 0 {
+		return result, fmt.Errorf("Invalid severity %v", severity)
+	}
+
+	workloads, err := kh.workloads(namespace)
+	if err != nil {
+		return result, err
+	}
+
+	scores := make(map[string]*cm.NamespaceScore)
+	penalties := make(map[string]int)
+	for _, workload := range workloads {
+		findings := scanWorkload(workload)
+
+		score, ok := scores[workload.meta.Namespace]
+		if !ok {
+			score = &cm.NamespaceScore{Namespace: workload.meta.Namespace, Findings: make(map[string]int)}
+			scores[workload.meta.Namespace] = score
+		}
+		score.Workloads++
+		penalty := 0
+		for _, finding := range findings {
+			score.Findings[finding.Severity]++
+			penalty += severityPenalty[finding.Severity]
+		}
+		if penalty > 100 {
+			penalty = 100
+		}
+		penalties[workload.meta.Namespace] += penalty
+
+		if len(findings) == 0 {
+			continue
+		}
+		entry := cm.WorkloadFindings{
+			Namespace: workload.meta.Namespace,
+			Kind:      workload.kind,
+			Name:      workload.meta.Name,
+			Findings:  findings,
+		}
+		for _, finding := range findings {
+			if severityRank[finding.Severity] > severityRank[entry.Severity] {
+				entry.Severity = finding.Severity
+			}
+		}
+		if severityRank[entry.Severity] >= severityRank[severity] {
+			result.Workloads = append(result.Workloads, entry)
+		}
+	}
+
+	// the score is 100 minus the average penalty of the workloads
+	for name, score := range scores {
+		score.Score = 100 - penalties[name]/score.Workloads
+		result.Scores = append(result.Scores, *score)
+	}
+	sort.Slice(result.Scores, func(i, j int) bool { return result.Scores[i].Score < result.Scores[j].Score })
+
+	sort.SliceStable(result.Workloads, func(i, j int) bool {
+		return severityRank[result.Workloads[i].Severity] > severityRank[result.Workloads[j].Severity]
+	})
+	start, end := pageBounds(len(result.Workloads), page, perPage)
+	result.Workloads = result.Workloads[start:end]
+
+	return result, nil
+}
+
+func scanWorkload(workload workload) []cm.SecurityFinding {
+	var result []cm.SecurityFinding
+	for _, check := range securityChecks {
+		for _, finding := range check.check(workload.spec) {
+			finding.Check = check.id
+			// a check may raise the severity of a single finding
+			if finding.Severity == "" {
+				finding.Severity = check.severity
+			}
+			result = append(result, finding)
+		}
+	}
+	return result
+}
+
+// Workloads of the namespace from the informer caches.
+// Jobs of a CronJob and pods of a controller are covered by their owner
+func (kh K8sHandler) workloads(namespace string) ([]workload, error) {
+	var result []workload
+
+	deployments, err := kh.informers.Apps().V1().Deployments().Lister().Deployments(namespace).List(labels.Everything())
+	if err != nil {
+		return result, err
+	}
+	for _, deployment := range deployments {
+		result = append(result, workload{kind: "Deployment", meta: deployment.ObjectMeta, replicas: replicasOf(deployment.Spec.Replicas), object: deployment, spec: &deployment.Spec.Template.Spec, labels: deployment.Spec.Template.Labels})
+	}
+
+	statefulSets, err := kh.informers.Apps().V1().StatefulSets().Lister().StatefulSets(namespace).List(labels.Everything())
+	if err != nil {
+		return result, err
+	}
+	for _, statefulSet := range statefulSets {
+		result = append(result, workload{kind: "StatefulSet", meta: statefulSet.ObjectMeta, replicas: replicasOf(statefulSet.Spec.Replicas), object: statefulSet, spec: &statefulSet.Spec.Template.Spec, labels: statefulSet.Spec.Template.Labels})
+	}
+
+	daemonSets, err := kh.informers.Apps().V1().DaemonSets().Lister().DaemonSets(namespace).List(labels.Everything())
+	if err != nil {
+		return result, err
+	}
+	for _, daemonSet := range daemonSets {
+		result = append(result, workload{kind: "DaemonSet", meta: daemonSet.ObjectMeta, object: daemonSet, spec: &daemonSet.Spec.Template.Spec, labels: daemonSet.Spec.Template.Labels})
+	}
+
+	cronJobs, err := kh.informers.Batch().V1().CronJobs().Lister().CronJobs(namespace).List(labels.Everything())
+	if err != nil {
+		return result, err
+	}
+	for _, cronJob := range cronJobs {
+		result = append(result, workload{kind: "CronJob", meta: cronJob.ObjectMeta, object: cronJob, spec: &cronJob.Spec.JobTemplate.Spec.Template.Spec, labels: cronJob.Spec.JobTemplate.Spec.Template.Labels})
+	}
+
+	jobs, err := kh.informers.Batch().V1().Jobs().Lister().Jobs(namespace).List(labels.Everything())
+	if err != nil {
+		return result, err
+	}
+	for _, job := range jobs {
+		if metav1.GetControllerOf(job) != nil {
+			continue
+		}
+		result = append(result, workload{kind: "Job", meta: job.ObjectMeta, object: job, spec: &job.Spec.Template.Spec, labels: job.Spec.Template.Labels})
+	}
+
+	pods, err := kh.informers.Core().V1().Pods().Lister().Pods(namespace).List(labels.Everything())
+	if err != nil {
+		return result, err
+	}
+	for _, pod := range pods {
+		if metav1.GetControllerOf(pod) != nil {
+			continue
+		}
+		result = append(result, workload{kind: "Pod", meta: pod.ObjectMeta, object: pod, spec: &pod.Spec, labels: pod.Labels})
+	}
+
+	return result, nil
+}
+
+// Desired replicas, which default to 1
+func replicasOf(replicas *int32) int32 {
+	if replicas == nil {
+		return 1
+	}
+	return *replicas
+}
+
+// Init and regular containers of the pod
+func allContainers(spec *corev1.PodSpec) []corev1.Container {
+	return append(append([]corev1.Container{}, spec.InitContainers...), spec.Containers...)
+}
+
+func checkPrivileged(spec *corev1.PodSpec) []cm.SecurityFinding {
+	var result []cm.SecurityFinding
+	for _, container := range allContainers(spec) {
+		if context := container.SecurityContext; context != nil && context.Privileged != nil && *context.Privileged {
+			result = append(result, cm.SecurityFinding{Container: container.Name, Message: "container is privileged"})
+		}
+	}
+	return result
+}
+
+func checkHostPath(spec *corev1.PodSpec) []cm.SecurityFinding {
+	var result []cm.SecurityFinding
+	for _, volume := range spec.Volumes {
+		if volume.HostPath != nil {
+			result = append(result, cm.SecurityFinding{Message: fmt.Sprintf("volume %s mounts host path %s", volume.Name, volume.HostPath.Path)})
+		}
+	}
+	return result
+}
+
+func checkHostNetwork(spec *corev1.PodSpec) []cm.SecurityFinding {
+	if spec.HostNetwork {
+		return []cm.SecurityFinding{{Message: "pod uses the host network"}}
+	}
+	return nil
+}
+
+func checkHostPID(spec *corev1.PodSpec) []cm.SecurityFinding {
+	var result []cm.SecurityFinding
+	if spec.HostPID {
+		result = append(result, cm.SecurityFinding{Message: "pod shares the host process namespace"})
+	}
+	if spec.HostIPC {
+		result = append(result, cm.SecurityFinding{Message: "pod shares the host IPC namespace"})
+	}
+	return result
+}
+
+// Containers which may run as root, the container security context overrides the one of the pod
+func checkRunAsRoot(spec *corev1.PodSpec) []cm.SecurityFinding {
+	var result []cm.SecurityFinding
+
+	var podNonRoot *bool
+	var podUser *int64
+	if spec.SecurityContext != nil {
+		podNonRoot = spec.SecurityContext.RunAsNonRoot
+		podUser = spec.SecurityContext.RunAsUser
+	}
+
+	for _, container := range allContainers(spec) {
+		nonRoot, user := podNonRoot, podUser
+		if context := container.SecurityContext; context != nil {
+			if context.RunAsNonRoot != nil {
+				nonRoot = context.RunAsNonRoot
+			}
+			if context.RunAsUser != nil {
+				user = context.RunAsUser
+			}
+		}
+
+		switch {
+		case user != nil && *user == 0:
+			result = append(result, cm.SecurityFinding{Container: container.Name, Message: "container runs as user 0"})
+		case user == nil && (nonRoot == nil || !*nonRoot):
+			result = append(result, cm.SecurityFinding{Container: container.Name, Message: "container may run as root, runAsNonRoot is not set"})
+		}
+	}
+	return result
+}
+
+func checkAddedCapabilities(spec *corev1.PodSpec) []cm.SecurityFinding {
+	var result []cm.SecurityFinding
+	for _, container := range allContainers(spec) {
+		context := container.SecurityContext
+		if context == nil || context.Capabilities == nil || len(context.Capabilities.Add) == 0 {
+			continue
+		}
+		var added []string
+		for _, capability := range context.Capabilities.Add {
+			added = append(added, string(capability))
+		}
+		finding := cm.SecurityFinding{Container: container.Name, Message: "container adds capabilities " + strings.Join(added, ", ")}
+		for _, capability := range added {
+			if contains(dangerousCapabilities, strings.TrimPrefix(capability, "CAP_")) {
+				finding.Severity = SeverityHigh
+			}
+		}
+		result = append(result, finding)
+	}
+	return result
+}
+
+func checkReadOnlyRootFilesystem(spec *corev1.PodSpec) []cm.SecurityFinding {
+	var result []cm.SecurityFinding
+	for _, container := range allContainers(spec) {
+		context := container.SecurityContext
+		if context == nil || context.ReadOnlyRootFilesystem == nil || !*context.ReadOnlyRootFilesystem {
+			result = append(result, cm.SecurityFinding{Container: container.Name, Message: "root filesystem is writable"})
+		}
+	}
+	return result
+}
+
+func checkLatestTag(spec *corev1.PodSpec) []cm.SecurityFinding {
+	var result []cm.SecurityFinding
+	for _, container := range allContainers(spec) {
+		if isLatestImage(container.Image) {
+			result = append(result, cm.SecurityFinding{Container: container.Name, Message: fmt.Sprintf("image %s is not pinned to a version", container.Image)})
+		}
+	}
+	return result
+}
+
+// An image without tag or digest is the latest one
+func isLatestImage(image string) bool {
+	if strings.Contains(image, "@") {
+		return false
+	}
+	// the last colon after the last slash separates the tag, a colon before it is a registry port
+	name := image[strings.LastIndex(image, "/")+1:]
+	i := strings.LastIndex(name, ":")
+	return i < 0 || name[i+1:] == "latest"
+}
+
+func checkMissingLimits(spec *corev1.PodSpec) []cm.SecurityFinding {
+	var result []cm.SecurityFinding
+	for _, container := range spec.Containers {
+		var missing []string
+		if _, ok := container.Resources.Limits[corev1.ResourceCPU]; !ok {
+			missing = append(missing, "cpu")
+		}
+		if _, ok := container.Resources.Limits[corev1.ResourceMemory]; !ok {
+			missing = append(missing, "memory")
+		}
+		if len(missing) > 0 {
+			result = append(result, cm.SecurityFinding{Container: container.Name, Message: "no " + strings.Join(missing, " and ") + " limit"})
+		}
+	}
+	return result
+}
@@ -0,0 +1,200 @@
+package k8s
+
+import (
+	"fmt"
+	"github.com/robfig/cron/v3"
+	cm "github.com/royroyee/kubem/common"
+	"gopkg.in/mgo.v2/bson"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	ActionSilence       = "silence"
+	ActionExpireSilence = "expire-silence"
+)
+
+// Validate and store a new silence or maintenance window, created by silence.CreatedBy
+func (kh K8sHandler) CreateSilence(silence cm.Silence) (cm.Silence, error) {
+	record := cm.AuditRecord{User: silence.CreatedBy, Action: ActionSilence, Kind: "Silence"}
+
+	silence, err := kh.createSilence(silence)
+	record.Name = silence.Id
+	record.After = silenceFields(silence)
+	_, err = kh.audit(record, err)
+	return silence, err
+}
+
+func (kh K8sHandler) createSilence(silence cm.Silence) (cm.Silence, error) {
+	now := time.Now()
+
+	if len(silence.Matchers) == 0 {
+		return silence, fmt.Errorf("Silence requires at least one matcher")
+	}
+	for _, matcher := range silence.Matchers {
+		if matcher.Name == "" {
+			return silence, fmt.Errorf("Matcher without name")
+		}
+		if matcher.Regex {
+			if _, err := regexp.Compile("^(?:" + matcher.Value + ")$"); err != nil {
+				return silence, fmt.Errorf("Invalid matcher %v: %v", matcher.Name, err)
+			}
+		}
+	}
+
+	if silence.StartsAt.IsZero() {
+		silence.StartsAt = now
+	}
+	if silence.Schedule != "" {
+		if _, err := cron.ParseStandard(silence.Schedule); err != nil {
+			return silence, fmt.Errorf("Invalid schedule: %v", err)
+		}
+		duration, err := time.ParseDuration(silence.Duration)
+		if err != nil || duration <= 0 {
+			return silence, fmt.Errorf("Maintenance window requires a positive duration")
+		}
+	} else if !silence.EndsAt.After(silence.StartsAt) {
+		return silence, fmt.Errorf("Silence must end after it starts")
+	}
+
+	silence.Id = bson.NewObjectId().Hex()
+	silence.Created = now
+
+	if err := kh.StoreSilenceInDB(silence); err != nil {
+		return silence, err
+	}
+	return silence, nil
+}
+
+// Expire a silence now, it is kept for audit
+func (kh K8sHandler) ExpireSilence(user string, id string) (cm.Silence, error) {
+	record := cm.AuditRecord{User: user, Action: ActionExpireSilence, Kind: "Silence", Name: id}
+
+	silence, err := kh.GetSilence(id)
+	if err != nil {
+		_, err = kh.audit(record, err)
+		return silence, err
+	}
+	record.Before = silenceFields(silence)
+
+	silence, err = kh.expireSilence(silence)
+	if err == nil {
+		record.After = silenceFields(silence)
+	}
+	_, err = kh.audit(record, err)
+	return silence, err
+}
+
+func (kh K8sHandler) expireSilence(silence cm.Silence) (cm.Silence, error) {
+	id := silence.Id
+
+	now := time.Now()
+	if !silence.EndsAt.IsZero() && !silence.EndsAt.After(now) {
+		return silence, fmt.Errorf("Silence %v already expired", id)
+	}
+	silence.EndsAt = now
+	if silence.StartsAt.After(now) {
+		silence.StartsAt = now
+	}
+
+	if err := kh.UpdateSilenceInDB(silence); err != nil {
+		return silence, err
+	}
+	return silence, nil
+}
+
+// What the silence mutes and when, for the audit records
+func silenceFields(silence cm.Silence) map[string]string {
+	var matchers []string
+	for _, matcher := range silence.Matchers {
+		operator := "="
+		if matcher.Regex {
+			operator = "=~"
+		}
+		matchers = append(matchers, matcher.Name+operator+matcher.Value)
+	}
+	fields := map[string]string{
+		"matchers":  strings.Join(matchers, ","),
+		"starts_at": silence.StartsAt.Format(time.RFC3339),
+		"comment":   silence.Comment,
+	}
+	if !silence.EndsAt.IsZero() {
+		fields["ends_at"] = silence.EndsAt.Format(time.RFC3339)
+	}
+	if silence.Schedule != "" {
+		fields["schedule"] = silence.Schedule
+		fields["duration"] = silence.Duration
+	}
+	return fields
+}
+
+// Id of the first active silence matching the alert, empty if none
+func (kh K8sHandler) silencedBy(alert cm.Alert, now time.Time) string {
+	silences, err := kh.GetActiveSilences(now)
+	if err != nil {
+		// better notify too much than nothing
+		return ""
+	}
+	return matchingSilence(silences, alert, now)
+}
+
+// Id of the first of the silences active at now which matches the alert, empty if none
+func matchingSilence(silences []cm.Silence, alert cm.Alert, now time.Time) string {
+	for _, silence := range silences {
+		if silenceActive(silence, now) && silenceMatches(silence, alert) {
+			return silence.Id
+		}
+	}
+	return ""
+}
+
+// Whether a silence which started and did not end yet is active, maintenance windows only in their scheduled windows
+func silenceActive(silence cm.Silence, now time.Time) bool {
+	if silence.Schedule == "" {
+		return true
+	}
+
+	schedule, err := cron.ParseStandard(silence.Schedule)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	duration, err := time.ParseDuration(silence.Duration)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+
+	// the last scheduled start is less than duration ago
+	return !schedule.Next(now.Add(-duration)).After(now)
+}
+
+func silenceMatches(silence cm.Silence, alert cm.Alert) bool {
+	for _, matcher := range silence.Matchers {
+		var value string
+		switch matcher.Name {
+		case "rule":
+			value = alert.Rule
+		case "subject", "node":
+			value = alert.Subject
+		case "namespace":
+			value = alert.Namespace
+		case "severity":
+			value = alert.Severity
+		default:
+			value = alert.Labels[matcher.Name]
+		}
+
+		if matcher.Regex {
+			re, err := regexp.Compile("^(?:" + matcher.Value + ")$")
+			if err != nil || !re.MatchString(value) {
+				return false
+			}
+		} else if value != matcher.Value {
+			return false
+		}
+	}
+	return true
+}
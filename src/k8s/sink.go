@@ -0,0 +1,152 @@
+package k8s
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	cm "github.com/royroyee/kubem/common"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+const sinkTimeout = 10 * time.Second
+
+// Destination of notifications
+type notificationSink interface {
+	send(notification cm.Notification) error
+}
+
+type webhookConfig struct {
+	URL string `json:"url"`
+}
+
+// Slack and Mattermost incoming webhooks accept the same payload
+type slackConfig struct {
+	URL      string `json:"url"`
+	Channel  string `json:"channel,omitempty"`
+	Username string `json:"username,omitempty"`
+}
+
+type smtpConfig struct {
+	Host     string   `json:"host"` // host:port
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+}
+
+// Generic JSON webhook, the notification is posted as is
+type webhookSink struct {
+	config webhookConfig
+	client *http.Client
+}
+
+func (sink webhookSink) send(notification cm.Notification) error {
+	payload := struct {
+		Receiver string     `json:"receiver"`
+		Group    string     `json:"group"`
+		Status   string     `json:"status"`
+		Alerts   []cm.Alert `json:"alerts"`
+	}{
+		Receiver: notification.Receiver,
+		Group:    notification.Group,
+		Status:   notificationStatus(notification),
+		Alerts:   notification.Alerts,
+	}
+	return postJSON(sink.client, sink.config.URL, payload)
+}
+
+type slackSink struct {
+	config slackConfig
+	client *http.Client
+}
+
+func (sink slackSink) send(notification cm.Notification) error {
+	payload := struct {
+		Text     string `json:"text"`
+		Channel  string `json:"channel,omitempty"`
+		Username string `json:"username,omitempty"`
+	}{
+		Text:     notificationSubject(notification) + "\n" + notificationBody(notification),
+		Channel:  sink.config.Channel,
+		Username: sink.config.Username,
+	}
+	return postJSON(sink.client, sink.config.URL, payload)
+}
+
+type smtpSink struct {
+	config smtpConfig
+}
+
+func (sink smtpSink) send(notification cm.Notification) error {
+	var auth smtp.Auth
+	if sink.config.Username != "" {
+		host, _, err := net.SplitHostPort(sink.config.Host)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", sink.config.Username, sink.config.Password, host)
+	}
+
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", sink.config.From)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(sink.config.To, ", "))
+	fmt.Fprintf(&message, "Subject: %s\r\n", notificationSubject(notification))
+	fmt.Fprintf(&message, "Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	message.WriteString(strings.ReplaceAll(notificationBody(notification), "\n", "\r\n"))
+
+	return smtp.SendMail(sink.config.Host, auth, sink.config.From, sink.config.To, message.Bytes())
+}
+
+func postJSON(client *http.Client, url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("Unexpected status %v from %v", resp.Status, url)
+	}
+	return nil
+}
+
+// firing as long as one of the alerts still fires
+func notificationStatus(notification cm.Notification) string {
+	for _, alert := range notification.Alerts {
+		if alert.State == AlertFiring {
+			return AlertFiring
+		}
+	}
+	return AlertResolved
+}
+
+func notificationSubject(notification cm.Notification) string {
+	firing := 0
+	for _, alert := range notification.Alerts {
+		if alert.State == AlertFiring {
+			firing++
+		}
+	}
+	return fmt.Sprintf("[%s:%d] %s", strings.ToUpper(notificationStatus(notification)), firing, notification.Group)
+}
+
+func notificationBody(notification cm.Notification) string {
+	var lines []string
+	for _, alert := range notification.Alerts {
+		subject := alert.Subject
+		if alert.Namespace != "" {
+			subject = alert.Namespace + "/" + alert.Subject
+		}
+		lines = append(lines, fmt.Sprintf("[%s] %s %s (%s): %s", strings.ToUpper(alert.State), alert.Rule, subject, alert.Severity, alert.Message))
+	}
+	return strings.Join(lines, "\n")
+}
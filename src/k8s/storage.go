@@ -0,0 +1,322 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	cm "github.com/royroyee/kubem/common"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Percentage of the space or inodes of a volume above which it is reported as full
+var volumeFillThreshold = parseVolumeFillThreshold(cm.GetEnv("KUBEM_VOLUME_FILL_THRESHOLD", "85"))
+
+const (
+	ProblemClaimPending = "Pending"
+	ProblemClaimLost    = "Lost"
+	ProblemVolumeFull   = "VolumeFull"
+
+	statsSummaryTimeout = 5 * time.Second
+)
+
+func parseVolumeFillThreshold(value string) float64 {
+	threshold, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Printf("Invalid KUBEM_VOLUME_FILL_THRESHOLD %v: %v", value, err)
+		return 85
+	}
+	return threshold
+}
+
+// Part of the kubelet stats summary holding the volumes of the pods
+type statsSummary struct {
+	Pods []podStats `json:"pods"`
+}
+
+type podStats struct {
+	Volumes []volumeStats `json:"volume"`
+}
+
+type volumeStats struct {
+	Name           string        `json:"name"`
+	PVCRef         *pvcReference `json:"pvcRef,omitempty"`
+	UsedBytes      *uint64       `json:"usedBytes,omitempty"`
+	AvailableBytes *uint64       `json:"availableBytes,omitempty"`
+	CapacityBytes  *uint64       `json:"capacityBytes,omitempty"`
+	InodesUsed     *uint64       `json:"inodesUsed,omitempty"`
+	InodesFree     *uint64       `json:"inodesFree,omitempty"`
+	Inodes         *uint64       `json:"inodes,omitempty"`
+}
+
+type pvcReference struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
+
+// Claims of the namespace (all namespaces if empty), only those with a problem if problemsOnly is set
+func (kh K8sHandler) GetPVCs(namespace string, problemsOnly bool, page int, perPage int) ([]cm.PVCInfo, error) {
+	result := []cm.PVCInfo{}
+
+	claims, err := kh.pvcInfos(namespace, "")
+	if err != nil {
+		return result, err
+	}
+	for _, claim := range claims {
+		if problemsOnly && claim.Problem == "" {
+			continue
+		}
+		result = append(result, claim)
+	}
+
+	start, end := pageBounds(len(result), page, perPage)
+	return result[start:end], nil
+}
+
+func (kh K8sHandler) NumberOfPVCs(namespace string, problemsOnly bool) (cm.Count, error) {
+	var result cm.Count
+
+	if !problemsOnly {
+		claims, err := kh.K8sClient.CoreV1().PersistentVolumeClaims(namespace).List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			log.Println(err)
+			return result, err
+		}
+		result.Count = len(claims.Items)
+		return result, nil
+	}
+
+	claims, err := kh.pvcInfos(namespace, "")
+	if err != nil {
+		return result, err
+	}
+	for _, claim := range claims {
+		if claim.Problem != "" {
+			result.Count++
+		}
+	}
+	return result, nil
+}
+
+func (kh K8sHandler) GetPVC(namespace string, name string) (cm.PVCInfo, error) {
+	claims, err := kh.pvcInfos(namespace, name)
+	if err != nil {
+		return cm.PVCInfo{}, err
+	}
+	if len(claims) == 0 {
+		return cm.PVCInfo{}, fmt.Errorf("PersistentVolumeClaim %v/%v not found", namespace, name)
+	}
+	return claims[0], nil
+}
+
+// Claims with their consuming pods and volume stats, only the named one if name is not empty
+func (kh K8sHandler) pvcInfos(namespace string, name string) ([]cm.PVCInfo, error) {
+	var result []cm.PVCInfo
+
+	options := metav1.ListOptions{}
+	if name != "" {
+		options.FieldSelector = "metadata.name=" + name
+	}
+	claims, err := kh.K8sClient.CoreV1().PersistentVolumeClaims(namespace).List(context.TODO(), options)
+	if err != nil {
+		log.Println(err)
+		return result, err
+	}
+	pods, err := kh.K8sClient.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		log.Println(err)
+		return result, err
+	}
+
+	// pods of every claim, and the nodes the claims are mounted on
+	consumers := make(map[string][]string)
+	nodes := make(map[string]bool)
+	for _, pod := range pods.Items {
+		for _, volume := range pod.Spec.Volumes {
+			if volume.PersistentVolumeClaim == nil {
+				continue
+			}
+			key := pod.Namespace + "/" + volume.PersistentVolumeClaim.ClaimName
+			consumers[key] = append(consumers[key], pod.Name)
+			if pod.Spec.NodeName != "" && pod.Status.Phase == corev1.PodRunning {
+				nodes[pod.Spec.NodeName] = true
+			}
+		}
+	}
+	usages := kh.volumeUsages(nodes)
+
+	for _, claim := range claims.Items {
+		key := claim.Namespace + "/" + claim.Name
+		info := cm.PVCInfo{
+			Namespace: claim.Namespace,
+			Name:      claim.Name,
+			Status:    string(claim.Status.Phase),
+			Volume:    claim.Spec.VolumeName,
+			Pods:      consumers[key],
+		}
+		if request, ok := claim.Spec.Resources.Requests[corev1.ResourceStorage]; ok {
+			info.Requested = request.String()
+		}
+		if capacity, ok := claim.Status.Capacity[corev1.ResourceStorage]; ok {
+			info.Capacity = capacity.String()
+		}
+		if claim.Spec.StorageClassName != nil {
+			info.StorageClass = *claim.Spec.StorageClassName
+		}
+		for _, mode := range claim.Spec.AccessModes {
+			info.AccessModes = append(info.AccessModes, string(mode))
+		}
+		if usage, ok := usages[key]; ok {
+			info.Usage = &usage
+		}
+
+		switch {
+		case claim.Status.Phase == corev1.ClaimPending:
+			info.Problem = ProblemClaimPending
+			info.Message = kh.pendingClaimMessage(&claim)
+		case claim.Status.Phase == corev1.ClaimLost:
+			info.Problem = ProblemClaimLost
+			info.Message = fmt.Sprintf("volume %s is gone", claim.Spec.VolumeName)
+		case info.Usage != nil && info.Usage.UsedPercent >= volumeFillThreshold:
+			info.Problem = ProblemVolumeFull
+			info.Message = fmt.Sprintf("%.1f%% of the space is used", info.Usage.UsedPercent)
+		case info.Usage != nil && info.Usage.InodesUsedPercent >= volumeFillThreshold:
+			info.Problem = ProblemVolumeFull
+			info.Message = fmt.Sprintf("%.1f%% of the inodes are used", info.Usage.InodesUsedPercent)
+		}
+
+		result = append(result, info)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Namespace+"/"+result[i].Name < result[j].Namespace+"/"+result[j].Name
+	})
+	return result, nil
+}
+
+// Latest event of a pending claim, e.g. why provisioning failed
+func (kh K8sHandler) pendingClaimMessage(claim *corev1.PersistentVolumeClaim) string {
+	events, err := kh.K8sClient.CoreV1().Events(claim.Namespace).List(context.TODO(), metav1.ListOptions{
+		FieldSelector: "involvedObject.kind=PersistentVolumeClaim,involvedObject.name=" + claim.Name,
+	})
+	if err != nil {
+		log.Println(err)
+		return ""
+	}
+
+	message := ""
+	var latest time.Time
+	for i := range events.Items {
+		if timestamp := eventTime(&events.Items[i]); timestamp.After(latest) {
+			latest = timestamp
+			message = events.Items[i].Message
+		}
+	}
+	return message
+}
+
+// Volume stats of the claims mounted on the nodes, from the stats summary of their kubelet.
+// A node whose kubelet is not reachable is skipped
+func (kh K8sHandler) volumeUsages(nodes map[string]bool) map[string]cm.VolumeUsage {
+	result := make(map[string]cm.VolumeUsage)
+
+	for node := range nodes {
+		ctx, cancel := context.WithTimeout(context.Background(), statsSummaryTimeout)
+		data, err := kh.K8sClient.CoreV1().RESTClient().Get().
+			Resource("nodes").Name(node).SubResource("proxy").Suffix("stats/summary").
+			DoRaw(ctx)
+		cancel()
+		if err != nil {
+			log.Printf("Failed to get the stats summary of node %s: %v", node, err)
+			continue
+		}
+
+		var summary statsSummary
+		if err := json.Unmarshal(data, &summary); err != nil {
+			log.Printf("Failed to parse the stats summary of node %s: %v", node, err)
+			continue
+		}
+
+		for _, pod := range summary.Pods {
+			for _, volume := range pod.Volumes {
+				if volume.PVCRef == nil {
+					continue
+				}
+				usage := cm.VolumeUsage{
+					Node:           node,
+					UsedBytes:      statValue(volume.UsedBytes),
+					AvailableBytes: statValue(volume.AvailableBytes),
+					CapacityBytes:  statValue(volume.CapacityBytes),
+					InodesUsed:     statValue(volume.InodesUsed),
+					InodesFree:     statValue(volume.InodesFree),
+					Inodes:         statValue(volume.Inodes),
+				}
+				if usage.CapacityBytes > 0 {
+					usage.UsedPercent = float64(usage.UsedBytes) / float64(usage.CapacityBytes) * 100
+				}
+				if usage.Inodes > 0 {
+					usage.InodesUsedPercent = float64(usage.InodesUsed) / float64(usage.Inodes) * 100
+				}
+				result[volume.PVCRef.Namespace+"/"+volume.PVCRef.Name] = usage
+			}
+		}
+	}
+	return result
+}
+
+func statValue(value *uint64) int64 {
+	if value == nil {
+		return 0
+	}
+	return int64(*value)
+}
+
+func (kh K8sHandler) GetPVs(page int, perPage int) ([]cm.PVInfo, error) {
+	result := []cm.PVInfo{}
+
+	volumes, err := kh.K8sClient.CoreV1().PersistentVolumes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		log.Println(err)
+		return result, err
+	}
+
+	for _, volume := range volumes.Items {
+		info := cm.PVInfo{
+			Name:          volume.Name,
+			Phase:         string(volume.Status.Phase),
+			StorageClass:  volume.Spec.StorageClassName,
+			ReclaimPolicy: string(volume.Spec.PersistentVolumeReclaimPolicy),
+			Reason:        volume.Status.Reason,
+		}
+		if capacity, ok := volume.Spec.Capacity[corev1.ResourceStorage]; ok {
+			info.Capacity = capacity.String()
+		}
+		for _, mode := range volume.Spec.AccessModes {
+			info.AccessModes = append(info.AccessModes, string(mode))
+		}
+		if claim := volume.Spec.ClaimRef; claim != nil {
+			info.Claim = claim.Namespace + "/" + claim.Name
+		}
+		result = append(result, info)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+
+	start, end := pageBounds(len(result), page, perPage)
+	return result[start:end], nil
+}
+
+func (kh K8sHandler) NumberOfPVs() (cm.Count, error) {
+	var result cm.Count
+
+	volumes, err := kh.K8sClient.CoreV1().PersistentVolumes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		log.Println(err)
+		return result, err
+	}
+	result.Count = len(volumes.Items)
+	return result, nil
+}
@@ -0,0 +1,247 @@
+package k8s
+
+import (
+	"fmt"
+	cm "github.com/royroyee/kubem/common"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	maxChangeFields = 20  // fields kept in the diff of a change
+	maxChangeValue  = 200 // characters kept of a changed value
+
+	TimelineChange = "change"
+	TimelineEvent  = "event"
+	TimelineAlert  = "alert"
+)
+
+// Record the spec changes of workloads, ConfigMaps, Services and nodes.
+// Workloads are compared on a generation bump, the others whenever their spec or data differs
+func (kh K8sHandler) TrackChanges() {
+	kh.informers.Apps().V1().Deployments().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			before, ok1 := oldObj.(*appsv1.Deployment)
+			after, ok2 := newObj.(*appsv1.Deployment)
+			if ok1 && ok2 && before.Generation != after.Generation {
+				kh.recordChange("Deployment", after.Namespace, after.Name, after.Generation, before.Spec, after.Spec)
+			}
+		},
+	})
+	kh.informers.Apps().V1().StatefulSets().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			before, ok1 := oldObj.(*appsv1.StatefulSet)
+			after, ok2 := newObj.(*appsv1.StatefulSet)
+			if ok1 && ok2 && before.Generation != after.Generation {
+				kh.recordChange("StatefulSet", after.Namespace, after.Name, after.Generation, before.Spec, after.Spec)
+			}
+		},
+	})
+	kh.informers.Apps().V1().DaemonSets().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			before, ok1 := oldObj.(*appsv1.DaemonSet)
+			after, ok2 := newObj.(*appsv1.DaemonSet)
+			if ok1 && ok2 && before.Generation != after.Generation {
+				kh.recordChange("DaemonSet", after.Namespace, after.Name, after.Generation, before.Spec, after.Spec)
+			}
+		},
+	})
+
+	// ConfigMaps, Services and nodes have no generation
+	kh.informers.Core().V1().ConfigMaps().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			before, ok1 := oldObj.(*corev1.ConfigMap)
+			after, ok2 := newObj.(*corev1.ConfigMap)
+			if ok1 && ok2 && before.ResourceVersion != after.ResourceVersion {
+				kh.recordChange("ConfigMap", after.Namespace, after.Name, 0, configMapData(before), configMapData(after))
+			}
+		},
+	})
+	kh.informers.Core().V1().Services().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			before, ok1 := oldObj.(*corev1.Service)
+			after, ok2 := newObj.(*corev1.Service)
+			if ok1 && ok2 && before.ResourceVersion != after.ResourceVersion {
+				kh.recordChange("Service", after.Namespace, after.Name, 0, before.Spec, after.Spec)
+			}
+		},
+	})
+	// Events expire after an hour in the API server, the Warning events are kept for the timeline
+	kh.informers.Core().V1().Events().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if event, ok := obj.(*corev1.Event); ok {
+				kh.recordWarningEvent(event)
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			if event, ok := newObj.(*corev1.Event); ok {
+				kh.recordWarningEvent(event)
+			}
+		},
+	})
+	kh.informers.Core().V1().Nodes().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			before, ok1 := oldObj.(*corev1.Node)
+			after, ok2 := newObj.(*corev1.Node)
+			// nodes are updated by every heartbeat, only the spec and labels are compared
+			if ok1 && ok2 && before.ResourceVersion != after.ResourceVersion {
+				kh.recordChange("Node", "", after.Name, 0, nodeSpec(before), nodeSpec(after))
+			}
+		},
+	})
+}
+
+func (kh K8sHandler) recordWarningEvent(event *corev1.Event) {
+	if event.Type != corev1.EventTypeWarning {
+		return
+	}
+	kh.StoreWarningEventInDB(cm.WarningEvent{
+		Uid:       string(event.UID),
+		Kind:      event.InvolvedObject.Kind,
+		Namespace: event.InvolvedObject.Namespace,
+		Name:      event.InvolvedObject.Name,
+		Reason:    event.Reason,
+		Message:   event.Message,
+		Count:     event.Count,
+		Timestamp: eventTime(event),
+	})
+}
+
+func configMapData(configMap *corev1.ConfigMap) map[string]interface{} {
+	return map[string]interface{}{
+		"data":       configMap.Data,
+		"binaryData": configMap.BinaryData,
+	}
+}
+
+func nodeSpec(node *corev1.Node) map[string]interface{} {
+	return map[string]interface{}{
+		"labels": node.Labels,
+		"spec":   node.Spec,
+	}
+}
+
+// Store the change if any field differs
+func (kh K8sHandler) recordChange(kind string, namespace string, name string, generation int64, from interface{}, to interface{}) {
+	diff, err := diffObjects(from, to)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if len(diff) == 0 {
+		return
+	}
+
+	change := cm.Change{
+		Kind:       kind,
+		Namespace:  namespace,
+		Name:       name,
+		Generation: generation,
+		Summary:    changeSummary(diff),
+		Timestamp:  time.Now(),
+	}
+	if len(diff) > maxChangeFields {
+		diff = diff[:maxChangeFields]
+		change.Truncated = true
+	}
+	for _, field := range diff {
+		field.From = truncate(field.From, maxChangeValue)
+		field.To = truncate(field.To, maxChangeValue)
+		change.Diff = append(change.Diff, field)
+	}
+
+	kh.StoreChangeInDB(change)
+}
+
+// e.g. "template.spec.containers[0].image, replicas changed" or "5 fields changed under data"
+func changeSummary(diff []cm.FieldChange) string {
+	if len(diff) <= 3 {
+		var paths []string
+		for _, field := range diff {
+			paths = append(paths, field.Path)
+		}
+		return strings.Join(paths, ", ") + " changed"
+	}
+
+	// the top level fields which changed
+	var roots []string
+	for _, field := range diff {
+		root := strings.SplitN(strings.SplitN(field.Path, ".", 2)[0], "[", 2)[0]
+		if !contains(roots, root) {
+			roots = append(roots, root)
+		}
+	}
+	return fmt.Sprintf("%d fields changed under %s", len(diff), strings.Join(roots, ", "))
+}
+
+func truncate(value string, length int) string {
+	if len(value) <= length {
+		return value
+	}
+	return value[:length] + "..."
+}
+
+// Changes, Warning events and alert transitions between from and to, newest first.
+// Only entries of the namespace are returned if it is not empty
+func (kh K8sHandler) GetTimeline(namespace string, from time.Time, to time.Time, page int, perPage int) ([]cm.TimelineEntry, error) {
+	result := []cm.TimelineEntry{}
+
+	changes, err := kh.GetChanges(namespace, from, to)
+	if err != nil {
+		return result, err
+	}
+	for _, change := range changes {
+		result = append(result, cm.TimelineEntry{
+			Time:      change.Timestamp,
+			Source:    TimelineChange,
+			Kind:      change.Kind,
+			Namespace: change.Namespace,
+			Name:      change.Name,
+			Reason:    "SpecChanged",
+			Message:   change.Summary,
+			Diff:      change.Diff,
+		})
+	}
+
+	events, err := kh.GetWarningEvents(namespace, from, to)
+	if err != nil {
+		return result, err
+	}
+	for _, event := range events {
+		result = append(result, cm.TimelineEntry{
+			Time:      event.Timestamp,
+			Source:    TimelineEvent,
+			Kind:      event.Kind,
+			Namespace: event.Namespace,
+			Name:      event.Name,
+			Reason:    event.Reason,
+			Message:   event.Message,
+		})
+	}
+
+	transitions, err := kh.GetAlertTransitions(namespace, from, to)
+	if err != nil {
+		return result, err
+	}
+	for _, transition := range transitions {
+		result = append(result, cm.TimelineEntry{
+			Time:      transition.Timestamp,
+			Source:    TimelineAlert,
+			Kind:      "Alert",
+			Namespace: transition.Namespace,
+			Name:      transition.Subject,
+			Reason:    transition.Rule + " " + transition.State,
+			Message:   transition.Message,
+		})
+	}
+
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Time.After(result[j].Time) })
+
+	start, end := pageBounds(len(result), page, perPage)
+	return result[start:end], nil
+}
@@ -0,0 +1,548 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	cm "github.com/royroyee/kubem/common"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"log"
+	"sort"
+	"strings"
+)
+
+// Health of a topology node
+const (
+	HealthHealthy = "healthy"
+	HealthWarning = "warning"
+	HealthError   = "error"
+	HealthMissing = "missing" // referenced but not found
+	HealthUnknown = "unknown" // kind which is not inspected
+)
+
+// Types of topology edges
+const (
+	EdgeRoutes  = "routes"  // Ingress to Service
+	EdgeSelects = "selects" // Service to Pod
+	EdgeOwns    = "owns"    // controller to the objects it created
+	EdgeMounts  = "mounts"  // Pod to a volume source
+	EdgeUses    = "uses"    // Pod to a ConfigMap or Secret of its environment
+	EdgeScales  = "scales"  // HorizontalPodAutoscaler to its target
+)
+
+type topologyGraph struct {
+	nodes map[string]*cm.TopologyNode
+	edges []cm.TopologyEdge
+	seen  map[string]bool
+}
+
+func newTopologyGraph() *topologyGraph {
+	return &topologyGraph{
+		nodes: make(map[string]*cm.TopologyNode),
+		seen:  make(map[string]bool),
+	}
+}
+
+func topologyId(kind string, namespace string, name string) string {
+	return kind + "/" + namespace + "/" + name
+}
+
+func (graph *topologyGraph) add(kind string, namespace string, name string, status string, message string) {
+	id := topologyId(kind, namespace, name)
+	graph.nodes[id] = &cm.TopologyNode{Id: id, Kind: kind, Namespace: namespace, Name: name, Status: status, Message: message}
+}
+
+// Id of the referenced object, added as missing if it was not found
+func (graph *topologyGraph) reference(kind string, namespace string, name string) string {
+	id := topologyId(kind, namespace, name)
+	if _, ok := graph.nodes[id]; !ok {
+		status := HealthMissing
+		if !inspectedKinds[kind] {
+			status = HealthUnknown
+		}
+		graph.nodes[id] = &cm.TopologyNode{Id: id, Kind: kind, Namespace: namespace, Name: name, Status: status}
+	}
+	return id
+}
+
+func (graph *topologyGraph) link(from string, to string, edgeType string) {
+	key := from + " " + to + " " + edgeType
+	if graph.seen[key] {
+		return
+	}
+	graph.seen[key] = true
+	graph.edges = append(graph.edges, cm.TopologyEdge{From: from, To: to, Type: edgeType})
+}
+
+// Kinds which are listed, a reference to any other kind is not reported as missing
+var inspectedKinds = map[string]bool{
+	"Ingress": true, "Service": true, "Pod": true, "ReplicaSet": true, "Deployment": true, "StatefulSet": true,
+	"DaemonSet": true, "Job": true, "CronJob": true, "HorizontalPodAutoscaler": true,
+	"PersistentVolumeClaim": true, "ConfigMap": true, "Secret": true,
+}
+
+// Dependency graph of the namespace, or only the objects connected to the root ("Kind/name") if it is not empty.
+// A root requires the namespace, the same name may exist in several namespaces
+func (kh K8sHandler) GetTopology(namespace string, root string) (cm.Topology, error) {
+	result := cm.Topology{Nodes: []cm.TopologyNode{}, Edges: []cm.TopologyEdge{}}
+
+	if root != "" && namespace == "" {
+		return result, fmt.Errorf("Namespace is required with root %v", root)
+	}
+
+	graph, err := kh.topologyGraph(namespace)
+	if err != nil {
+		return result, err
+	}
+
+	included := make(map[string]bool)
+	if root == "" {
+		for id := range graph.nodes {
+			included[id] = true
+		}
+	} else {
+		parts := strings.SplitN(root, "/", 2)
+		if len(parts) != 2 {
+			return result, fmt.Errorf("Invalid root %v, expected Kind/name", root)
+		}
+		rootId := ""
+		for id, node := range graph.nodes {
+			if strings.EqualFold(node.Kind, parts[0]) && node.Name == parts[1] {
+				rootId = id
+			}
+		}
+		if rootId == "" {
+			return result, fmt.Errorf("%v not found in namespace %v", root, namespace)
+		}
+		included = connectedNodes(graph, rootId)
+	}
+
+	for id := range included {
+		result.Nodes = append(result.Nodes, *graph.nodes[id])
+	}
+	sort.Slice(result.Nodes, func(i, j int) bool { return result.Nodes[i].Id < result.Nodes[j].Id })
+	for _, edge := range graph.edges {
+		if included[edge.From] && included[edge.To] {
+			result.Edges = append(result.Edges, edge)
+		}
+	}
+	return result, nil
+}
+
+// Nodes reachable from the root in either direction.
+// Volume sources are shared by unrelated workloads so the search does not go through them
+func connectedNodes(graph *topologyGraph, root string) map[string]bool {
+	neighbours := make(map[string][]string)
+	for _, edge := range graph.edges {
+		neighbours[edge.From] = append(neighbours[edge.From], edge.To)
+		neighbours[edge.To] = append(neighbours[edge.To], edge.From)
+	}
+
+	result := map[string]bool{root: true}
+	queue := []string{root}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+
+		switch graph.nodes[id].Kind {
+		case "ConfigMap", "Secret", "PersistentVolumeClaim":
+			if id != root {
+				continue
+			}
+		}
+		for _, next := range neighbours[id] {
+			if !result[next] {
+				result[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+	return result
+}
+
+func (kh K8sHandler) topologyGraph(namespace string) (*topologyGraph, error) {
+	graph := newTopologyGraph()
+	ctx := context.TODO()
+
+	pods, err := kh.K8sClient.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		log.Println(err)
+		return graph, err
+	}
+	services, err := kh.K8sClient.CoreV1().Services(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		log.Println(err)
+		return graph, err
+	}
+	ingresses, err := kh.K8sClient.NetworkingV1().Ingresses(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		log.Println(err)
+		return graph, err
+	}
+	replicaSets, err := kh.K8sClient.AppsV1().ReplicaSets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		log.Println(err)
+		return graph, err
+	}
+	deployments, err := kh.K8sClient.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		log.Println(err)
+		return graph, err
+	}
+	statefulSets, err := kh.K8sClient.AppsV1().StatefulSets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		log.Println(err)
+		return graph, err
+	}
+	daemonSets, err := kh.K8sClient.AppsV1().DaemonSets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		log.Println(err)
+		return graph, err
+	}
+	jobs, err := kh.K8sClient.BatchV1().Jobs(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		log.Println(err)
+		return graph, err
+	}
+	cronJobs, err := kh.K8sClient.BatchV1().CronJobs(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		log.Println(err)
+		return graph, err
+	}
+	autoscalers, err := kh.K8sClient.AutoscalingV2().HorizontalPodAutoscalers(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		log.Println(err)
+		return graph, err
+	}
+	claims, err := kh.K8sClient.CoreV1().PersistentVolumeClaims(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		log.Println(err)
+		return graph, err
+	}
+	configMaps, err := kh.K8sClient.CoreV1().ConfigMaps(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		log.Println(err)
+		return graph, err
+	}
+	// only the names are needed, the data of the Secrets is not fetched
+	secrets, err := kh.metadataClient.Resource(corev1.SchemeGroupVersion.WithResource("secrets")).Namespace(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		log.Println(err)
+		return graph, err
+	}
+
+	// objects first, so that references to anything else are reported as missing
+	for _, pod := range pods.Items {
+		status, message := podHealth(&pod)
+		graph.add("Pod", pod.Namespace, pod.Name, status, message)
+	}
+	for _, service := range services.Items {
+		graph.add("Service", service.Namespace, service.Name, HealthHealthy, string(service.Spec.Type))
+	}
+	scaledDown := make(map[string]bool)
+	for _, replicaSet := range replicaSets.Items {
+		// old ReplicaSets of a Deployment are kept for rollbacks only, they are shown if they still own pods
+		if replicaSet.Status.Replicas == 0 && (replicaSet.Spec.Replicas == nil || *replicaSet.Spec.Replicas == 0) {
+			scaledDown[topologyId("ReplicaSet", replicaSet.Namespace, replicaSet.Name)] = true
+		}
+		status, message := replicaHealth(replicaSet.Spec.Replicas, replicaSet.Status.ReadyReplicas, "ready")
+		graph.add("ReplicaSet", replicaSet.Namespace, replicaSet.Name, status, message)
+	}
+	for _, deployment := range deployments.Items {
+		status, message := replicaHealth(deployment.Spec.Replicas, deployment.Status.AvailableReplicas, "available")
+		if rolloutStatus(&deployment) == RolloutStalled {
+			status, message = HealthError, "rollout stalled"
+		}
+		graph.add("Deployment", deployment.Namespace, deployment.Name, status, message)
+	}
+	for _, statefulSet := range statefulSets.Items {
+		status, message := replicaHealth(statefulSet.Spec.Replicas, statefulSet.Status.ReadyReplicas, "ready")
+		graph.add("StatefulSet", statefulSet.Namespace, statefulSet.Name, status, message)
+	}
+	for _, daemonSet := range daemonSets.Items {
+		desired := daemonSet.Status.DesiredNumberScheduled
+		status, message := replicaHealth(&desired, daemonSet.Status.NumberReady, "ready")
+		graph.add("DaemonSet", daemonSet.Namespace, daemonSet.Name, status, message)
+	}
+	for _, job := range jobs.Items {
+		status, message := jobHealth(&job)
+		graph.add("Job", job.Namespace, job.Name, status, message)
+	}
+	for _, cronJob := range cronJobs.Items {
+		status, message := HealthHealthy, cronJob.Spec.Schedule
+		if cronJob.Spec.Suspend != nil && *cronJob.Spec.Suspend {
+			status, message = HealthWarning, "suspended"
+		}
+		graph.add("CronJob", cronJob.Namespace, cronJob.Name, status, message)
+	}
+	for _, autoscaler := range autoscalers.Items {
+		status, message := autoscalerHealth(&autoscaler)
+		graph.add("HorizontalPodAutoscaler", autoscaler.Namespace, autoscaler.Name, status, message)
+	}
+	for _, claim := range claims.Items {
+		status := HealthHealthy
+		switch claim.Status.Phase {
+		case corev1.ClaimPending:
+			status = HealthWarning
+		case corev1.ClaimLost:
+			status = HealthError
+		}
+		graph.add("PersistentVolumeClaim", claim.Namespace, claim.Name, status, string(claim.Status.Phase))
+	}
+	for _, configMap := range configMaps.Items {
+		graph.add("ConfigMap", configMap.Namespace, configMap.Name, HealthHealthy, "")
+	}
+	for _, secret := range secrets.Items {
+		graph.add("Secret", secret.Namespace, secret.Name, HealthHealthy, "")
+	}
+
+	// ownership, from the controller reference of every object
+	owned := []metav1.Object{}
+	for i := range pods.Items {
+		owned = append(owned, &pods.Items[i])
+	}
+	for i := range replicaSets.Items {
+		owned = append(owned, &replicaSets.Items[i])
+	}
+	for i := range jobs.Items {
+		owned = append(owned, &jobs.Items[i])
+	}
+	for _, object := range owned {
+		owner := metav1.GetControllerOfNoCopy(object)
+		if owner == nil {
+			continue
+		}
+		kind := "Pod"
+		switch object.(type) {
+		case *appsv1.ReplicaSet:
+			kind = "ReplicaSet"
+		case *batchv1.Job:
+			kind = "Job"
+		}
+		id := topologyId(kind, object.GetNamespace(), object.GetName())
+		if _, ok := graph.nodes[id]; !ok {
+			continue
+		}
+		graph.link(graph.reference(owner.Kind, object.GetNamespace(), owner.Name), id, EdgeOwns)
+	}
+	for id := range scaledDown {
+		if !hasEdgeFrom(graph, id) {
+			removeNode(graph, id)
+		}
+	}
+
+	// services and the pods they select
+	for _, service := range services.Items {
+		serviceId := topologyId("Service", service.Namespace, service.Name)
+		if len(service.Spec.Selector) == 0 {
+			continue
+		}
+		selector := labels.SelectorFromSet(service.Spec.Selector)
+		selected, ready := 0, 0
+		for _, pod := range pods.Items {
+			if !selector.Matches(labels.Set(pod.Labels)) {
+				continue
+			}
+			graph.link(serviceId, topologyId("Pod", pod.Namespace, pod.Name), EdgeSelects)
+			selected++
+			if isPodReady(&pod) {
+				ready++
+			}
+		}
+		node := graph.nodes[serviceId]
+		switch {
+		case selected == 0:
+			node.Status, node.Message = HealthWarning, "no pods selected"
+		case ready == 0:
+			node.Status, node.Message = HealthError, "no ready endpoints"
+		default:
+			node.Message = fmt.Sprintf("%d/%d endpoints ready", ready, selected)
+		}
+	}
+
+	for _, ingress := range ingresses.Items {
+		var backends []string
+		if backend := ingress.Spec.DefaultBackend; backend != nil && backend.Service != nil {
+			backends = append(backends, backend.Service.Name)
+		}
+		for _, rule := range ingress.Spec.Rules {
+			if rule.HTTP == nil {
+				continue
+			}
+			for _, path := range rule.HTTP.Paths {
+				if path.Backend.Service != nil {
+					backends = append(backends, path.Backend.Service.Name)
+				}
+			}
+		}
+
+		status, message := HealthHealthy, ""
+		ingressId := topologyId("Ingress", ingress.Namespace, ingress.Name)
+		for _, backend := range backends {
+			serviceId := graph.reference("Service", ingress.Namespace, backend)
+			graph.link(ingressId, serviceId, EdgeRoutes)
+			if graph.nodes[serviceId].Status == HealthMissing {
+				status, message = HealthError, fmt.Sprintf("backend service %s not found", backend)
+			}
+		}
+		graph.add("Ingress", ingress.Namespace, ingress.Name, status, message)
+	}
+
+	for _, autoscaler := range autoscalers.Items {
+		target := autoscaler.Spec.ScaleTargetRef
+		autoscalerId := topologyId("HorizontalPodAutoscaler", autoscaler.Namespace, autoscaler.Name)
+		targetId := graph.reference(target.Kind, autoscaler.Namespace, target.Name)
+		graph.link(autoscalerId, targetId, EdgeScales)
+		if graph.nodes[targetId].Status == HealthMissing {
+			node := graph.nodes[autoscalerId]
+			node.Status, node.Message = HealthError, fmt.Sprintf("target %s/%s not found", target.Kind, target.Name)
+		}
+	}
+
+	// volumes and environment of the pods
+	for _, pod := range pods.Items {
+		podId := topologyId("Pod", pod.Namespace, pod.Name)
+		for _, volume := range pod.Spec.Volumes {
+			switch {
+			case volume.ConfigMap != nil:
+				graph.link(podId, graph.reference("ConfigMap", pod.Namespace, volume.ConfigMap.Name), EdgeMounts)
+			case volume.Secret != nil:
+				graph.link(podId, graph.reference("Secret", pod.Namespace, volume.Secret.SecretName), EdgeMounts)
+			case volume.PersistentVolumeClaim != nil:
+				graph.link(podId, graph.reference("PersistentVolumeClaim", pod.Namespace, volume.PersistentVolumeClaim.ClaimName), EdgeMounts)
+			case volume.Projected != nil:
+				for _, source := range volume.Projected.Sources {
+					if source.ConfigMap != nil {
+						graph.link(podId, graph.reference("ConfigMap", pod.Namespace, source.ConfigMap.Name), EdgeMounts)
+					}
+					if source.Secret != nil {
+						graph.link(podId, graph.reference("Secret", pod.Namespace, source.Secret.Name), EdgeMounts)
+					}
+				}
+			}
+		}
+
+		containers := append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+		for _, container := range containers {
+			for _, source := range container.EnvFrom {
+				if source.ConfigMapRef != nil {
+					graph.link(podId, graph.reference("ConfigMap", pod.Namespace, source.ConfigMapRef.Name), EdgeUses)
+				}
+				if source.SecretRef != nil {
+					graph.link(podId, graph.reference("Secret", pod.Namespace, source.SecretRef.Name), EdgeUses)
+				}
+			}
+			for _, env := range container.Env {
+				if env.ValueFrom == nil {
+					continue
+				}
+				if ref := env.ValueFrom.ConfigMapKeyRef; ref != nil {
+					graph.link(podId, graph.reference("ConfigMap", pod.Namespace, ref.Name), EdgeUses)
+				}
+				if ref := env.ValueFrom.SecretKeyRef; ref != nil {
+					graph.link(podId, graph.reference("Secret", pod.Namespace, ref.Name), EdgeUses)
+				}
+			}
+		}
+	}
+
+	return graph, nil
+}
+
+func hasEdgeFrom(graph *topologyGraph, id string) bool {
+	for _, edge := range graph.edges {
+		if edge.From == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Remove the node and its edges
+func removeNode(graph *topologyGraph, id string) {
+	delete(graph.nodes, id)
+	var edges []cm.TopologyEdge
+	for _, edge := range graph.edges {
+		if edge.From != id && edge.To != id {
+			edges = append(edges, edge)
+		}
+	}
+	graph.edges = edges
+}
+
+func podHealth(pod *corev1.Pod) (string, string) {
+	if problem, ok := classifyPod(pod); ok {
+		return HealthError, problem.Reason
+	}
+	switch pod.Status.Phase {
+	case corev1.PodPending:
+		return HealthWarning, string(corev1.PodPending)
+	case corev1.PodSucceeded:
+		return HealthHealthy, "Completed"
+	}
+	if !isPodReady(pod) {
+		return HealthWarning, "not ready"
+	}
+	return HealthHealthy, string(pod.Status.Phase)
+}
+
+func isPodReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func replicaHealth(desired *int32, ready int32, state string) (string, string) {
+	replicas := int32(1)
+	if desired != nil {
+		replicas = *desired
+	}
+	message := fmt.Sprintf("%d/%d %s", ready, replicas, state)
+	switch {
+	case replicas > 0 && ready == 0:
+		return HealthError, message
+	case ready < replicas:
+		return HealthWarning, message
+	}
+	return HealthHealthy, message
+}
+
+func jobHealth(job *batchv1.Job) (string, string) {
+	for _, condition := range job.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch condition.Type {
+		case batchv1.JobFailed:
+			return HealthError, condition.Reason
+		case batchv1.JobComplete:
+			return HealthHealthy, "Complete"
+		}
+	}
+	if job.Status.Failed > 0 {
+		return HealthWarning, fmt.Sprintf("%d failed pods", job.Status.Failed)
+	}
+	return HealthHealthy, fmt.Sprintf("%d active", job.Status.Active)
+}
+
+func autoscalerHealth(autoscaler *autoscalingv2.HorizontalPodAutoscaler) (string, string) {
+	for _, condition := range autoscaler.Status.Conditions {
+		if condition.Status != corev1.ConditionFalse {
+			continue
+		}
+		switch condition.Type {
+		case autoscalingv2.AbleToScale:
+			return HealthError, condition.Message
+		case autoscalingv2.ScalingActive:
+			return HealthWarning, condition.Message
+		}
+	}
+	return HealthHealthy, fmt.Sprintf("%d/%d replicas", autoscaler.Status.CurrentReplicas, autoscaler.Status.DesiredReplicas)
+}
@@ -2,11 +2,18 @@ package k8s
 
 import (
 	"context"
+	"fmt"
+	cm "github.com/royroyee/kubem/common"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"log"
+	"sort"
+	"time"
 )
 
+// Namespace of the Lease objects which kubelets renew as their heartbeat
+const nodeLeaseNamespace = "kube-node-lease"
+
 func (kh K8sHandler) nodeStatus() (ready []string, notReady []string, err error) {
 
 	nodeList, err := kh.K8sClient.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
@@ -19,23 +26,28 @@ func (kh K8sHandler) nodeStatus() (ready []string, notReady []string, err error)
 		if isNodeReady(&node) {
 			ready = append(ready, node.GetName())
 		} else {
-			notReady = append(ready, node.GetName())
+			notReady = append(notReady, node.GetName())
 		}
 	}
 	return ready, notReady, nil
 }
 
-func (kh K8sHandler) podStatus() (running int, pending []string, errorStatus []string, err error) {
+func (kh K8sHandler) podStatus() (running int, pending []string, errorStatus []string, problems []cm.PodProblemGroup, err error) {
 
 	running = 0
 
 	podList, err := kh.K8sClient.CoreV1().Pods(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
 	if err != nil {
 		log.Println(err)
-		return running, pending, errorStatus, err
+		return running, pending, errorStatus, problems, err
 	}
 
+	var podProblems []cm.PodProblem
 	for _, pod := range podList.Items {
+		if problem, ok := classifyPod(&pod); ok {
+			podProblems = append(podProblems, problem)
+		}
+
 		switch pod.Status.Phase {
 		case corev1.PodPending:
 			pending = append(pending, pod.Name)
@@ -49,7 +61,7 @@ func (kh K8sHandler) podStatus() (running int, pending []string, errorStatus []s
 			errorStatus = append(errorStatus, pod.Name)
 		}
 	}
-	return running, pending, errorStatus, nil
+	return running, pending, errorStatus, groupPodProblems(podProblems), nil
 }
 
 func isNodeReady(node *corev1.Node) bool {
@@ -62,12 +74,50 @@ func isNodeReady(node *corev1.Node) bool {
 }
 
 func NodeStatus(node *corev1.Node) string {
+	if isNodeReady(node) {
+		return "Ready"
+	}
+	return "Not Ready"
+}
+
+// Every condition reported by the node (Ready, MemoryPressure, DiskPressure, PIDPressure, NetworkUnavailable)
+func nodeConditions(node *corev1.Node) []cm.NodeCondition {
+	var result []cm.NodeCondition
 	for _, condition := range node.Status.Conditions {
-		if condition.Type == corev1.NodeReady {
-			return "Ready"
+		result = append(result, cm.NodeCondition{
+			Type:               string(condition.Type),
+			Status:             string(condition.Status),
+			Reason:             condition.Reason,
+			Message:            condition.Message,
+			LastTransitionTime: condition.LastTransitionTime.Time.Format("2006-01-02 15:04"),
+		})
+	}
+	return result
+}
+
+func nodeTaints(node *corev1.Node) []string {
+	var result []string
+	for _, taint := range node.Spec.Taints {
+		if taint.Value == "" {
+			result = append(result, fmt.Sprintf("%s:%s", taint.Key, taint.Effect))
+		} else {
+			result = append(result, fmt.Sprintf("%s=%s:%s", taint.Key, taint.Value, taint.Effect))
 		}
 	}
-	return "Not Ready"
+	return result
+}
+
+// Seconds since the kubelet last renewed its node lease, -1 if the lease cannot be read
+func (kh K8sHandler) nodeHeartbeatAge(nodeName string) int64 {
+	lease, err := kh.K8sClient.CoordinationV1().Leases(nodeLeaseNamespace).Get(context.TODO(), nodeName, metav1.GetOptions{})
+	if err != nil {
+		log.Println(err)
+		return -1
+	}
+	if lease.Spec.RenewTime == nil {
+		return -1
+	}
+	return int64(time.Since(lease.Spec.RenewTime.Time).Seconds())
 }
 
 func (kh K8sHandler) GetNamespaceName() ([]string, error) {
@@ -85,3 +135,148 @@ func (kh K8sHandler) GetNamespaceName() ([]string, error) {
 
 	return result, nil
 }
+
+// Effective requests and limits of a pod : the sum over its containers, or the largest init container if that is higher
+func podResources(pod *corev1.Pod) (requests corev1.ResourceList, limits corev1.ResourceList) {
+	requests = corev1.ResourceList{}
+	limits = corev1.ResourceList{}
+
+	for _, container := range pod.Spec.Containers {
+		addResources(requests, container.Resources.Requests)
+		addResources(limits, container.Resources.Limits)
+	}
+	for _, container := range pod.Spec.InitContainers {
+		maxResources(requests, container.Resources.Requests)
+		maxResources(limits, container.Resources.Limits)
+	}
+	return requests, limits
+}
+
+func podResource(pod *corev1.Pod, usage corev1.ResourceList) cm.PodResource {
+	requests, limits := podResources(pod)
+
+	return cm.PodResource{
+		Name:        pod.Name,
+		Namespace:   pod.Namespace,
+		Phase:       string(pod.Status.Phase),
+		Owner:       podOwner(pod),
+		Restarts:    podRestarts(pod),
+		CpuRequests: requests.Cpu().MilliValue(),
+		RamRequests: requests.Memory().Value() / 1024 / 1024,
+		CpuLimits:   limits.Cpu().MilliValue(),
+		RamLimits:   limits.Memory().Value() / 1024 / 1024,
+		CpuUsage:    usage.Cpu().MilliValue(),
+		RamUsage:    usage.Memory().Value() / 1024 / 1024,
+	}
+}
+
+func addResources(total corev1.ResourceList, add corev1.ResourceList) {
+	for name, quantity := range add {
+		if current, ok := total[name]; ok {
+			current.Add(quantity)
+			total[name] = current
+		} else {
+			total[name] = quantity.DeepCopy()
+		}
+	}
+}
+
+func maxResources(total corev1.ResourceList, other corev1.ResourceList) {
+	for name, quantity := range other {
+		if current, ok := total[name]; !ok || quantity.Cmp(current) > 0 {
+			total[name] = quantity.DeepCopy()
+		}
+	}
+}
+
+func podRestarts(pod *corev1.Pod) int32 {
+	var restarts int32
+	for _, status := range pod.Status.ContainerStatuses {
+		restarts += status.RestartCount
+	}
+	return restarts
+}
+
+// Kind/Name of the controller owning the pod
+func podOwner(pod *corev1.Pod) string {
+	for _, owner := range pod.OwnerReferences {
+		if owner.Controller != nil && *owner.Controller {
+			return fmt.Sprintf("%s/%s", owner.Kind, owner.Name)
+		}
+	}
+	if len(pod.OwnerReferences) > 0 {
+		return fmt.Sprintf("%s/%s", pod.OwnerReferences[0].Kind, pod.OwnerReferences[0].Name)
+	}
+	return ""
+}
+
+// Current usage of every pod in the namespace from the metrics API, keyed by namespace/name
+func (kh K8sHandler) podUsages(namespace string) (map[string]corev1.ResourceList, error) {
+	result := make(map[string]corev1.ResourceList)
+
+	podMetrics, err := kh.MetricK8sClient.MetricsV1beta1().PodMetricses(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return result, err
+	}
+
+	for _, podMetric := range podMetrics.Items {
+		usage := corev1.ResourceList{}
+		for _, container := range podMetric.Containers {
+			addResources(usage, container.Usage)
+		}
+		result[podMetric.Namespace+"/"+podMetric.Name] = usage
+	}
+	return result, nil
+}
+
+func sortPodResources(pods []cm.PodResource, sortBy string, desc bool) error {
+	var less func(a, b cm.PodResource) bool
+
+	switch sortBy {
+	case "", "name":
+		less = func(a, b cm.PodResource) bool { return a.Name < b.Name }
+	case "namespace":
+		less = func(a, b cm.PodResource) bool { return a.Namespace < b.Namespace }
+	case "phase":
+		less = func(a, b cm.PodResource) bool { return a.Phase < b.Phase }
+	case "owner":
+		less = func(a, b cm.PodResource) bool { return a.Owner < b.Owner }
+	case "restarts":
+		less = func(a, b cm.PodResource) bool { return a.Restarts < b.Restarts }
+	case "cpu_requests":
+		less = func(a, b cm.PodResource) bool { return a.CpuRequests < b.CpuRequests }
+	case "ram_requests":
+		less = func(a, b cm.PodResource) bool { return a.RamRequests < b.RamRequests }
+	case "cpu_limits":
+		less = func(a, b cm.PodResource) bool { return a.CpuLimits < b.CpuLimits }
+	case "ram_limits":
+		less = func(a, b cm.PodResource) bool { return a.RamLimits < b.RamLimits }
+	case "cpu_usage":
+		less = func(a, b cm.PodResource) bool { return a.CpuUsage < b.CpuUsage }
+	case "ram_usage":
+		less = func(a, b cm.PodResource) bool { return a.RamUsage < b.RamUsage }
+	default:
+		return fmt.Errorf("Invalid Sort Column %v", sortBy)
+	}
+
+	sort.SliceStable(pods, func(i, j int) bool {
+		if desc {
+			return less(pods[j], pods[i])
+		}
+		return less(pods[i], pods[j])
+	})
+	return nil
+}
+
+// Bounds of a page of a slice of the given length, empty if the page is out of range
+func pageBounds(length int, page int, perPage int) (start int, end int) {
+	start = (page - 1) * perPage
+	if start < 0 || start >= length || perPage <= 0 {
+		return 0, 0
+	}
+	end = start + perPage
+	if end > length {
+		end = length
+	}
+	return start, end
+}
@@ -26,8 +26,16 @@ func main() {
 	// Handlers
 	initHandlers()
 
+	// Informer caches are used by the alert rules, event handlers must be added before they start
+	handlers.k8sHandler.TrackRollouts()
+	handlers.k8sHandler.TrackChanges()
+	handlers.k8sHandler.TrackAutoscalers()
+	handlers.k8sHandler.TrackJobs()
+	handlers.k8sHandler.TrackNodeConditions()
+	handlers.k8sHandler.StartInformers()
+
 	var wg sync.WaitGroup
-	wg.Add(3)
+	wg.Add(8)
 
 	// Start DB Session
 	go handlers.k8sHandler.DBSession()
@@ -37,6 +45,16 @@ func main() {
 
 	go handlers.k8sHandler.WatchEvents()
 
+	go handlers.k8sHandler.RecordCapacity()
+
+	go handlers.k8sHandler.RecordNamespaceUsage()
+
+	go handlers.k8sHandler.RecordPodUsage()
+
+	go handlers.k8sHandler.EvaluateAlerts()
+
+	go handlers.k8sHandler.SendNotifications()
+
 	wg.Wait()
 	log.Println("kubem  finished. Bye.")
 }